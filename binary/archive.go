@@ -0,0 +1,133 @@
+package binary
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+
+	"github.com/Ferlab-Ste-Justine/ferio/etcd"
+)
+
+const DEFAULT_ARCHIVE_PATH = "minio"
+
+/*
+Returns a reader over the tar archive's file at the given path.
+*/
+func getTarEntryReader(reader io.Reader, entryPath string) (io.Reader, error) {
+	tarReader := tar.NewReader(reader)
+	for true {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error reading tar archive: %s", err.Error()))
+		}
+
+		if header.Typeflag == tar.TypeReg && path.Clean(strings.TrimPrefix(header.Name, "/")) == path.Clean(strings.TrimPrefix(entryPath, "/")) {
+			return tarReader, nil
+		}
+	}
+
+	return nil, errors.New(fmt.Sprintf("File %s not found in tar archive", entryPath))
+}
+
+/*
+Returns a reader that decompresses the archive of the release on the fly.
+The returned closer must be called once reading is done.
+*/
+func getArchiveReader(reader io.Reader, rel *etcd.MinioRelease) (io.Reader, func(), error) {
+	switch rel.Archive {
+	case etcd.ARCHIVE_GZIP:
+		gzReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return gzReader, func() { gzReader.Close() }, nil
+	case etcd.ARCHIVE_XZ:
+		xzReader, err := xz.NewReader(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return xzReader, func() {}, nil
+	case etcd.ARCHIVE_ZSTD:
+		zstdReader, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return zstdReader, zstdReader.Close, nil
+	case etcd.ARCHIVE_TAR_GZ:
+		gzReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		entryPath := rel.ArchivePath
+		if entryPath == "" {
+			entryPath = DEFAULT_ARCHIVE_PATH
+		}
+
+		entryReader, entryErr := getTarEntryReader(gzReader, entryPath)
+		if entryErr != nil {
+			gzReader.Close()
+			return nil, nil, entryErr
+		}
+
+		return entryReader, func() { gzReader.Close() }, nil
+	}
+
+	return nil, nil, errors.New(fmt.Sprintf("Archive format %s is not supported", rel.Archive))
+}
+
+/*
+Extracts the minio binary from the archive of the release, decompressing it as it is written.
+The binary is written with executable permissions and synced to disk.
+*/
+func extractBinary(archivePath string, binPath string, rel *etcd.MinioRelease) error {
+	archive, openErr := os.Open(archivePath)
+	if openErr != nil {
+		return errors.New(fmt.Sprintf("Error opening minio archive: %s", openErr.Error()))
+	}
+	defer archive.Close()
+
+	reader, closeReader, readerErr := getArchiveReader(archive, rel)
+	if readerErr != nil {
+		return errors.New(fmt.Sprintf("Error reading minio archive: %s", readerErr.Error()))
+	}
+	defer closeReader()
+
+	fsWr, fsErr := os.OpenFile(binPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if fsErr != nil {
+		return errors.New(fmt.Sprintf("Error opening file to extract minio binary: %s", fsErr.Error()))
+	}
+	defer fsWr.Close()
+
+	_, cpErr := io.Copy(fsWr, reader)
+	if cpErr != nil {
+		return errors.New(fmt.Sprintf("Error extracting minio binary from archive: %s", cpErr.Error()))
+	}
+
+	chmodErr := fsWr.Chmod(0755)
+	if chmodErr != nil {
+		return errors.New(fmt.Sprintf("Error setting minio binary permissions: %s", chmodErr.Error()))
+	}
+
+	syncErr := fsWr.Sync()
+	if syncErr != nil {
+		return errors.New(fmt.Sprintf("Error syncing extracted minio binary: %s", syncErr.Error()))
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package binary
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+
+	"github.com/Ferlab-Ste-Justine/ferio/etcd"
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+)
+
+func getTestArchive(t *testing.T, archive string, content []byte) []byte {
+	var buf bytes.Buffer
+	switch archive {
+	case etcd.ARCHIVE_GZIP:
+		wr := gzip.NewWriter(&buf)
+		wr.Write(content)
+		wr.Close()
+	case etcd.ARCHIVE_XZ:
+		wr, _ := xz.NewWriter(&buf)
+		wr.Write(content)
+		wr.Close()
+	case etcd.ARCHIVE_ZSTD:
+		wr, _ := zstd.NewWriter(&buf)
+		wr.Write(content)
+		wr.Close()
+	case etcd.ARCHIVE_TAR_GZ:
+		gzWr := gzip.NewWriter(&buf)
+		tarWr := tar.NewWriter(gzWr)
+		for name, fileContent := range map[string][]byte{"./minio-release/README": []byte("readme"), "./minio-release/minio": content} {
+			tarWr.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(fileContent)), Typeflag: tar.TypeReg})
+			tarWr.Write(fileContent)
+		}
+		tarWr.Close()
+		gzWr.Close()
+	default:
+		t.Fatalf("Unsupported test archive format %s", archive)
+	}
+
+	return buf.Bytes()
+}
+
+func TestGetBinaryArchives(t *testing.T) {
+	log := logger.Logger{LogLevel: logger.ERROR}
+	content := bytes.Repeat([]byte("minio"), 1000)
+
+	for _, archive := range []string{etcd.ARCHIVE_GZIP, etcd.ARCHIVE_XZ, etcd.ARCHIVE_ZSTD, etcd.ARCHIVE_TAR_GZ} {
+		archiveContent := getTestArchive(t, archive, content)
+		srv := &testServer{content: archiveContent}
+		httpSrv := httptest.NewServer(srv)
+		defer httpSrv.Close()
+
+		for _, target := range []string{etcd.CHECKSUM_TARGET_ARCHIVE, etcd.CHECKSUM_TARGET_BINARY} {
+			checksum := getTestSha(archiveContent)
+			if target == etcd.CHECKSUM_TARGET_BINARY {
+				checksum = getTestSha(content)
+			}
+
+			binDir := t.TempDir()
+			rel := etcd.MinioRelease{
+				Version:        "v1",
+				Url:            httpSrv.URL,
+				Checksum:       checksum,
+				Archive:        archive,
+				ChecksumTarget: target,
+			}
+			if archive == etcd.ARCHIVE_TAR_GZ {
+				rel.ArchivePath = "minio-release/minio"
+			}
+
+			err := GetBinary(&rel, binDir, getTestDownloadConfig(), log)
+			if err != nil {
+				t.Errorf("Error occured getting %s binary with a checksum over the %s: %s", archive, target, err.Error())
+				continue
+			}
+
+			binPath := GetMinioPathFromVersion(binDir, "v1")
+			extracted, _ := os.ReadFile(binPath)
+			if !bytes.Equal(extracted, content) {
+				t.Errorf("Expected binary extracted from %s archive to match the original content and it didn't", archive)
+			}
+
+			info, statErr := os.Stat(binPath)
+			if statErr != nil || info.Mode().Perm()&0111 == 0 {
+				t.Errorf("Expected binary extracted from %s archive to be executable and it wasn't", archive)
+			}
+
+			requests := len(srv.requests)
+			err = GetBinary(&rel, binDir, getTestDownloadConfig(), log)
+			if err != nil || len(srv.requests) != requests {
+				t.Errorf("Expected already extracted %s binary not to be downloaded again", archive)
+			}
+
+			rel.Version = "v2"
+			rel.Checksum = getTestSha([]byte("other"))
+			err = GetBinary(&rel, binDir, getTestDownloadConfig(), log)
+			if err == nil {
+				t.Errorf("Expected %s binary with a bad checksum over the %s to be rejected and it wasn't", archive, target)
+			}
+
+			_, statErr = os.Stat(GetMinioPathFromVersion(binDir, "v2"))
+			if !os.IsNotExist(statErr) {
+				t.Errorf("Expected %s binary with a bad checksum over the %s not to be installed and it was", archive, target)
+			}
+		}
+	}
+}
+
+func TestGetBinaryArchiveMissingPath(t *testing.T) {
+	log := logger.Logger{LogLevel: logger.ERROR}
+	archiveContent := getTestArchive(t, etcd.ARCHIVE_TAR_GZ, []byte("minio"))
+	httpSrv := httptest.NewServer(&testServer{content: archiveContent})
+	defer httpSrv.Close()
+
+	binDir := t.TempDir()
+	rel := etcd.MinioRelease{
+		Version:  "v1",
+		Url:      httpSrv.URL,
+		Checksum: getTestSha(archiveContent),
+		Archive:  etcd.ARCHIVE_TAR_GZ,
+	}
+
+	err := GetBinary(&rel, binDir, getTestDownloadConfig(), log)
+	if err == nil {
+		t.Errorf("Expected getting a binary missing from its tar.gz archive to fail and it didn't")
+	}
+}
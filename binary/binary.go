@@ -3,100 +3,181 @@ package binary
 import (
 	"errors"
 	"fmt"
-	"io"
-    "net/http"
+	"net/http"
 	"os"
 	"path"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/Ferlab-Ste-Justine/ferio/etcd"
 	"github.com/Ferlab-Ste-Justine/ferio/fs"
 	"github.com/Ferlab-Ste-Justine/ferio/logger"
+	"github.com/Ferlab-Ste-Justine/ferio/metrics"
 )
 
-func downloadBinary(binaryUrl string, binaryPath string, retries int) error {
-	cli := http.Client{}
-	resClosed := false
-	fsClosed := false
+func getBinaryFromUrl(cli *http.Client, binaryUrl string, rel *etcd.MinioRelease, sum Checksum, partPath string, conf DownloadConfig, log logger.Logger) error {
+	log.Infof("[binary] Downloading binary version %s from url %s", rel.Version, binaryUrl)
 
-	res, getErr := cli.Get(binaryUrl)
-	if getErr != nil {
-		if retries > 0 {
-			return downloadBinary(binaryUrl, binaryPath, retries - 1)
-		}
+	dlStart := time.Now()
+	written, dlErr := downloadBinary(cli, binaryUrl, partPath, conf, log)
+	if dlErr != nil {
+		metrics.IncDownloadFailures()
+		return dlErr
+	}
+	metrics.ObserveDownload(time.Since(dlStart), written)
 
-		return errors.New(fmt.Sprintf("Error downloading minio: %s", getErr.Error()))
+	if rel.IsBinaryChecksum() {
+		return nil
 	}
-	defer func() {
-		if !resClosed{
-			res.Body.Close()
-		}
-	}()
-	
-	if res.StatusCode >= 400 {
-		if retries > 0 {
-			res.Body.Close()
-			resClosed = true
-			return downloadBinary(binaryUrl, binaryPath, retries - 1)
+
+	binHash, binHashErr := sum.GetFileHash(partPath)
+	if binHashErr != nil {
+		return errors.New(fmt.Sprintf("Error reading downloaded binary to check checksum: %s", binHashErr.Error()))
+	}
+
+	if binHash != sum.Hash {
+		removeErr := os.Remove(partPath)
+		if removeErr != nil {
+			return errors.New(fmt.Sprintf("Error removing minio download with bad checksum: %s", removeErr.Error()))
 		}
 
-		return errors.New(fmt.Sprintf("Error downloading minio: Server returned error code %d", res.StatusCode))
+		return errors.New(fmt.Sprintf("Error downloaded binary checksum did not match expected value: %s != %s", binHash, sum.Hash))
+	}
+
+	return nil
+}
+
+/*
+Extracts the binary of a downloaded archive and verifies its checksum if the checksum of the release is over the binary.
+The archive is kept next to the binary so that its checksum and signature can be verified again later on.
+*/
+func installArchive(rel *etcd.MinioRelease, sum Checksum, partPath string, archivePath string, binPath string) error {
+	renameErr := fs.AtomicRename(partPath, archivePath)
+	if renameErr != nil {
+		return renameErr
 	}
 
-	fsWr, fsErr := os.OpenFile(binaryPath, os.O_CREATE|os.O_WRONLY, 0755)
-	if fsErr != nil {
-		return errors.New(fmt.Sprintf("Error opening writable binary file to download minio: %s", fsErr.Error()))
+	binPartPath := binPath + ".part"
+	extractErr := extractBinary(archivePath, binPartPath, rel)
+	if extractErr != nil {
+		os.Remove(binPartPath)
+		os.Remove(archivePath)
+		return extractErr
 	}
-	defer func() {
-		if !fsClosed{
-			fsWr.Close()
+
+	if rel.IsBinaryChecksum() {
+		binHash, binHashErr := sum.GetFileHash(binPartPath)
+		if binHashErr != nil {
+			return errors.New(fmt.Sprintf("Error reading extracted binary to check checksum: %s", binHashErr.Error()))
 		}
-	}()
-
-	_, cpErr := io.Copy(fsWr, res.Body)
-	if cpErr != nil {
-		if retries > 0 {
-			res.Body.Close()
-			resClosed = true
-			fsWr.Close()
-			fsClosed = true
-			return downloadBinary(binaryUrl, binaryPath, retries - 1)
+
+		if binHash != sum.Hash {
+			os.Remove(binPartPath)
+			os.Remove(archivePath)
+			return errors.New(fmt.Sprintf("Error extracted binary checksum did not match expected value: %s != %s", binHash, sum.Hash))
 		}
+	}
+
+	return fs.AtomicRename(binPartPath, binPath)
+}
+
+var versionLocks = map[string]*sync.Mutex{}
+var versionLocksMutex sync.Mutex
 
-		return errors.New(fmt.Sprintf("Error downloading minio in writable file: %s", cpErr.Error()))
+/*
+Returns the lock of a version directory of the binaries directory.
+It serializes the downloads and cleanup of a version, so that a prefetch running in the background and an update of the same release do not write the same files concurrently.
+*/
+func getVersionLock(binDir string) *sync.Mutex {
+	versionLocksMutex.Lock()
+	defer versionLocksMutex.Unlock()
+
+	lock, ok := versionLocks[binDir]
+	if !ok {
+		lock = &sync.Mutex{}
+		versionLocks[binDir] = lock
 	}
 
-	return nil
+	return lock
 }
 
 func GetMinioPathFromVersion(binariesDir string, minioVersion string) string {
 	return path.Join(binariesDir, minioVersion, "minio")
 }
 
-func GetBinary(minioUrl string, minioVersion string, expectedSha string, binariesDir string, log logger.Logger) error {
-	log.Infof("[binary] Downloading binary version %s from url %s", minioVersion, minioUrl)
-	
-	binDir := path.Join(binariesDir, minioVersion)
+/*
+Downloads the minio binary of the release in the binaries directory and verifies its checksum.
+The binary is downloaded in a partial download file, which is only renamed to its final path once its checksum is verified.
+A partial download file left by a previous run is resumed.
+The url and mirrors of the release are tried in turn until one of them provides a binary with the expected checksum.
+The signature of the binary is then verified if the release has one or if signatures are required.
+If the release is archived, the archive is downloaded instead and the binary is extracted from it with executable permissions.
+The checksum and signature are then over the archive, unless the checksum target of the release is the binary.
+*/
+func GetBinary(rel *etcd.MinioRelease, binariesDir string, conf DownloadConfig, log logger.Logger) error {
+	urls := rel.GetUrls()
+	if len(urls) == 0 {
+		return errors.New(fmt.Sprintf("Minio release %s has no url to download its binary from", rel.Version))
+	}
+
+	binDir := path.Join(binariesDir, rel.Version)
 	binPath := path.Join(binDir, "minio")
-	
+
+	lock := getVersionLock(binDir)
+	lock.Lock()
+	defer lock.Unlock()
+
+	artifactPath := binPath
+	if rel.IsArchived() {
+		artifactPath = binPath + ".archive"
+	}
+	partPath := artifactPath + ".part"
+
+	sumPath := artifactPath
+	if rel.IsBinaryChecksum() {
+		sumPath = binPath
+	}
+
+	cli, cliErr := getHttpClient(conf)
+	if cliErr != nil {
+		return cliErr
+	}
+
+	sum, sumErr := getReleaseChecksum(cli, rel)
+	if sumErr != nil {
+		return sumErr
+	}
+
 	exists, existsErr := fs.PathExists(binPath)
 	if existsErr != nil {
 		return errors.New(fmt.Sprintf("Error determining if minio download already exists: %s", existsErr.Error()))
 	}
 
+	if exists && artifactPath != binPath {
+		exists, existsErr = fs.PathExists(artifactPath)
+		if existsErr != nil {
+			return errors.New(fmt.Sprintf("Error determining if minio archive already exists: %s", existsErr.Error()))
+		}
+	}
+
 	if exists {
-		sha, shaErr := fs.GetFileSha256(binPath)
-		if shaErr != nil {
-			return errors.New(fmt.Sprintf("Error checking checksum of pre-existing minio download: %s", shaErr.Error()))
+		hash, hashErr := sum.GetFileHash(sumPath)
+		if hashErr != nil {
+			return errors.New(fmt.Sprintf("Error checking checksum of pre-existing minio download: %s", hashErr.Error()))
 		}
 
-		if sha == expectedSha {
+		if hash == sum.Hash {
 			log.Infof("[binary] Minio binary was already downloaded with matching checksum. Skipping download")
-			return nil
+			return verifySignature(cli, rel, artifactPath, conf.Signature, log)
 		}
 
 		log.Infof("[binary] Minio binary was already downloaded, but checksum didn't match. Will delete and re-download")
-		removeErr := os.Remove(binPath)
-		if removeErr != nil {
-			return errors.New(fmt.Sprintf("Error removing bad pre-existing minio download: %s", removeErr.Error()))
+		for _, existingPath := range []string{binPath, artifactPath} {
+			removeErr := os.Remove(existingPath)
+			if removeErr != nil && !os.IsNotExist(removeErr) {
+				return errors.New(fmt.Sprintf("Error removing bad pre-existing minio download: %s", removeErr.Error()))
+			}
 		}
 	}
 
@@ -105,21 +186,34 @@ func GetBinary(minioUrl string, minioVersion string, expectedSha string, binarie
 		return errors.New(fmt.Sprintf("Error creating minio download path: %s", mkdirErr.Error()))
 	}
 
-	dlErr := downloadBinary(minioUrl, binPath, 3)
-	if dlErr != nil {
-		return dlErr
-	}
+	var urlErr error
+	for _, binaryUrl := range getDownloadUrls(cli, rel, conf, log) {
+		urlErr = getBinaryFromUrl(cli, binaryUrl, rel, sum, partPath, conf, log)
+		if urlErr == nil {
+			sigErr := verifySignature(cli, rel, partPath, conf.Signature, log)
+			if sigErr != nil {
+				os.Remove(partPath)
+				return sigErr
+			}
+
+			if !rel.IsArchived() {
+				return fs.AtomicRename(partPath, binPath)
+			}
 
-	binSha, binShaErr := fs.GetFileSha256(binPath)
-	if binShaErr != nil {
-		return errors.New(fmt.Sprintf("Error reading downloaded binary to check checksum: %s", binShaErr.Error()))
+			urlErr = installArchive(rel, sum, partPath, artifactPath, binPath)
+			if urlErr == nil {
+				return nil
+			}
+		}
+
+		log.Warnf("[binary] Failed to get binary version %s from url %s: %s", rel.Version, binaryUrl, urlErr.Error())
 	}
-    
-	if binSha != expectedSha {
-		return errors.New(fmt.Sprintf("Error downloaded binary checksum did not match expected value: %s != %s", binSha, expectedSha))
+
+	if len(urls) > 1 {
+		return errors.New(fmt.Sprintf("Error getting the minio binary from all of its %d urls. Last error: %s", len(urls), urlErr.Error()))
 	}
 
-	return nil
+	return urlErr
 }
 
 func GetMinioPath(binariesDir string) (string, error) {
@@ -135,17 +229,33 @@ func GetMinioPath(binariesDir string) (string, error) {
 	return path.Join(binDirs[len(binDirs) - 1], "minio"), nil
 }
 
-func CleanupOldBinaries(binariesDir string, log logger.Logger) error {
+func CleanupOldBinaries(binariesDir string, keptVersions []string, log logger.Logger) error {
 	binDirs, binDirsErr := fs.GetTopSubDirectories(binariesDir)
 	if binDirsErr != nil {
 		return errors.New(fmt.Sprintf("Error cleaning up minio binaries: %s", binDirsErr.Error()))
 	}
 
-	log.Infof("[binary] Found %d minio binaries. Will delete all, but the most recent", len(binDirs))
+	log.Infof("[binary] Found %d minio binaries. Will delete all, but versions %s", len(binDirs), strings.Join(keptVersions, ", "))
+
+	for _, binDir := range binDirs {
+		kept := false
+		for _, version := range keptVersions {
+			if path.Base(binDir) == version {
+				kept = true
+			}
+		}
+
+		if kept {
+			continue
+		}
 
-	cleanupErr := fs.KeepLastDirectories(1, binDirs)
-	if cleanupErr != nil {
-		return errors.New(fmt.Sprintf("Error cleaning up minio binaries: %s", cleanupErr.Error()))
+		lock := getVersionLock(binDir)
+		lock.Lock()
+		rmErr := os.RemoveAll(binDir)
+		lock.Unlock()
+		if rmErr != nil {
+			return errors.New(fmt.Sprintf("Error cleaning up minio binaries: %s", rmErr.Error()))
+		}
 	}
 
 	return nil
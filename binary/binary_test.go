@@ -0,0 +1,344 @@
+package binary
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Ferlab-Ste-Justine/ferio/etcd"
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+)
+
+type testServer struct {
+	content  []byte
+	failures int
+	delay    time.Duration
+	requests []string
+	mutex    sync.Mutex
+}
+
+func (srv *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv.mutex.Lock()
+	srv.requests = append(srv.requests, r.Header.Get("Range"))
+	fail := srv.failures > 0
+	if fail {
+		srv.failures--
+	}
+	srv.mutex.Unlock()
+
+	time.Sleep(srv.delay)
+	if fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	http.ServeContent(w, r, "minio", time.Time{}, bytes.NewReader(srv.content))
+}
+
+func getTestSha(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func getTestDownloadConfig() DownloadConfig {
+	conf := DownloadConfig{RetryInterval: 10 * time.Millisecond}
+	conf.SetDefaults()
+	return conf
+}
+
+func TestGetBinary(t *testing.T) {
+	log := logger.Logger{LogLevel: logger.ERROR}
+	content := bytes.Repeat([]byte("minio"), 1000)
+	srv := &testServer{content: content, failures: 2}
+	httpSrv := httptest.NewServer(srv)
+	defer httpSrv.Close()
+
+	binDir := t.TempDir()
+
+	err := GetBinary(&etcd.MinioRelease{Version: "v1", Url: httpSrv.URL, Checksum: getTestSha(content)}, binDir, getTestDownloadConfig(), log)
+	if err != nil {
+		t.Errorf("Error occured getting binary after transient server errors: %s", err.Error())
+	}
+
+	if len(srv.requests) != 3 {
+		t.Errorf("Expected the download to be attempted 3 times and it was attempted %d times", len(srv.requests))
+	}
+
+	downloaded, readErr := os.ReadFile(GetMinioPathFromVersion(binDir, "v1"))
+	if readErr != nil {
+		t.Errorf("Error reading downloaded binary: %s", readErr.Error())
+	}
+
+	if !bytes.Equal(downloaded, content) {
+		t.Errorf("Expected downloaded binary to match the served content and it didn't")
+	}
+
+	stat, statErr := os.Stat(GetMinioPathFromVersion(binDir, "v1"))
+	if statErr != nil {
+		t.Errorf("Error getting downloaded binary info: %s", statErr.Error())
+	} else if stat.Mode().Perm() & 0100 == 0 {
+		t.Errorf("Expected downloaded binary to be executable and its mode was %s", stat.Mode().String())
+	}
+
+	_, statErr = os.Stat(GetMinioPathFromVersion(binDir, "v1") + ".part")
+	if !os.IsNotExist(statErr) {
+		t.Errorf("Expected partial download file to be gone after the download")
+	}
+}
+
+func TestGetBinaryResume(t *testing.T) {
+	log := logger.Logger{LogLevel: logger.ERROR}
+	content := bytes.Repeat([]byte("minio"), 1000)
+	srv := &testServer{content: content}
+	httpSrv := httptest.NewServer(srv)
+	defer httpSrv.Close()
+
+	binDir := t.TempDir()
+	partPath := GetMinioPathFromVersion(binDir, "v1") + ".part"
+	os.MkdirAll(path.Dir(partPath), 0755)
+	os.WriteFile(partPath, content[:1200], 0755)
+
+	err := GetBinary(&etcd.MinioRelease{Version: "v1", Url: httpSrv.URL, Checksum: getTestSha(content)}, binDir, getTestDownloadConfig(), log)
+	if err != nil {
+		t.Errorf("Error occured resuming binary download: %s", err.Error())
+	}
+
+	if len(srv.requests) != 1 || srv.requests[0] != "bytes=1200-" {
+		t.Errorf("Expected the download to resume at byte 1200 and the requested ranges were %v", srv.requests)
+	}
+
+	downloaded, _ := os.ReadFile(GetMinioPathFromVersion(binDir, "v1"))
+	if !bytes.Equal(downloaded, content) {
+		t.Errorf("Expected resumed binary to match the served content and it didn't")
+	}
+
+	//A partial download file larger than the binary cannot be resumed and should be restarted
+	srv.requests = []string{}
+	partPath = GetMinioPathFromVersion(binDir, "v2") + ".part"
+	os.MkdirAll(path.Dir(partPath), 0755)
+	os.WriteFile(partPath, append(content, content...), 0755)
+
+	err = GetBinary(&etcd.MinioRelease{Version: "v2", Url: httpSrv.URL, Checksum: getTestSha(content)}, binDir, getTestDownloadConfig(), log)
+	if err != nil {
+		t.Errorf("Error occured restarting binary download: %s", err.Error())
+	}
+
+	if len(srv.requests) != 2 || srv.requests[1] != "" {
+		t.Errorf("Expected the download to be restarted from the beginning and the requested ranges were %v", srv.requests)
+	}
+
+	downloaded, _ = os.ReadFile(GetMinioPathFromVersion(binDir, "v2"))
+	if !bytes.Equal(downloaded, content) {
+		t.Errorf("Expected restarted binary to match the served content and it didn't")
+	}
+}
+
+func TestGetBinaryBadChecksum(t *testing.T) {
+	log := logger.Logger{LogLevel: logger.ERROR}
+	content := bytes.Repeat([]byte("minio"), 1000)
+	srv := &testServer{content: content}
+	httpSrv := httptest.NewServer(srv)
+	defer httpSrv.Close()
+
+	binDir := t.TempDir()
+
+	err := GetBinary(&etcd.MinioRelease{Version: "v1", Url: httpSrv.URL, Checksum: getTestSha([]byte("other"))}, binDir, getTestDownloadConfig(), log)
+	if err == nil {
+		t.Errorf("Expected getting a binary with a bad checksum to fail and it didn't")
+	}
+
+	for _, binPath := range []string{GetMinioPathFromVersion(binDir, "v1"), GetMinioPathFromVersion(binDir, "v1") + ".part"} {
+		_, statErr := os.Stat(binPath)
+		if !os.IsNotExist(statErr) {
+			t.Errorf("Expected %s to be removed after a bad checksum and it wasn't", binPath)
+		}
+	}
+}
+
+func TestGetBinaryMirrors(t *testing.T) {
+	log := logger.Logger{LogLevel: logger.ERROR}
+	content := bytes.Repeat([]byte("minio"), 1000)
+	conf := getTestDownloadConfig()
+	conf.Retries = 1
+
+	brokenSrv := &testServer{content: content, failures: 100}
+	brokenHttpSrv := httptest.NewServer(brokenSrv)
+	defer brokenHttpSrv.Close()
+
+	corruptSrv := &testServer{content: []byte("corrupted")}
+	corruptHttpSrv := httptest.NewServer(corruptSrv)
+	defer corruptHttpSrv.Close()
+
+	stageDir := t.TempDir()
+	os.WriteFile(path.Join(stageDir, "minio"), content, 0755)
+
+	binDir := t.TempDir()
+	rel := etcd.MinioRelease{
+		Version:  "v1",
+		Url:      brokenHttpSrv.URL,
+		Mirrors:  []string{corruptHttpSrv.URL, "file://" + path.Join(stageDir, "minio")},
+		Checksum: getTestSha(content),
+	}
+
+	err := GetBinary(&rel, binDir, conf, log)
+	if err != nil {
+		t.Errorf("Error occured getting binary from a staged file mirror: %s", err.Error())
+	}
+
+	if len(brokenSrv.requests) != 2 || len(corruptSrv.requests) != 1 {
+		t.Errorf("Expected the url and mirrors to be tried in order and they were tried %d and %d times", len(brokenSrv.requests), len(corruptSrv.requests))
+	}
+
+	downloaded, _ := os.ReadFile(GetMinioPathFromVersion(binDir, "v1"))
+	if !bytes.Equal(downloaded, content) {
+		t.Errorf("Expected binary from the file mirror to match the staged content and it didn't")
+	}
+
+	rel.Version = "v2"
+	rel.Mirrors = []string{corruptHttpSrv.URL}
+	err = GetBinary(&rel, binDir, conf, log)
+	if err == nil {
+		t.Errorf("Expected getting a binary with no valid url to fail and it didn't")
+	}
+}
+
+func TestGetBinaryFastestMirror(t *testing.T) {
+	log := logger.Logger{LogLevel: logger.ERROR}
+	content := bytes.Repeat([]byte("minio"), 1000)
+
+	slowSrv := &testServer{content: content, delay: 200 * time.Millisecond}
+	slowHttpSrv := httptest.NewServer(slowSrv)
+	defer slowHttpSrv.Close()
+
+	fastSrv := &testServer{content: content}
+	fastHttpSrv := httptest.NewServer(fastSrv)
+	defer fastHttpSrv.Close()
+
+	binDir := t.TempDir()
+	rel := etcd.MinioRelease{
+		Version:         "v1",
+		Url:             slowHttpSrv.URL,
+		Mirrors:         []string{fastHttpSrv.URL},
+		MirrorSelection: etcd.MIRROR_SELECTION_FASTEST,
+		Checksum:        getTestSha(content),
+	}
+
+	err := GetBinary(&rel, binDir, getTestDownloadConfig(), log)
+	if err != nil {
+		t.Errorf("Error occured getting binary from the fastest mirror: %s", err.Error())
+	}
+
+	if len(slowSrv.requests) != 1 || len(fastSrv.requests) != 2 {
+		t.Errorf("Expected both urls to be probed and the binary to be downloaded from the fastest and they got %d and %d requests", len(slowSrv.requests), len(fastSrv.requests))
+	}
+}
+
+func TestGetBinaryHttpClient(t *testing.T) {
+	log := logger.Logger{LogLevel: logger.ERROR}
+	content := bytes.Repeat([]byte("minio"), 1000)
+
+	headers := make(chan http.Header, 10)
+	tlsSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+		http.ServeContent(w, r, "minio", time.Time{}, bytes.NewReader(content))
+	}))
+	defer tlsSrv.Close()
+
+	confDir := t.TempDir()
+	caPath := path.Join(confDir, "ca.pem")
+	os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsSrv.Certificate().Raw}), 0644)
+	headersPath := path.Join(confDir, "headers.yml")
+	os.WriteFile(headersPath, []byte("Authorization: Bearer rotated\n"), 0600)
+
+	rel := etcd.MinioRelease{Version: "v1", Url: tlsSrv.URL, Checksum: getTestSha(content)}
+
+	err := GetBinary(&rel, t.TempDir(), getTestDownloadConfig(), log)
+	if err == nil {
+		t.Errorf("Expected download from a server with an untrusted certificate to fail and it didn't")
+	}
+
+	conf := getTestDownloadConfig()
+	conf.CaCert = caPath
+	conf.Headers = map[string]string{"Authorization": "Bearer static", "X-Artifact-Store": "ferio"}
+	conf.HeadersFile = headersPath
+	err = GetBinary(&rel, t.TempDir(), conf, log)
+	if err != nil {
+		t.Errorf("Error occured getting binary from a server signed by the configured ca: %s", err.Error())
+	}
+
+	select {
+	case header := <-headers:
+		if header.Get("Authorization") != "Bearer rotated" || header.Get("X-Artifact-Store") != "ferio" {
+			t.Errorf("Expected the static and file headers to be sent and the headers were: %v", header)
+		}
+	default:
+		t.Errorf("Expected the server to be called with the configured ca")
+	}
+
+	proxied := make(chan string, 10)
+	proxySrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied <- r.URL.String()
+		http.ServeContent(w, r, "minio", time.Time{}, bytes.NewReader(content))
+	}))
+	defer proxySrv.Close()
+
+	conf = getTestDownloadConfig()
+	conf.Proxy = proxySrv.URL
+	rel.Url = "http://artifacts.internal/minio"
+	err = GetBinary(&rel, t.TempDir(), conf, log)
+	if err != nil {
+		t.Errorf("Error occured getting binary through the configured proxy: %s", err.Error())
+	}
+
+	select {
+	case proxiedUrl := <-proxied:
+		if proxiedUrl != rel.Url {
+			t.Errorf("Expected the proxy to receive the request for %s and it received %s", rel.Url, proxiedUrl)
+		}
+	default:
+		t.Errorf("Expected the download to go through the configured proxy and it didn't")
+	}
+}
+
+func TestHeadersNotSentOnRedirect(t *testing.T) {
+	log := logger.Logger{LogLevel: logger.ERROR}
+	content := bytes.Repeat([]byte("minio"), 1000)
+
+	authorizations := make(chan string, 10)
+	storageSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations <- r.Header.Get("Authorization")
+		http.ServeContent(w, r, "minio", time.Time{}, bytes.NewReader(content))
+	}))
+	defer storageSrv.Close()
+
+	redirectSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, strings.Replace(storageSrv.URL, "127.0.0.1", "localhost", 1) + "/minio", http.StatusFound)
+	}))
+	defer redirectSrv.Close()
+
+	conf := getTestDownloadConfig()
+	conf.Headers = map[string]string{"Authorization": "Bearer secret"}
+	rel := etcd.MinioRelease{Version: "v1", Url: redirectSrv.URL, Checksum: getTestSha(content)}
+	err := GetBinary(&rel, t.TempDir(), conf, log)
+	if err != nil {
+		t.Errorf("Error occured getting binary through a redirect: %s", err.Error())
+	}
+
+	select {
+	case authorization := <-authorizations:
+		if authorization != "" {
+			t.Errorf("Expected the headers not to be sent to the redirect host and the authorization was: %s", authorization)
+		}
+	default:
+		t.Errorf("Expected the download to be redirected and it wasn't")
+	}
+}
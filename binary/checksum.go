@@ -0,0 +1,161 @@
+package binary
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/Ferlab-Ste-Justine/ferio/etcd"
+	"github.com/Ferlab-Ste-Justine/ferio/fs"
+)
+
+const CHECKSUM_ALGORITHM_SHA256 = "sha256"
+const CHECKSUM_ALGORITHM_SHA512 = "sha512"
+
+type Checksum struct {
+	Algorithm string
+	Hash      string
+}
+
+func (sum Checksum) String() string {
+	return sum.Algorithm + ":" + sum.Hash
+}
+
+/*
+Parses a checksum with an optional algorithm prefix (ex: sha512:<hash>).
+Without a prefix, the algorithm is inferred from the length of the hash.
+*/
+func ParseChecksum(value string) (Checksum, error) {
+	sum := Checksum{Hash: strings.ToLower(strings.TrimSpace(value))}
+	if idx := strings.Index(sum.Hash, ":"); idx >= 0 {
+		sum.Algorithm = sum.Hash[:idx]
+		sum.Hash = sum.Hash[idx+1:]
+	}
+
+	_, hexErr := hex.DecodeString(sum.Hash)
+	if hexErr != nil {
+		return sum, errors.New(fmt.Sprintf("Checksum %s is not a valid hexadecimal hash", value))
+	}
+
+	expectedLength := map[string]int{CHECKSUM_ALGORITHM_SHA256: 64, CHECKSUM_ALGORITHM_SHA512: 128}
+	if sum.Algorithm == "" {
+		for algorithm, length := range expectedLength {
+			if len(sum.Hash) == length {
+				sum.Algorithm = algorithm
+			}
+		}
+	}
+
+	length, supported := expectedLength[sum.Algorithm]
+	if !supported {
+		return sum, errors.New(fmt.Sprintf("Checksum %s does not use a supported algorithm. Supported algorithms are %s and %s", value, CHECKSUM_ALGORITHM_SHA256, CHECKSUM_ALGORITHM_SHA512))
+	}
+
+	if len(sum.Hash) != length {
+		return sum, errors.New(fmt.Sprintf("Checksum %s does not have the length of a %s hash", value, sum.Algorithm))
+	}
+
+	return sum, nil
+}
+
+func (sum Checksum) GetFileHash(src string) (string, error) {
+	if sum.Algorithm == CHECKSUM_ALGORITHM_SHA512 {
+		return fs.GetFileSha512(src)
+	}
+
+	return fs.GetFileSha256(src)
+}
+
+/*
+Parses a checksum file in the format of the sha256sum and sha512sum commands, with one "<hash>  <filename>" line per file.
+If the file has several lines, the checksum of the given file name is returned.
+*/
+func parseChecksumFile(content string, fileName string) (Checksum, error) {
+	lines := []string{}
+	for _, line := range strings.Split(content, "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(lines) == 1 || (len(fields) > 1 && strings.TrimPrefix(fields[1], "*") == fileName) {
+			return ParseChecksum(fields[0])
+		}
+	}
+
+	if len(lines) == 0 {
+		return Checksum{}, errors.New("Checksum file is empty")
+	}
+
+	return Checksum{}, errors.New(fmt.Sprintf("Checksum file has no checksum for file %s", fileName))
+}
+
+func getChecksumFromUrl(cli *http.Client, rel *etcd.MinioRelease) (Checksum, error) {
+	content, getErr := getCompanionFile(cli, rel.ChecksumUrl)
+	if getErr != nil {
+		return Checksum{}, errors.New(fmt.Sprintf("Error downloading checksum file: %s", getErr.Error()))
+	}
+
+	fileName := "minio"
+	if urls := rel.GetUrls(); len(urls) > 0 {
+		parsed, parseErr := url.Parse(urls[0])
+		if parseErr == nil && path.Base(parsed.Path) != "." && path.Base(parsed.Path) != "/" {
+			fileName = path.Base(parsed.Path)
+		}
+	}
+
+	sum, sumErr := parseChecksumFile(string(content), fileName)
+	if sumErr != nil {
+		return sum, errors.New(fmt.Sprintf("Error parsing checksum file %s: %s", rel.ChecksumUrl, sumErr.Error()))
+	}
+
+	return sum, nil
+}
+
+func getReleaseChecksum(cli *http.Client, rel *etcd.MinioRelease) (Checksum, error) {
+	if rel.ChecksumUrl == "" {
+		return ParseChecksum(rel.Checksum)
+	}
+
+	sum, sumErr := getChecksumFromUrl(cli, rel)
+	if sumErr != nil {
+		return sum, sumErr
+	}
+
+	if rel.Checksum != "" {
+		expected, expectedErr := ParseChecksum(rel.Checksum)
+		if expectedErr != nil {
+			return sum, expectedErr
+		}
+
+		if expected != sum {
+			return sum, errors.New(fmt.Sprintf("Checksum %s of the checksum file %s does not match the release's checksum %s", sum.String(), rel.ChecksumUrl, expected.String()))
+		}
+	}
+
+	return sum, nil
+}
+
+/*
+Returns the checksum the binary of the release should match, prefixed with its algorithm.
+If the release has a checksum url, the checksum is fetched from it and cross-checked with the release's checksum, if any.
+*/
+func GetReleaseChecksum(rel *etcd.MinioRelease, conf DownloadConfig) (string, error) {
+	cli, cliErr := getHttpClient(conf)
+	if cliErr != nil {
+		return "", cliErr
+	}
+
+	sum, err := getReleaseChecksum(cli, rel)
+	if err != nil {
+		return "", err
+	}
+
+	return sum.String(), nil
+}
@@ -0,0 +1,102 @@
+package binary
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/Ferlab-Ste-Justine/ferio/etcd"
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+)
+
+func TestParseChecksum(t *testing.T) {
+	sha256Hash := strings.Repeat("a", 64)
+	sha512Hash := strings.Repeat("b", 128)
+
+	for _, tc := range []struct {
+		value    string
+		expected Checksum
+	}{
+		{sha256Hash, Checksum{CHECKSUM_ALGORITHM_SHA256, sha256Hash}},
+		{strings.ToUpper(sha256Hash), Checksum{CHECKSUM_ALGORITHM_SHA256, sha256Hash}},
+		{"sha256:" + sha256Hash, Checksum{CHECKSUM_ALGORITHM_SHA256, sha256Hash}},
+		{sha512Hash, Checksum{CHECKSUM_ALGORITHM_SHA512, sha512Hash}},
+		{"SHA512:" + sha512Hash, Checksum{CHECKSUM_ALGORITHM_SHA512, sha512Hash}},
+	} {
+		sum, err := ParseChecksum(tc.value)
+		if err != nil {
+			t.Errorf("Error occured parsing checksum %s: %s", tc.value, err.Error())
+		}
+
+		if sum != tc.expected {
+			t.Errorf("Expected checksum %s to be parsed as %s and it was parsed as %s", tc.value, tc.expected.String(), sum.String())
+		}
+	}
+
+	for _, value := range []string{"", "md5:" + strings.Repeat("a", 32), "sha512:" + sha256Hash, strings.Repeat("z", 64)} {
+		_, err := ParseChecksum(value)
+		if err == nil {
+			t.Errorf("Expected parsing checksum '%s' to fail and it didn't", value)
+		}
+	}
+}
+
+func TestParseChecksumFile(t *testing.T) {
+	hash := strings.Repeat("a", 64)
+	otherHash := strings.Repeat("b", 64)
+
+	sum, err := parseChecksumFile(fmt.Sprintf("%s  minio.RELEASE.2024-05-01T01-11-10Z\n", hash), "minio")
+	if err != nil || sum.Hash != hash {
+		t.Errorf("Expected the checksum of a single line file to be used whatever its file name and it wasn't")
+	}
+
+	sum, err = parseChecksumFile(fmt.Sprintf("%s  mc\n%s *minio\n", otherHash, hash), "minio")
+	if err != nil || sum.Hash != hash {
+		t.Errorf("Expected the checksum of the binary's file name to be used and it wasn't")
+	}
+
+	_, err = parseChecksumFile(fmt.Sprintf("%s  mc\n%s  mcli\n", otherHash, hash), "minio")
+	if err == nil {
+		t.Errorf("Expected parsing a checksum file without the binary's file name to fail and it didn't")
+	}
+}
+
+func TestGetBinaryChecksumUrl(t *testing.T) {
+	log := logger.Logger{LogLevel: logger.ERROR}
+	content := bytes.Repeat([]byte("minio"), 1000)
+	hash := sha512.Sum512(content)
+
+	stageDir := t.TempDir()
+	os.WriteFile(path.Join(stageDir, "minio"), content, 0755)
+	os.WriteFile(path.Join(stageDir, "minio.sha512sum"), []byte(hex.EncodeToString(hash[:]) + "  minio\n"), 0644)
+
+	binDir := t.TempDir()
+	rel := etcd.MinioRelease{
+		Version:     "v1",
+		Url:         "file://" + path.Join(stageDir, "minio"),
+		ChecksumUrl: "file://" + path.Join(stageDir, "minio.sha512sum"),
+	}
+
+	err := GetBinary(&rel, binDir, getTestDownloadConfig(), log)
+	if err != nil {
+		t.Errorf("Error occured getting binary with a checksum url: %s", err.Error())
+	}
+
+	rel.Version = "v2"
+	rel.Checksum = getTestSha(content)
+	err = GetBinary(&rel, binDir, getTestDownloadConfig(), log)
+	if err == nil {
+		t.Errorf("Expected getting a binary whose checksum does not match its checksum url to fail and it didn't")
+	}
+
+	rel.Checksum = CHECKSUM_ALGORITHM_SHA512 + ":" + hex.EncodeToString(hash[:])
+	err = GetBinary(&rel, binDir, getTestDownloadConfig(), log)
+	if err != nil {
+		t.Errorf("Error occured getting binary with a matching checksum and checksum url: %s", err.Error())
+	}
+}
@@ -0,0 +1,337 @@
+package binary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/Ferlab-Ste-Justine/ferio/auth"
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+)
+
+const MAX_COMPANION_FILE_SIZE = 64 * 1024
+
+type DownloadConfig struct {
+	ConnectTimeout   time.Duration     `yaml:"connect_timeout"`
+	//Maximum time without receiving data from the server, after which the download is retried
+	ReadTimeout      time.Duration     `yaml:"read_timeout"`
+	Retries          int
+	RetryInterval    time.Duration     `yaml:"retry_interval"`
+	MaxRetryInterval time.Duration     `yaml:"max_retry_interval"`
+	ProgressInterval time.Duration     `yaml:"progress_interval"`
+	//Url of the proxy to download through. Defaults to the proxy of the environment variables
+	Proxy            string
+	CaCert           string            `yaml:"ca_cert"`
+	ClientCert       string            `yaml:"client_cert"`
+	ClientKey        string            `yaml:"client_key"`
+	Headers          map[string]string
+	//Yaml file of headers, read before each download so that credentials can be rotated
+	HeadersFile      string            `yaml:"headers_file"`
+	Signature        SignatureConfig
+}
+
+func (conf *DownloadConfig) SetDefaults() {
+	if conf.ConnectTimeout == 0 {
+		conf.ConnectTimeout = 30 * time.Second
+	}
+
+	if conf.ReadTimeout == 0 {
+		conf.ReadTimeout = 1 * time.Minute
+	}
+
+	if conf.Retries == 0 {
+		conf.Retries = 5
+	}
+
+	if conf.RetryInterval == 0 {
+		conf.RetryInterval = 1 * time.Second
+	}
+
+	if conf.MaxRetryInterval == 0 {
+		conf.MaxRetryInterval = 1 * time.Minute
+	}
+
+	if conf.ProgressInterval == 0 {
+		conf.ProgressInterval = 10 * time.Second
+	}
+}
+
+/*
+Returns the headers to send with the download requests, the headers of the headers file taking precedence over the static headers.
+*/
+func (conf *DownloadConfig) GetHeaders() (map[string]string, error) {
+	headers := map[string]string{}
+	for name, value := range conf.Headers {
+		headers[name] = value
+	}
+
+	if conf.HeadersFile == "" {
+		return headers, nil
+	}
+
+	content, readErr := os.ReadFile(conf.HeadersFile)
+	if readErr != nil {
+		return nil, errors.New(fmt.Sprintf("Error reading the download headers file: %s", readErr.Error()))
+	}
+
+	fileHeaders := map[string]string{}
+	parseErr := yaml.Unmarshal(content, &fileHeaders)
+	if parseErr != nil {
+		return nil, errors.New(fmt.Sprintf("Error parsing the download headers file: %s", parseErr.Error()))
+	}
+
+	for name, value := range fileHeaders {
+		headers[name] = value
+	}
+
+	return headers, nil
+}
+
+/*
+Transport that adds the configured headers to the requests.
+The headers are not added to the requests of redirects to another host, so that credentials are not leaked to it.
+*/
+type headersTransport struct {
+	transport http.RoundTripper
+	headers   map[string]string
+}
+
+func (transport *headersTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	origin := req
+	for origin.Response != nil && origin.Response.Request != nil {
+		origin = origin.Response.Request
+	}
+
+	if len(transport.headers) == 0 || origin.URL.Host != req.URL.Host {
+		return transport.transport.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	for name, value := range transport.headers {
+		req.Header.Set(name, value)
+	}
+
+	return transport.transport.RoundTrip(req)
+}
+
+/*
+Returns the http client of the downloads.
+It also supports file urls, so that binaries staged on a local or shared filesystem can be used.
+*/
+func getHttpClient(conf DownloadConfig) (*http.Client, error) {
+	a := auth.Auth{CaCert: conf.CaCert, ClientCert: conf.ClientCert, ClientKey: conf.ClientKey}
+	tlsConf, tlsErr := a.GetTlsConfigs()
+	if tlsErr != nil {
+		return nil, errors.New(fmt.Sprintf("Error loading the download tls configuration: %s", tlsErr.Error()))
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if conf.Proxy != "" {
+		proxyUrl, proxyErr := url.Parse(conf.Proxy)
+		if proxyErr != nil {
+			return nil, errors.New(fmt.Sprintf("Error parsing the download proxy url: %s", proxyErr.Error()))
+		}
+		proxy = http.ProxyURL(proxyUrl)
+	}
+
+	headers, headersErr := conf.GetHeaders()
+	if headersErr != nil {
+		return nil, headersErr
+	}
+
+	dialer := &net.Dialer{Timeout: conf.ConnectTimeout}
+
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConf,
+		TLSHandshakeTimeout:   conf.ConnectTimeout,
+		ResponseHeaderTimeout: conf.ReadTimeout,
+	}
+	transport.RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
+
+	return &http.Client{Transport: &headersTransport{transport: transport, headers: headers}}, nil
+}
+
+/*
+Reader that cancels the download if no data is read within the read timeout and that logs the progress of the download.
+*/
+type progressReader struct {
+	reader       io.Reader
+	timer        *time.Timer
+	readTimeout  time.Duration
+	url          string
+	downloaded   int64
+	total        int64
+	interval     time.Duration
+	lastProgress time.Time
+	log          logger.Logger
+}
+
+func (reader *progressReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+	reader.timer.Reset(reader.readTimeout)
+	reader.downloaded += int64(n)
+
+	if time.Since(reader.lastProgress) >= reader.interval {
+		reader.lastProgress = time.Now()
+		if reader.total > 0 {
+			reader.log.Infof("[binary] Downloaded %d of %d bytes (%d%%) from %s", reader.downloaded, reader.total, reader.downloaded * 100 / reader.total, reader.url)
+		} else {
+			reader.log.Infof("[binary] Downloaded %d bytes from %s", reader.downloaded, reader.url)
+		}
+	}
+
+	return n, err
+}
+
+/*
+Downloads the url into the partial download file, resuming from the end of the file if the server supports range requests.
+Returns the number of bytes that were downloaded.
+*/
+func downloadAttempt(cli *http.Client, binaryUrl string, partPath string, conf DownloadConfig, log logger.Logger) (int64, error) {
+	fsWr, fsErr := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0755)
+	if fsErr != nil {
+		return 0, errors.New(fmt.Sprintf("Error opening partial download file: %s", fsErr.Error()))
+	}
+	defer fsWr.Close()
+
+	offset, seekErr := fsWr.Seek(0, io.SeekEnd)
+	if seekErr != nil {
+		return 0, errors.New(fmt.Sprintf("Error seeking the end of the partial download file: %s", seekErr.Error()))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, reqErr := http.NewRequestWithContext(ctx, http.MethodGet, binaryUrl, nil)
+	if reqErr != nil {
+		return 0, reqErr
+	}
+
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	res, getErr := cli.Do(req)
+	if getErr != nil {
+		return 0, getErr
+	}
+	defer res.Body.Close()
+
+	total := res.ContentLength
+	switch {
+	case offset > 0 && res.StatusCode == http.StatusPartialContent:
+		log.Infof("[binary] Resuming download of %s at byte %d", binaryUrl, offset)
+		if total > 0 {
+			total += offset
+		}
+	case res.StatusCode == http.StatusOK:
+		if offset > 0 {
+			log.Infof("[binary] Server does not support resuming the download of %s. Restarting it", binaryUrl)
+		}
+
+		truncErr := fsWr.Truncate(0)
+		if truncErr != nil {
+			return 0, errors.New(fmt.Sprintf("Error truncating the partial download file: %s", truncErr.Error()))
+		}
+
+		_, seekErr = fsWr.Seek(0, io.SeekStart)
+		if seekErr != nil {
+			return 0, errors.New(fmt.Sprintf("Error seeking the start of the partial download file: %s", seekErr.Error()))
+		}
+		offset = 0
+	case res.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		truncErr := fsWr.Truncate(0)
+		if truncErr != nil {
+			return 0, errors.New(fmt.Sprintf("Error truncating the partial download file: %s", truncErr.Error()))
+		}
+
+		return 0, errors.New("Server could not resume the download. Restarting it")
+	default:
+		return 0, errors.New(fmt.Sprintf("Server returned error code %d", res.StatusCode))
+	}
+
+	timer := time.AfterFunc(conf.ReadTimeout, cancel)
+	defer timer.Stop()
+
+	reader := &progressReader{
+		reader:       res.Body,
+		timer:        timer,
+		readTimeout:  conf.ReadTimeout,
+		url:          binaryUrl,
+		downloaded:   offset,
+		total:        total,
+		interval:     conf.ProgressInterval,
+		lastProgress: time.Now(),
+		log:          log,
+	}
+
+	written, cpErr := io.Copy(fsWr, reader)
+	if cpErr != nil {
+		if ctx.Err() != nil {
+			return written, errors.New(fmt.Sprintf("No data received for %s", conf.ReadTimeout.String()))
+		}
+
+		return written, cpErr
+	}
+
+	syncErr := fsWr.Sync()
+	if syncErr != nil {
+		return written, errors.New(fmt.Sprintf("Error syncing the partial download file: %s", syncErr.Error()))
+	}
+
+	return written, nil
+}
+
+/*
+Downloads the url into the partial download file, retrying with an exponential backoff.
+Each retry resumes the download where the previous attempt stopped.
+*/
+func downloadBinary(cli *http.Client, binaryUrl string, partPath string, conf DownloadConfig, log logger.Logger) (int64, error) {
+	total := int64(0)
+	interval := conf.RetryInterval
+	for attempt := 0; ; attempt++ {
+		written, err := downloadAttempt(cli, binaryUrl, partPath, conf, log)
+		total += written
+		if err == nil {
+			return total, nil
+		}
+
+		if attempt >= conf.Retries {
+			return total, errors.New(fmt.Sprintf("Error downloading minio: %s", err.Error()))
+		}
+
+		log.Warnf("[binary] Error downloading minio from %s: %s. Will retry in %s", binaryUrl, err.Error(), interval.String())
+		time.Sleep(interval)
+
+		interval *= 2
+		if interval > conf.MaxRetryInterval {
+			interval = conf.MaxRetryInterval
+		}
+	}
+}
+
+/*
+Downloads a small file published alongside the binary, such as its signature or checksum file.
+*/
+func getCompanionFile(cli *http.Client, fileUrl string) ([]byte, error) {
+	res, getErr := cli.Get(fileUrl)
+	if getErr != nil {
+		return nil, getErr
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 400 {
+		return nil, errors.New(fmt.Sprintf("Server returned error code %d", res.StatusCode))
+	}
+
+	return io.ReadAll(io.LimitReader(res.Body, MAX_COMPANION_FILE_SIZE))
+}
@@ -0,0 +1,79 @@
+package binary
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Ferlab-Ste-Justine/ferio/etcd"
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+)
+
+type mirrorProbe struct {
+	Url      string
+	Ok       bool
+	Duration time.Duration
+}
+
+func probeMirror(cli *http.Client, binaryUrl string, conf DownloadConfig) mirrorProbe {
+	ctx, cancel := context.WithTimeout(context.Background(), conf.ConnectTimeout + conf.ReadTimeout)
+	defer cancel()
+
+	start := time.Now()
+	req, reqErr := http.NewRequestWithContext(ctx, http.MethodHead, binaryUrl, nil)
+	if reqErr != nil {
+		return mirrorProbe{Url: binaryUrl}
+	}
+
+	res, resErr := cli.Do(req)
+	if resErr != nil {
+		return mirrorProbe{Url: binaryUrl}
+	}
+	res.Body.Close()
+
+	return mirrorProbe{Url: binaryUrl, Ok: res.StatusCode < 400, Duration: time.Since(start)}
+}
+
+/*
+Returns the urls of the release in the order they should be tried.
+With the fastest mirror selection, all the urls are probed concurrently and ordered from the fastest to respond to the slowest, with the urls that failed to respond last.
+*/
+func getDownloadUrls(cli *http.Client, rel *etcd.MinioRelease, conf DownloadConfig, log logger.Logger) []string {
+	urls := rel.GetUrls()
+	if rel.MirrorSelection != etcd.MIRROR_SELECTION_FASTEST || len(urls) < 2 {
+		return urls
+	}
+
+	probes := make([]mirrorProbe, len(urls))
+	var wg sync.WaitGroup
+	for idx, binaryUrl := range urls {
+		wg.Add(1)
+		go func(idx int, binaryUrl string) {
+			defer wg.Done()
+			probes[idx] = probeMirror(cli, binaryUrl, conf)
+		}(idx, binaryUrl)
+	}
+	wg.Wait()
+
+	sort.SliceStable(probes, func(i, j int) bool {
+		if probes[i].Ok != probes[j].Ok {
+			return probes[i].Ok
+		}
+
+		return probes[i].Ok && probes[i].Duration < probes[j].Duration
+	})
+
+	ordered := []string{}
+	for _, probe := range probes {
+		if probe.Ok {
+			log.Debugf("[binary] Mirror %s responded in %s", probe.Url, probe.Duration.String())
+		} else {
+			log.Warnf("[binary] Mirror %s failed to respond", probe.Url)
+		}
+		ordered = append(ordered, probe.Url)
+	}
+
+	return ordered
+}
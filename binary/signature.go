@@ -0,0 +1,188 @@
+package binary
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/Ferlab-Ste-Justine/ferio/etcd"
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+)
+
+const MINISIGN_ALGORITHM = "Ed"
+const MINISIGN_PREHASHED_ALGORITHM = "ED"
+const MINISIGN_UNTRUSTED_COMMENT_PREFIX = "untrusted comment:"
+const MINISIGN_TRUSTED_COMMENT_PREFIX = "trusted comment: "
+
+type SignatureConfig struct {
+	//Minisign public keys or base64 encoded ed25519 public keys trusted to sign minio binaries
+	TrustedKeys []string `yaml:"trusted_keys"`
+	//If true, releases without a signature are rejected. Implied when trusted keys are configured
+	Required    bool
+}
+
+/*
+Returns whether releases without a signature are rejected.
+Configuring trusted keys implies that signatures are required, so that removing the signature url of a release document does not disable its verification.
+*/
+func (conf *SignatureConfig) IsRequired() bool {
+	return conf.Required || len(conf.TrustedKeys) > 0
+}
+
+type trustedKey struct {
+	//Minisign key id, empty for plain ed25519 keys
+	KeyId []byte
+	Key   ed25519.PublicKey
+}
+
+func parseTrustedKeys(keys []string) ([]trustedKey, error) {
+	trusted := []trustedKey{}
+	for _, key := range keys {
+		lines := strings.Split(strings.TrimSpace(key), "\n")
+		decoded, decErr := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[len(lines) - 1]))
+		if decErr != nil {
+			return trusted, errors.New(fmt.Sprintf("Error decoding trusted key %s: %s", key, decErr.Error()))
+		}
+
+		switch {
+		case len(decoded) == 2 + 8 + ed25519.PublicKeySize && string(decoded[:2]) == MINISIGN_ALGORITHM:
+			trusted = append(trusted, trustedKey{KeyId: decoded[2:10], Key: ed25519.PublicKey(decoded[10:])})
+		case len(decoded) == ed25519.PublicKeySize:
+			trusted = append(trusted, trustedKey{Key: ed25519.PublicKey(decoded)})
+		default:
+			return trusted, errors.New(fmt.Sprintf("Trusted key %s is neither a minisign public key nor an ed25519 public key", key))
+		}
+	}
+
+	return trusted, nil
+}
+
+/*
+Verifies a signature in the minisign format.
+Both the legacy signatures over the binary and the signatures over the blake2b hash of the binary are supported.
+The trusted comment of the signature is verified as well.
+*/
+func verifyMinisign(signature []byte, binPath string, keys []trustedKey) error {
+	lines := strings.Split(strings.TrimSpace(string(signature)), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], MINISIGN_TRUSTED_COMMENT_PREFIX) {
+		return errors.New("Minisign signature is malformed")
+	}
+
+	sig, sigErr := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if sigErr != nil || len(sig) != 2 + 8 + ed25519.SignatureSize {
+		return errors.New("Minisign signature is malformed")
+	}
+
+	globalSig, globalSigErr := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if globalSigErr != nil || len(globalSig) != ed25519.SignatureSize {
+		return errors.New("Minisign signature's trusted comment signature is malformed")
+	}
+
+	content, readErr := os.ReadFile(binPath)
+	if readErr != nil {
+		return errors.New(fmt.Sprintf("Error reading binary to verify its signature: %s", readErr.Error()))
+	}
+
+	switch string(sig[:2]) {
+	case MINISIGN_ALGORITHM:
+	case MINISIGN_PREHASHED_ALGORITHM:
+		hash := blake2b.Sum512(content)
+		content = hash[:]
+	default:
+		return errors.New(fmt.Sprintf("Minisign signature algorithm %s is not supported", string(sig[:2])))
+	}
+
+	trustedComment := []byte(strings.TrimSuffix(strings.TrimPrefix(lines[2], MINISIGN_TRUSTED_COMMENT_PREFIX), "\r"))
+	matched := false
+	for _, key := range keys {
+		if key.KeyId == nil || !bytes.Equal(key.KeyId, sig[2:10]) {
+			continue
+		}
+		matched = true
+
+		if ed25519.Verify(key.Key, content, sig[10:]) && ed25519.Verify(key.Key, append(append([]byte{}, sig[10:]...), trustedComment...), globalSig) {
+			return nil
+		}
+	}
+
+	if !matched {
+		return errors.New(fmt.Sprintf("Minisign signature was made with key id %X, which is not trusted", sig[2:10]))
+	}
+
+	return errors.New("Minisign signature of the binary or of its trusted comment is invalid")
+}
+
+/*
+Verifies an ed25519 signature of the binary, either raw or base64 encoded.
+*/
+func verifyEd25519(signature []byte, binPath string, keys []trustedKey) error {
+	sig := signature
+	if len(sig) != ed25519.SignatureSize {
+		decoded, decErr := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+		if decErr != nil || len(decoded) != ed25519.SignatureSize {
+			return errors.New("Ed25519 signature is malformed")
+		}
+		sig = decoded
+	}
+
+	content, readErr := os.ReadFile(binPath)
+	if readErr != nil {
+		return errors.New(fmt.Sprintf("Error reading binary to verify its signature: %s", readErr.Error()))
+	}
+
+	for _, key := range keys {
+		if ed25519.Verify(key.Key, content, sig) {
+			return nil
+		}
+	}
+
+	return errors.New("Ed25519 signature of the binary does not match any trusted key")
+}
+
+/*
+Verifies the signature of the release's binary against the trusted keys.
+A release without a signature is only accepted if signatures are not required and a release with a signature is rejected if there is no trusted key to verify it.
+*/
+func verifySignature(cli *http.Client, rel *etcd.MinioRelease, binPath string, conf SignatureConfig, log logger.Logger) error {
+	if rel.SignatureUrl == "" {
+		if conf.IsRequired() {
+			return errors.New(fmt.Sprintf("Minio release %s has no signature and signatures are required", rel.Version))
+		}
+
+		return nil
+	}
+
+	if len(conf.TrustedKeys) == 0 {
+		return errors.New(fmt.Sprintf("Minio release %s has a signature, but no trusted key is configured to verify it", rel.Version))
+	}
+
+	keys, keysErr := parseTrustedKeys(conf.TrustedKeys)
+	if keysErr != nil {
+		return keysErr
+	}
+
+	signature, sigErr := getCompanionFile(cli, rel.SignatureUrl)
+	if sigErr != nil {
+		return errors.New(fmt.Sprintf("Error downloading signature: %s", sigErr.Error()))
+	}
+
+	if strings.HasPrefix(string(signature), MINISIGN_UNTRUSTED_COMMENT_PREFIX) {
+		sigErr = verifyMinisign(signature, binPath, keys)
+	} else {
+		sigErr = verifyEd25519(signature, binPath, keys)
+	}
+
+	if sigErr != nil {
+		return errors.New(fmt.Sprintf("Error verifying the signature of minio release %s: %s", rel.Version, sigErr.Error()))
+	}
+
+	log.Infof("[binary] Verified the signature of minio release %s", rel.Version)
+	return nil
+}
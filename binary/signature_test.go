@@ -0,0 +1,160 @@
+package binary
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/Ferlab-Ste-Justine/ferio/etcd"
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+)
+
+func getMinisignKeys(t *testing.T) (string, ed25519.PrivateKey, []byte) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err.Error())
+	}
+
+	keyId := make([]byte, 8)
+	rand.Read(keyId)
+	encoded := base64.StdEncoding.EncodeToString(append(append([]byte(MINISIGN_ALGORITHM), keyId...), pub...))
+	return encoded, priv, keyId
+}
+
+func signMinisign(priv ed25519.PrivateKey, keyId []byte, content []byte, prehashed bool) []byte {
+	algorithm := MINISIGN_ALGORITHM
+	if prehashed {
+		algorithm = MINISIGN_PREHASHED_ALGORITHM
+		hash := blake2b.Sum512(content)
+		content = hash[:]
+	}
+
+	sig := ed25519.Sign(priv, content)
+	trustedComment := "timestamp:1700000000\tfile:minio"
+	globalSig := ed25519.Sign(priv, append(append([]byte{}, sig...), []byte(trustedComment)...))
+
+	return []byte(fmt.Sprintf(
+		"untrusted comment: signature from minisign secret key\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(append(append([]byte(algorithm), keyId...), sig...)),
+		trustedComment,
+		base64.StdEncoding.EncodeToString(globalSig),
+	))
+}
+
+func TestVerifySignature(t *testing.T) {
+	log := logger.Logger{LogLevel: logger.ERROR}
+	cli, _ := getHttpClient(getTestDownloadConfig())
+	content := bytes.Repeat([]byte("minio"), 1000)
+
+	dir := t.TempDir()
+	binPath := path.Join(dir, "minio")
+	os.WriteFile(binPath, content, 0755)
+
+	minisignKey, minisignPriv, keyId := getMinisignKeys(t)
+	otherKey, otherPriv, otherKeyId := getMinisignKeys(t)
+	edPub, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	edKey := base64.StdEncoding.EncodeToString(edPub)
+
+	signatures := map[string][]byte{
+		"minio.minisig":        signMinisign(minisignPriv, keyId, content, true),
+		"minio.legacy.minisig": signMinisign(minisignPriv, keyId, content, false),
+		"minio.other.minisig":  signMinisign(otherPriv, otherKeyId, content, true),
+		"minio.forged.minisig": signMinisign(minisignPriv, keyId, []byte("forged"), true),
+		"minio.sig":            []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(edPriv, content))),
+		"minio.raw.sig":        ed25519.Sign(edPriv, content),
+	}
+	for name, signature := range signatures {
+		os.WriteFile(path.Join(dir, name), signature, 0644)
+	}
+
+	for _, tc := range []struct {
+		signature string
+		conf      SignatureConfig
+		valid     bool
+	}{
+		{"minio.minisig", SignatureConfig{TrustedKeys: []string{otherKey, minisignKey}}, true},
+		{"minio.legacy.minisig", SignatureConfig{TrustedKeys: []string{minisignKey}}, true},
+		{"minio.other.minisig", SignatureConfig{TrustedKeys: []string{minisignKey}}, false},
+		{"minio.forged.minisig", SignatureConfig{TrustedKeys: []string{minisignKey}}, false},
+		{"minio.sig", SignatureConfig{TrustedKeys: []string{minisignKey, edKey}}, true},
+		{"minio.raw.sig", SignatureConfig{TrustedKeys: []string{edKey}}, true},
+		{"minio.sig", SignatureConfig{TrustedKeys: []string{minisignKey}}, false},
+		{"minio.sig", SignatureConfig{Required: true}, false},
+		{"minio.sig", SignatureConfig{}, false},
+		{"", SignatureConfig{}, true},
+		{"", SignatureConfig{Required: true}, false},
+		{"", SignatureConfig{TrustedKeys: []string{edKey}}, false},
+		{"", SignatureConfig{TrustedKeys: []string{edKey}, Required: true}, false},
+	} {
+		rel := etcd.MinioRelease{Version: "v1"}
+		if tc.signature != "" {
+			rel.SignatureUrl = "file://" + path.Join(dir, tc.signature)
+		}
+
+		err := verifySignature(cli, &rel, binPath, tc.conf, log)
+		if tc.valid && err != nil {
+			t.Errorf("Expected signature %s to be accepted and it wasn't: %s", tc.signature, err.Error())
+		}
+
+		if !tc.valid && err == nil {
+			t.Errorf("Expected signature %s to be rejected and it wasn't", tc.signature)
+		}
+	}
+}
+
+func TestGetBinarySignature(t *testing.T) {
+	log := logger.Logger{LogLevel: logger.ERROR}
+	content := bytes.Repeat([]byte("minio"), 1000)
+	minisignKey, minisignPriv, keyId := getMinisignKeys(t)
+
+	stageDir := t.TempDir()
+	os.WriteFile(path.Join(stageDir, "minio"), content, 0755)
+	os.WriteFile(path.Join(stageDir, "minio.minisig"), signMinisign(minisignPriv, keyId, []byte("forged"), true), 0644)
+
+	binDir := t.TempDir()
+	conf := getTestDownloadConfig()
+	conf.Signature = SignatureConfig{TrustedKeys: []string{minisignKey}}
+	rel := etcd.MinioRelease{
+		Version:      "v1",
+		Url:          "file://" + path.Join(stageDir, "minio"),
+		SignatureUrl: "file://" + path.Join(stageDir, "minio.minisig"),
+		Checksum:     getTestSha(content),
+	}
+
+	err := GetBinary(&rel, binDir, conf, log)
+	if err == nil {
+		t.Errorf("Expected getting a binary with an invalid signature to fail and it didn't")
+	}
+
+	for _, binPath := range []string{GetMinioPathFromVersion(binDir, "v1"), GetMinioPathFromVersion(binDir, "v1") + ".part"} {
+		_, statErr := os.Stat(binPath)
+		if !os.IsNotExist(statErr) {
+			t.Errorf("Expected %s to be removed after an invalid signature and it wasn't", binPath)
+		}
+	}
+
+	unsignedRel := rel
+	unsignedRel.SignatureUrl = ""
+	err = GetBinary(&unsignedRel, binDir, conf, log)
+	if err == nil {
+		t.Errorf("Expected getting a binary whose signature url was removed to fail when trusted keys are configured and it didn't")
+	}
+
+	_, statErr := os.Stat(GetMinioPathFromVersion(binDir, "v1"))
+	if !os.IsNotExist(statErr) {
+		t.Errorf("Expected binary whose signature url was removed not to be installed and it was")
+	}
+
+	os.WriteFile(path.Join(stageDir, "minio.minisig"), signMinisign(minisignPriv, keyId, content, true), 0644)
+	err = GetBinary(&rel, binDir, conf, log)
+	if err != nil {
+		t.Errorf("Error occured getting a binary with a valid signature: %s", err.Error())
+	}
+}
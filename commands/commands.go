@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Ferlab-Ste-Justine/ferio/config"
+	"github.com/Ferlab-Ste-Justine/ferio/etcd"
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+)
+
+const STATUS_USAGE = "Usage: ferio status"
+
+/*
+Returns a function running the ferio subcommand given in the arguments, after validating the subcommand and its arguments.
+This way, usage errors are reported without connecting to etcd.
+*/
+func getCommand(args []string, conf config.Config, log logger.Logger) (func(*client.EtcdClient) error, error) {
+	switch args[0] {
+	case "status":
+		if len(args) != 1 {
+			return nil, errors.New(STATUS_USAGE)
+		}
+
+		return func(cli *client.EtcdClient) error {
+			return Status(cli, conf, os.Stdout)
+		}, nil
+	case "publish":
+		argsErr := validatePublishArgs(args[1:])
+		if argsErr != nil {
+			return nil, argsErr
+		}
+
+		return func(cli *client.EtcdClient) error {
+			return Publish(cli, conf, args[1:], log)
+		}, nil
+	case "workspace":
+		_, argsErr := parseWorkspaceArgs(args[1:])
+		if argsErr != nil {
+			return nil, argsErr
+		}
+
+		return func(cli *client.EtcdClient) error {
+			return Workspace(cli, conf, args[1:], os.Stdout, log)
+		}, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown command %s\n%s", args[0], strings.Join([]string{STATUS_USAGE, PUBLISH_USAGE, WORKSPACE_USAGE}, "\n")))
+	}
+}
+
+/*
+Runs the ferio subcommand given in the arguments, as an alternative to the ferio service.
+*/
+func Run(args []string, conf config.Config, log logger.Logger) error {
+	cmd, cmdErr := getCommand(args, conf, log)
+	if cmdErr != nil {
+		return cmdErr
+	}
+
+	cli, cliErr := etcd.GetClient(conf.Etcd)
+	if cliErr != nil {
+		return cliErr
+	}
+	defer cli.Close()
+
+	return cmd(cli)
+}
@@ -0,0 +1,190 @@
+package commands
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/Ferlab-Ste-Justine/ferio/binary"
+	"github.com/Ferlab-Ste-Justine/ferio/config"
+	"github.com/Ferlab-Ste-Justine/ferio/etcd"
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+	"github.com/Ferlab-Ste-Justine/ferio/version"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+)
+
+const PUBLISH_USAGE = "Usage: ferio publish <release|pools> <document path or - for stdin> [<signature path>]"
+
+func readDocument(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+
+	return os.ReadFile(path)
+}
+
+func checkRelease(rel *etcd.MinioRelease, current string, dlConf binary.DownloadConfig, log logger.Logger) error {
+	if rel.Version == "" || len(rel.GetUrls()) == 0 || (rel.Checksum == "" && rel.ChecksumUrl == "") {
+		return errors.New("Release must have a version, an url or mirrors and a checksum or checksum url")
+	}
+
+	formatErr := rel.ValidateFormat()
+	if formatErr != nil {
+		return formatErr
+	}
+
+	if current != "" {
+		var currentRel etcd.MinioRelease
+		err := yaml.Unmarshal([]byte(current), &currentRel)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Error parsing the current release configuration: %s", err.Error()))
+		}
+
+		greater, cmpErr := version.IsGreater(rel.Version, currentRel.Version)
+		if cmpErr != nil && !rel.AllowDowngrade {
+			return cmpErr
+		}
+
+		if !greater && !rel.AllowDowngrade {
+			return errors.New(fmt.Sprintf("Release version %s is not greater than the current version %s. Set allow_downgrade to true to publish it anyway", rel.Version, currentRel.Version))
+		}
+
+		if rel.Version == currentRel.Version {
+			return errors.New(fmt.Sprintf("Release version %s is already the current version", rel.Version))
+		}
+	}
+
+	tmpDir, tmpErr := os.MkdirTemp("", "ferio-publish-")
+	if tmpErr != nil {
+		return errors.New(fmt.Sprintf("Error creating temporary directory to download minio: %s", tmpErr.Error()))
+	}
+	defer os.RemoveAll(tmpDir)
+
+	return binary.GetBinary(rel, tmpDir, dlConf, log)
+}
+
+func checkPools(pools *etcd.MinioServerPools, current string) error {
+	if pools.Version == "" || len(pools.Pools) == 0 {
+		return errors.New("Server pools must have a version and at least one pool")
+	}
+
+	if current == "" {
+		return nil
+	}
+
+	var currentPools etcd.MinioServerPools
+	err := yaml.Unmarshal([]byte(current), &currentPools)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error parsing the current server pools configuration: %s", err.Error()))
+	}
+
+	greater, cmpErr := version.IsGreater(pools.Version, currentPools.Version)
+	if cmpErr != nil {
+		return cmpErr
+	}
+
+	if !greater {
+		return errors.New(fmt.Sprintf("Server pools version %s is not greater than the current version %s", pools.Version, currentPools.Version))
+	}
+
+	if !pools.Pools.IsAppendOf(currentPools.Pools) {
+		return errors.New(fmt.Sprintf("Server pools version %s does not only append pools to the current version %s", pools.Version, currentPools.Version))
+	}
+
+	return nil
+}
+
+func validatePublishArgs(args []string) error {
+	if (len(args) != 2 && len(args) != 3) || (args[0] != "release" && args[0] != "pools") {
+		return errors.New(PUBLISH_USAGE)
+	}
+
+	return nil
+}
+
+/*
+Validates a release or server pools document and writes it in the configuration prefix.
+The write fails if the key was modified by someone else while the document was being validated.
+*/
+func Publish(cli *client.EtcdClient, conf config.Config, args []string, log logger.Logger) error {
+	argsErr := validatePublishArgs(args)
+	if argsErr != nil {
+		return argsErr
+	}
+
+	doc, docErr := readDocument(args[1])
+	if docErr != nil {
+		return errors.New(fmt.Sprintf("Error reading the document to publish: %s", docErr.Error()))
+	}
+
+	key := etcd.GetPoolsConfigKey(conf.Etcd.ConfigPrefix)
+	if args[0] == "release" {
+		key = etcd.GetReleaseConfigKey(conf.Etcd.ConfigPrefix)
+	}
+
+	var sig []byte
+	if len(args) == 3 {
+		sigContent, sigErr := os.ReadFile(args[2])
+		if sigErr != nil {
+			return errors.New(fmt.Sprintf("Error reading the signature of the document to publish: %s", sigErr.Error()))
+		}
+
+		sig, sigErr = etcd.DecodeDocumentSignature(sigContent)
+		if sigErr != nil {
+			return errors.New(fmt.Sprintf("Error reading the signature of the document to publish: %s", sigErr.Error()))
+		}
+	}
+
+	verifyErr := conf.Signatures.VerifyDocument(key, string(doc), base64.StdEncoding.EncodeToString(sig), len(sig) > 0)
+	if verifyErr != nil {
+		return verifyErr
+	}
+
+	current, rev, _, currentErr := etcd.GetConfigDocument(cli, key)
+	if currentErr != nil {
+		return currentErr
+	}
+
+	version := ""
+	if args[0] == "release" {
+		var rel etcd.MinioRelease
+		err := yaml.UnmarshalStrict(doc, &rel)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Error parsing the release document: %s", err.Error()))
+		}
+
+		err = checkRelease(&rel, current, conf.Download, log)
+		if err != nil {
+			return err
+		}
+		version = rel.Version
+	} else {
+		var pools etcd.MinioServerPools
+		err := yaml.UnmarshalStrict(doc, &pools)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Error parsing the server pools document: %s", err.Error()))
+		}
+
+		err = checkPools(&pools, current)
+		if err != nil {
+			return err
+		}
+		version = pools.Version
+	}
+
+	published, pubErr := etcd.PublishConfigDocument(cli, key, string(doc), sig, rev)
+	if pubErr != nil {
+		return pubErr
+	}
+
+	if !published {
+		return errors.New(fmt.Sprintf("Key %s was modified while the document was being validated. Aborting", key))
+	}
+
+	log.Infof("[commands] Published %s version %s at key %s", args[0], version, key)
+	return nil
+}
@@ -0,0 +1,313 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Ferlab-Ste-Justine/ferio/config"
+	"github.com/Ferlab-Ste-Justine/ferio/etcd"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+)
+
+func joinHosts(hosts []string) string {
+	if len(hosts) == 0 {
+		return "-"
+	}
+
+	return strings.Join(hosts, ", ")
+}
+
+func isHostIn(host string, hosts []string) bool {
+	for _, elem := range hosts {
+		if elem == host {
+			return true
+		}
+	}
+	return false
+}
+
+func getMissingHosts(hosts []string, tk *etcd.Task, dead []string) []string {
+	missing := []string{}
+	for _, host := range hosts {
+		if !tk.HasToDo(host) {
+			continue
+		}
+
+		if isHostIn(host, dead) {
+			missing = append(missing, fmt.Sprintf("%s (dead)", host))
+		} else {
+			missing = append(missing, host)
+		}
+	}
+	return missing
+}
+
+func printTaskPhases(cli *client.EtcdClient, conf config.Config, phases []etcd.TaskPhase, hosts []string, out io.Writer) error {
+	hostsCount := len(hosts)
+
+	dead, deadErr := etcd.GetDeadHosts(cli, conf.Etcd.WorkspacePrefix, hosts)
+	if deadErr != nil {
+		return deadErr
+	}
+
+	for _, phase := range phases {
+		tk, _, err := etcd.GetTask(cli, phase.Key)
+		if err != nil {
+			return err
+		}
+
+		completion := "in progress"
+		if tk.Complete {
+			completion = "complete"
+		} else if len(tk.Completers) == 0 {
+			completion = "not started"
+		}
+
+		completedCount := 0
+		for _, host := range hosts {
+			if !tk.HasToDo(host) {
+				completedCount++
+			}
+		}
+
+		fmt.Fprintf(out, "  %s: %s (%d/%d hosts)\n", phase.Name, completion, completedCount, hostsCount)
+		if len(tk.Completers) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(out, "    completed: %s\n", joinHosts(tk.Completers))
+		fmt.Fprintf(out, "    missing: %s\n", joinHosts(getMissingHosts(hosts, tk, dead)))
+		if len(tk.Failed) > 0 {
+			fmt.Fprintf(out, "    failed: %s\n", joinHosts(tk.Failed))
+		}
+	}
+
+	return nil
+}
+
+func printQueueState(cli *client.EtcdClient, conf config.Config, kind string, version string, out io.Writer) error {
+	state, stateErr := etcd.GetQueuedUpdateState(cli, conf.Etcd.WorkspacePrefix, kind, version)
+	if stateErr != nil {
+		return stateErr
+	}
+
+	fmt.Fprintf(out, "  queue state: %s\n", state)
+	if state != etcd.QUEUE_OUTCOME_REJECTED {
+		return nil
+	}
+
+	rejection, rejectionErr := etcd.GetQueueRejection(cli, conf.Etcd.WorkspacePrefix, kind, version)
+	if rejectionErr != nil {
+		return rejectionErr
+	}
+
+	if rejection != nil {
+		fmt.Fprintf(out, "  rejection reason: %s\n", rejection.Reason)
+	}
+
+	return nil
+}
+
+func printPoolsStatus(cli *client.EtcdClient, conf config.Config, pools *etcd.MinioServerPools, excls etcd.HostExclusions, out io.Writer) error {
+	upd, updErr := pools.GetUpdate(cli, conf.Etcd.WorkspacePrefix, excls, conf.Signatures)
+	if updErr != nil {
+		return updErr
+	}
+
+	phase := "done"
+	if !upd.IsDone() {
+		phase = upd.GetTaskPhase()
+	}
+
+	fmt.Fprintf(out, "Server pools version %s\n", pools.Version)
+	stateErr := printQueueState(cli, conf, etcd.QUEUE_KIND_POOLS, pools.Version, out)
+	if stateErr != nil {
+		return stateErr
+	}
+	fmt.Fprintf(out, "  phase: %s\n", phase)
+
+	phasesErr := printTaskPhases(cli, conf, pools.GetTaskPhases(conf.Etcd.WorkspacePrefix), upd.RequiredHosts, out)
+	if phasesErr != nil {
+		return phasesErr
+	}
+
+	newHosts := []string{}
+	for _, host := range excls.FilterHosts(pools.Pools.GetHosts()) {
+		if !upd.IsRequiredHost(host) {
+			newHosts = append(newHosts, host)
+		}
+	}
+
+	if len(newHosts) == 0 {
+		return nil
+	}
+
+	return printTaskPhases(cli, conf, []etcd.TaskPhase{etcd.TaskPhase{Name: "join (added hosts)", Key: pools.GetJoinKey(conf.Etcd.WorkspacePrefix)}}, newHosts, out)
+}
+
+func printReleaseStatus(cli *client.EtcdClient, conf config.Config, rel *etcd.MinioRelease, pools *etcd.MinioServerPools, excls etcd.HostExclusions, out io.Writer) error {
+	upd, updErr := rel.GetUpdate(cli, conf.Etcd.WorkspacePrefix, pools, excls)
+	if updErr != nil {
+		return updErr
+	}
+
+	phase := "done"
+	if !upd.IsDone() {
+		phase = upd.GetTaskPhase()
+	}
+
+	fmt.Fprintf(out, "Release version %s\n", rel.Version)
+	stateErr := printQueueState(cli, conf, etcd.QUEUE_KIND_RELEASE, rel.Version, out)
+	if stateErr != nil {
+		return stateErr
+	}
+	fmt.Fprintf(out, "  phase: %s\n", phase)
+
+	if rel.RequireApproval {
+		approved, approvedErr := rel.IsApproved(cli, conf.Etcd.WorkspacePrefix)
+		if approvedErr != nil {
+			return approvedErr
+		}
+
+		if approved {
+			fmt.Fprintf(out, "  approval: approved\n")
+		} else {
+			fmt.Fprintf(out, "  approval: pending (create key %s to approve)\n", rel.GetApprovalKey(conf.Etcd.WorkspacePrefix))
+		}
+	}
+
+	return printTaskPhases(cli, conf, rel.GetTaskPhases(conf.Etcd.WorkspacePrefix), upd.RequiredHosts, out)
+}
+
+func printNextReleaseStatus(cli *client.EtcdClient, conf config.Config, pools *etcd.MinioServerPools, out io.Writer) error {
+	nextRel, nextRelErr := etcd.GetNextRelease(cli, conf.Etcd.ConfigPrefix, conf.Signatures)
+	if nextRelErr != nil {
+		return nextRelErr
+	}
+
+	if nextRel == nil {
+		return nil
+	}
+
+	prefetched, prefetchedErr := etcd.GetPrefetchedHosts(cli, conf.Etcd.WorkspacePrefix, nextRel)
+	if prefetchedErr != nil {
+		return prefetchedErr
+	}
+
+	missing := []string{}
+	for _, host := range pools.Pools.GetHosts() {
+		if !isHostIn(host, prefetched) {
+			missing = append(missing, host)
+		}
+	}
+
+	fmt.Fprintf(out, "Next release version %s\n", nextRel.Version)
+	fmt.Fprintf(out, "  prefetched: %s\n", joinHosts(prefetched))
+	fmt.Fprintf(out, "  missing: %s\n", joinHosts(missing))
+
+	return nil
+}
+
+/*
+Prints the progress of the updates to the current server pools and release versions.
+For each synchronization phase, the hosts that completed it and the hosts that are missing are listed.
+Hosts whose ferio instance is not registered in the workspace are reported as dead.
+Excluded hosts are listed and are not expected to complete the phases.
+*/
+func Status(cli *client.EtcdClient, conf config.Config, out io.Writer) error {
+	pools, rel, _, getErr := etcd.GetConfigs(cli, conf.Etcd.ConfigPrefix, conf.Signatures)
+	if getErr != nil {
+		return getErr
+	}
+
+	appliedPools, appliedPoolsErr := etcd.GetAppliedPools(cli, conf.Etcd.WorkspacePrefix, conf.Signatures)
+	if appliedPoolsErr != nil {
+		return appliedPoolsErr
+	}
+
+	appliedRel, appliedRelErr := etcd.GetAppliedRelease(cli, conf.Etcd.WorkspacePrefix, conf.Signatures)
+	if appliedRelErr != nil {
+		return appliedRelErr
+	}
+
+	if appliedPools != nil {
+		fmt.Fprintf(out, "Applied server pools version: %s\n", appliedPools.Version)
+	}
+
+	if appliedRel != nil {
+		fmt.Fprintf(out, "Applied release version: %s\n", appliedRel.Version)
+	}
+
+	dead, deadErr := etcd.GetDeadHosts(cli, conf.Etcd.WorkspacePrefix, pools.Pools.GetHosts())
+	if deadErr != nil {
+		return deadErr
+	}
+
+	fmt.Fprintf(out, "Live ferio instances: %d/%d hosts\n", pools.Pools.CountHosts() - int64(len(dead)), pools.Pools.CountHosts())
+	fmt.Fprintf(out, "Dead ferio instances: %s\n", joinHosts(dead))
+
+	paused, pauseReason, pausedErr := etcd.IsPaused(cli, conf.Etcd.ConfigPrefix)
+	if pausedErr != nil {
+		return pausedErr
+	}
+
+	if paused {
+		fmt.Fprintf(out, "Updates are paused: %s\n", pauseReason)
+	}
+
+	windows, windowsErr := etcd.GetMaintenanceWindows(cli, conf.Etcd.ConfigPrefix)
+	if windowsErr != nil {
+		return windowsErr
+	}
+
+	if len(windows) > 0 {
+		open, openErr := windows.IsOpen(time.Now())
+		if openErr != nil {
+			return openErr
+		}
+
+		if open {
+			fmt.Fprintf(out, "Maintenance window: open\n")
+		} else {
+			fmt.Fprintf(out, "Maintenance window: closed\n")
+		}
+	}
+
+	excls, exclsErr := etcd.GetHostExclusions(cli, conf.Etcd.ConfigPrefix)
+	if exclsErr != nil {
+		return exclsErr
+	}
+
+	now := time.Now()
+	for _, excl := range excls {
+		if !excl.IsActive(now) {
+			continue
+		}
+
+		if excl.Expiry.IsZero() {
+			fmt.Fprintf(out, "Excluded host %s: %s\n", excl.Host, excl.Reason)
+		} else {
+			fmt.Fprintf(out, "Excluded host %s until %s: %s\n", excl.Host, excl.Expiry.Format(time.RFC3339), excl.Reason)
+		}
+	}
+
+	poolsErr := printPoolsStatus(cli, conf, pools, excls, out)
+	if poolsErr != nil {
+		return poolsErr
+	}
+
+	relPools := pools
+	if appliedPools != nil {
+		relPools = appliedPools
+	}
+
+	relErr := printReleaseStatus(cli, conf, rel, relPools, excls, out)
+	if relErr != nil {
+		return relErr
+	}
+
+	return printNextReleaseStatus(cli, conf, pools, out)
+}
@@ -0,0 +1,68 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Ferlab-Ste-Justine/ferio/config"
+	"github.com/Ferlab-Ste-Justine/ferio/etcd"
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+)
+
+const WORKSPACE_USAGE = "Usage: ferio workspace gc [--dry-run]"
+
+/*
+Deletes the task keys of the versions that fall outside of the retention policy of the workspace_gc configuration.
+With --dry-run, the versions that would be deleted are only printed.
+*/
+func WorkspaceGc(cli *client.EtcdClient, conf config.Config, dryRun bool, out io.Writer, log logger.Logger) error {
+	versions, err := etcd.CollectTaskVersions(cli, conf.Etcd.ConfigPrefix, conf.Etcd.WorkspacePrefix, conf.WorkspaceGc.KeepVersions, dryRun, log)
+	if err != nil {
+		return err
+	}
+
+	action := "Deleted"
+	if dryRun {
+		action = "Would delete"
+	}
+
+	for _, version := range versions {
+		fmt.Fprintf(out, "%s %d task keys of %s version %s\n", action, version.Keys, version.Kind, version.Version)
+	}
+
+	if len(versions) == 0 {
+		fmt.Fprintf(out, "No task keys to delete, keeping the last %d versions of each kind\n", conf.WorkspaceGc.KeepVersions)
+	}
+
+	return nil
+}
+
+/*
+Returns whether the workspace gc command is a dry run.
+*/
+func parseWorkspaceArgs(args []string) (bool, error) {
+	if len(args) == 0 || len(args) > 2 || args[0] != "gc" {
+		return false, errors.New(WORKSPACE_USAGE)
+	}
+
+	if len(args) == 2 {
+		if args[1] != "--dry-run" {
+			return false, errors.New(WORKSPACE_USAGE)
+		}
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func Workspace(cli *client.EtcdClient, conf config.Config, args []string, out io.Writer, log logger.Logger) error {
+	dryRun, argsErr := parseWorkspaceArgs(args)
+	if argsErr != nil {
+		return argsErr
+	}
+
+	return WorkspaceGc(cli, conf, dryRun, out, log)
+}
@@ -8,17 +8,27 @@ import (
 	"strings"
 	yaml "gopkg.in/yaml.v2"
 
+	"github.com/Ferlab-Ste-Justine/ferio/binary"
 	"github.com/Ferlab-Ste-Justine/ferio/etcd"
+	"github.com/Ferlab-Ste-Justine/ferio/health"
 	"github.com/Ferlab-Ste-Justine/ferio/logger"
+	"github.com/Ferlab-Ste-Justine/ferio/metrics"
 	"github.com/Ferlab-Ste-Justine/ferio/systemd"
 )
 
 type Config struct {
 	Etcd            etcd.EtcdConfig
-	BinariesDir     string                 `yaml:"binaries_dir"`
+	BinariesDir     string                       `yaml:"binaries_dir"`
 	Host            string
-	LogLevel        string                 `yaml:"log_level"`
-	MinioServices   []systemd.MinioService `yaml:"minio_services"`
+	LogLevel        string                       `yaml:"log_level"`
+	MinioServices   []systemd.MinioService       `yaml:"minio_services"`
+	BarrierTimeouts etcd.BarrierTimeouts         `yaml:"barrier_timeouts"`
+	HealthCheck     health.HealthCheckConfig     `yaml:"health_check"`
+	Metrics         metrics.MetricsConfig
+	HostRegistry    etcd.HostRegistryConfig      `yaml:"host_registry"`
+	WorkspaceGc     etcd.WorkspaceGcConfig       `yaml:"workspace_gc"`
+	Download        binary.DownloadConfig
+	Signatures      etcd.DocumentSignatureConfig `yaml:"document_signatures"`
 }
 
 func getConfigFilePath() string {
@@ -72,5 +82,11 @@ func GetConfig() (Config, error) {
 		}
 	}
 
+	c.HealthCheck.SetDefaults()
+	c.Metrics.SetDefaults()
+	c.HostRegistry.SetDefaults()
+	c.WorkspaceGc.SetDefaults()
+	c.Download.SetDefaults()
+
 	return c, nil
 }
@@ -1,11 +1,15 @@
 package etcd
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sync"
+	"time"
 	yaml "gopkg.in/yaml.v2"
 
 	"github.com/Ferlab-Ste-Justine/ferio/logger"
+	"github.com/Ferlab-Ste-Justine/ferio/metrics"
 
 	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
 )
@@ -14,7 +18,10 @@ type ReleaseChangeAction func(*MinioRelease, *MinioServerPools) error
 
 type ServerPoolsChangeAction func(*MinioServerPools, *MinioRelease) error
 
-func GetConfigs(cli *client.EtcdClient, prefix string) (*MinioServerPools, *MinioRelease, int64, error) {
+/*
+Returns the server pools and release configurations, after verifying their signatures against the publisher keys.
+*/
+func GetConfigs(cli *client.EtcdClient, prefix string, sigs DocumentSignatureConfig) (*MinioServerPools, *MinioRelease, int64, error) {
 	poolsKey := fmt.Sprintf(ETCD_POOLS_CONFIG_KEY, prefix)
 	relKey := fmt.Sprintf(ETCD_RELEASE_CONFIG_KEY, prefix)
 
@@ -31,6 +38,12 @@ func GetConfigs(cli *client.EtcdClient, prefix string) (*MinioServerPools, *Mini
 		return nil, nil, -1, errors.New(fmt.Sprintf("Server pools configuration not found at key %s", poolsKey))
 	}
 
+	sig, sigOk := info.Keys[GetDocumentSignatureKey(poolsKey)]
+	err = sigs.VerifyDocument(poolsKey, val.Value, sig.Value, sigOk)
+	if err != nil {
+		return nil, nil, -1, err
+	}
+
 	err = yaml.Unmarshal([]byte(val.Value), &pools)
 	if err != nil {
 		return nil, nil, -1, errors.New(fmt.Sprintf("Error parsing the server pools configuration: %s", err.Error()))
@@ -41,6 +54,12 @@ func GetConfigs(cli *client.EtcdClient, prefix string) (*MinioServerPools, *Mini
 		return nil, nil, -1, errors.New(fmt.Sprintf("Release configuration not found at key %s", relKey))
 	}
 
+	sig, sigOk = info.Keys[GetDocumentSignatureKey(relKey)]
+	err = sigs.VerifyDocument(relKey, val.Value, sig.Value, sigOk)
+	if err != nil {
+		return nil, nil, -1, err
+	}
+
 	err = yaml.Unmarshal([]byte(val.Value), &rel)
 	if err != nil {
 		return nil, nil, -1, errors.New(fmt.Sprintf("Error parsing the release configuration: %s", err.Error()))
@@ -49,132 +68,276 @@ func GetConfigs(cli *client.EtcdClient, prefix string) (*MinioServerPools, *Mini
 	return &pools, &rel, info.Revision, nil
 }
 
-func HandleChanges(cli *client.EtcdClient, prefix string, startPools *MinioServerPools, poolsAction ServerPoolsChangeAction, startRel *MinioRelease, relAction ReleaseChangeAction, log logger.Logger) <-chan error {
-	errCh := make(chan error)
-	go func() {
-		defer close(errCh)
+/*
+Queues the server pools and release documents of the configuration prefix, along with their signatures.
+*/
+func EnqueueConfigs(cli *client.EtcdClient, confPrefix string, workspacePrefix string, sigs DocumentSignatureConfig, log logger.Logger) error {
+	err := EnqueueConfigDocument(cli, confPrefix, workspacePrefix, QUEUE_KIND_POOLS, sigs, log)
+	if err != nil {
+		return err
+	}
 
-		log.Infof("[etcd] Starting to watch for minio release and server pool changes")
-	
-		relConfigKey := fmt.Sprintf(ETCD_RELEASE_CONFIG_KEY, prefix)
-		poolsConfigKey := fmt.Sprintf(ETCD_POOLS_CONFIG_KEY, prefix)
+	return EnqueueConfigDocument(cli, confPrefix, workspacePrefix, QUEUE_KIND_RELEASE, sigs, log)
+}
 
-		pools, rel, rev, getErr := GetConfigs(cli, prefix)
-		if getErr != nil {
-			errCh <- getErr
-			return
-		}
+/*
+Downloads the binaries of upcoming releases ahead of their updates, in the background.
+*/
+type releasePrefetcher struct {
+	action   ReleasePrefetchAction
+	log      logger.Logger
+	lock     sync.Mutex
+	//Versions whose prefetch is in progress
+	inFlight map[string]bool
+}
 
-		if (*pools).Version != (*startPools).Version {
-			log.Infof("[etcd] Handling new server pools configuration at version %s", (*pools).Version)
+func newReleasePrefetcher(action ReleasePrefetchAction, log logger.Logger) *releasePrefetcher {
+	return &releasePrefetcher{action: action, log: log, inFlight: map[string]bool{}}
+}
 
-			rel, _, getErr := GetMinioRelease(cli, prefix)
-			if getErr != nil {
-				errCh <- getErr
-				return
-			}
+/*
+Downloads the binary of the upcoming release in a goroutine, so that a slow download does not hold the handling of changes.
+A release whose prefetch is already in progress is not prefetched again.
+Prefetching is best effort: a failure is logged, but does not interrupt the handling of changes.
+*/
+func (pref *releasePrefetcher) Prefetch(rel *MinioRelease) {
+	pref.lock.Lock()
+	defer pref.lock.Unlock()
 
-			actErr := poolsAction(pools, rel)
-			if actErr != nil {
-				errCh <- actErr
-				return
-			}
+	if pref.inFlight[rel.Version] {
+		pref.log.Debugf("[etcd] Prefetch of upcoming minio release at version %s is already in progress", rel.Version)
+		return
+	}
+	pref.inFlight[rel.Version] = true
+
+	go func() {
+		defer func() {
+			pref.lock.Lock()
+			defer pref.lock.Unlock()
+			delete(pref.inFlight, rel.Version)
+		}()
+
+		pref.log.Infof("[etcd] Prefetching upcoming minio release at version %s", rel.Version)
+		err := pref.action(rel)
+		if err != nil {
+			pref.log.Errorf("[etcd] Error prefetching upcoming minio release at version %s: %s", rel.Version, err.Error())
 		}
+	}()
+}
 
-		if (*rel).Version != (*startRel).Version {
-			log.Infof("[etcd] Handling new minio release at version %s", (*rel).Version)
-			
-			pools, _, getErr := GetMinioServerPools(cli, prefix)
-			if getErr != nil {
-				errCh <- getErr
-				close(errCh)
-				return
-			}
+/*
+Processes the update queue in a goroutine whenever it is notified of a change.
+This way, configuration changes keep being queued while an update is in progress and a version published during an update
+supersedes the queued versions of the same kind that were not started yet.
+*/
+type queueProcessor struct {
+	notifyCh chan struct{}
+	//Receives the error that stopped the processing, if any
+	errCh    chan error
+}
 
-			actErr := relAction(rel, pools)
-			if actErr != nil {
-				errCh <- actErr
+func startQueueProcessor(cli *client.EtcdClient, confPrefix string, workspacePrefix string, poolsAction ServerPoolsChangeAction, relAction ReleaseChangeAction, sigs DocumentSignatureConfig, log logger.Logger) *queueProcessor {
+	proc := &queueProcessor{notifyCh: make(chan struct{}, 1), errCh: make(chan error, 1)}
+	go func() {
+		defer close(proc.errCh)
+		for range proc.notifyCh {
+			err := ProcessQueue(cli, confPrefix, workspacePrefix, poolsAction, relAction, sigs, log)
+			if err != nil {
+				proc.errCh <- err
 				return
 			}
 		}
+	}()
+
+	return proc
+}
+
+/*
+Notifies the processor that the queue or the configurations changed, without waiting on the processing.
+Notifications made while the queue is being processed are coalesced into a single pass once it is done.
+*/
+func (proc *queueProcessor) Notify() {
+	select {
+	case proc.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+/*
+Stops the processor once the processing in progress, if any, is done.
+*/
+func (proc *queueProcessor) Stop() {
+	close(proc.notifyCh)
+	go func() {
+		for range proc.errCh {}
+	}()
+}
+
+func syncConfigs(cli *client.EtcdClient, confPrefix string, workspacePrefix string, processor *queueProcessor, prefetcher *releasePrefetcher, sigs DocumentSignatureConfig, log logger.Logger) (int64, error) {
+	_, _, rev, getErr := GetConfigs(cli, confPrefix, sigs)
+	if getErr != nil {
+		return -1, getErr
+	}
 
-		wcCh := cli.Watch(prefix, client.WatchOptions{
-			Revision: rev + 1,
-			IsPrefix: true,
-			TrimPrefix: false,
-		})
+	queueErr := EnqueueConfigs(cli, confPrefix, workspacePrefix, sigs, log)
+	if queueErr != nil {
+		return -1, queueErr
+	}
+
+	processor.Notify()
+
+	nextRel, nextRelErr := GetNextRelease(cli, confPrefix, sigs)
+	if nextRelErr != nil {
+		log.Errorf("[etcd] Error getting the upcoming minio release to prefetch: %s", nextRelErr.Error())
+	} else if nextRel != nil {
+		prefetcher.Prefetch(nextRel)
+	}
+
+	return rev, nil
+}
+
+/*
+Returns whether a configuration document or its signature was upserted in a watch event.
+*/
+func isDocumentUpserted(changes client.WatchInfo, documentKey string) bool {
+	_, docOk := changes.Upserts[documentKey]
+	_, sigOk := changes.Upserts[GetDocumentSignatureKey(documentKey)]
+	return docOk || sigOk
+}
+
+/*
+Watches the configuration prefix and queues the server pools and release configurations when they change.
+The queue is processed in the background, so that changes are queued without waiting on the update in progress.
+Changed configurations whose signatures are not valid are logged and ignored. As the document and its signature are read together,
+a configuration whose signature is written after it is queued once its signature is written.
+*/
+func HandleChanges(cli *client.EtcdClient, confPrefix string, workspacePrefix string, poolsAction ServerPoolsChangeAction, relAction ReleaseChangeAction, prefetchAction ReleasePrefetchAction, sigs DocumentSignatureConfig, log logger.Logger) <-chan error {
+	errCh := make(chan error)
+	go func() {
+		defer close(errCh)
 
-		for info := range wcCh {
-			if info.Error != nil {
-				errCh <- info.Error
-				return	
+		log.Infof("[etcd] Starting to watch for minio release and server pool changes")
+	
+		relConfigKey := fmt.Sprintf(ETCD_RELEASE_CONFIG_KEY, confPrefix)
+		poolsConfigKey := fmt.Sprintf(ETCD_POOLS_CONFIG_KEY, confPrefix)
+		nextRelConfigKey := fmt.Sprintf(ETCD_NEXT_RELEASE_CONFIG_KEY, confPrefix)
+
+		prefetcher := newReleasePrefetcher(prefetchAction, log)
+		processor := startQueueProcessor(cli, confPrefix, workspacePrefix, poolsAction, relAction, sigs, log)
+		defer processor.Stop()
+
+		restarts := uint64(0)
+		for true {
+			rev, syncErr := syncConfigs(cli, confPrefix, workspacePrefix, processor, prefetcher, sigs, log)
+			if syncErr != nil {
+				errCh <- syncErr
+				return
 			}
 
-			log.Debugf("[etcd] Detected a change in configurations keyspace")
+			ctx, cancel := context.WithCancel(cli.Context)
+			wcCh := cli.SetContext(ctx).Watch(confPrefix, client.WatchOptions{
+				Revision: rev + 1,
+				IsPrefix: true,
+				TrimPrefix: false,
+			})
+			stopWatch := func() {
+				cancel()
+				go func() {
+					for range wcCh {}
+				}()
+			}
 
-			for _, val := range info.Changes.Deletions {
-				if val == poolsConfigKey {
-					errCh <- errors.New("Server pools configurations got deleted")
+			var watchErr error
+		WatchLoop:
+			for true {
+				var info client.WatchNotification
+				select {
+				case procErr := <-processor.errCh:
+					stopWatch()
+					errCh <- procErr
 					return
+				case notification, ok := <-wcCh:
+					if !ok {
+						break WatchLoop
+					}
+					info = notification
 				}
-				
-				if val == relConfigKey {
-					errCh <- errors.New("Release configurations got deleted")
-					return
+
+				if info.Error != nil {
+					watchErr = info.Error
+					break
 				}
-			}
 
-			val, ok := info.Changes.Upserts[poolsConfigKey]
-			if ok {
-				pools := MinioServerPools{}
+				restarts = 0
+				log.Debugf("[etcd] Detected a change in configurations keyspace")
 
-				err := yaml.Unmarshal([]byte(val.Value), &pools)
-				if err != nil {
-					errCh <- errors.New(fmt.Sprintf("Error parsing the server pools configuration: %s", err.Error()))
-					return
+				for _, val := range info.Changes.Deletions {
+					if val == poolsConfigKey {
+						stopWatch()
+						errCh <- errors.New("Server pools configurations got deleted")
+						return
+					}
+					
+					if val == relConfigKey {
+						stopWatch()
+						errCh <- errors.New("Release configurations got deleted")
+						return
+					}
 				}
 
-				log.Infof("[etcd] Handling new server pools configuration at version %s", pools.Version)
-	
-				rel, _, getErr := GetMinioRelease(cli, prefix)
-				if getErr != nil {
-					errCh <- getErr
-					return
+				if isDocumentUpserted(info.Changes, poolsConfigKey) {
+					err := EnqueueConfigDocument(cli, confPrefix, workspacePrefix, QUEUE_KIND_POOLS, sigs, log)
+					if errors.Is(err, ErrInvalidDocumentSignature) {
+						log.Errorf("[etcd] Ignoring server pools configuration change: %s", err.Error())
+					} else if err != nil {
+						stopWatch()
+						errCh <- err
+						return
+					}
 				}
-	
-				actErr := poolsAction(&pools, rel)
-				if actErr != nil {
-					errCh <- actErr
-					return
+
+				if isDocumentUpserted(info.Changes, relConfigKey) {
+					err := EnqueueConfigDocument(cli, confPrefix, workspacePrefix, QUEUE_KIND_RELEASE, sigs, log)
+					if errors.Is(err, ErrInvalidDocumentSignature) {
+						log.Errorf("[etcd] Ignoring minio release configuration change: %s", err.Error())
+					} else if err != nil {
+						stopWatch()
+						errCh <- err
+						return
+					}
 				}
-			}
 
-			val, ok = info.Changes.Upserts[relConfigKey]
-			if ok {
-				rel := MinioRelease{}
+				processor.Notify()
 
-				err := yaml.Unmarshal([]byte(val.Value), &rel)
-				if err != nil {
-					errCh <- errors.New(fmt.Sprintf("Error parsing the minio release configuration: %s", err.Error()))
-					return
-				}
-	
-				log.Infof("[etcd] Handling new minio release at version %s", rel.Version)
-	
-				pools, _, getErr := GetMinioServerPools(cli, prefix)
-				if getErr != nil {
-					errCh <- getErr
-					return
-				}
-	
-				actErr := relAction(&rel, pools)
-				if actErr != nil {
-					errCh <- actErr
-					return
+				if isDocumentUpserted(info.Changes, nextRelConfigKey) {
+					nextRel, err := GetNextRelease(cli, confPrefix, sigs)
+					if err != nil {
+						log.Errorf("[etcd] Ignoring the upcoming minio release to prefetch: %s", err.Error())
+						continue
+					}
+
+					if nextRel != nil {
+						prefetcher.Prefetch(nextRel)
+					}
 				}
 			}
+			stopWatch()
+
+			if watchErr == nil {
+				return
+			}
+
+			if restarts >= cli.Retries {
+				errCh <- watchErr
+				return
+			}
+
+			restarts++
+			metrics.IncWatchRestarts()
+			log.Warnf("[etcd] Watch on configurations keyspace failed. Will restart it: %s", watchErr.Error())
+			time.Sleep(cli.RetryInterval)
 		}
 	}()
 
 	return errCh
-}
\ No newline at end of file
+}
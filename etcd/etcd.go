@@ -38,4 +38,19 @@ func GetClient(conf EtcdConfig) (*client.EtcdClient, error)  {
 		RetryInterval:     conf.RetryInterval,
 		Retries:           conf.Retries,
 	})
-}
\ No newline at end of file
+}
+
+/*
+Watches a single key for changes made after the given revision.
+The returned function stops the watch and must be called once the watch is no longer needed.
+*/
+func watchKey(cli *client.EtcdClient, key string, revision int64) (<-chan client.WatchNotification, func()) {
+	ctx, cancel := context.WithCancel(cli.Context)
+	wcCh := cli.SetContext(ctx).Watch(key, client.WatchOptions{Revision: revision + 1})
+	return wcCh, func() {
+		cancel()
+		go func() {
+			for range wcCh {}
+		}()
+	}
+}
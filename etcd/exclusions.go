@@ -0,0 +1,163 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+)
+
+const ETCD_EXCLUSIONS_CONFIG_KEY = "%sexclusions"
+
+type HostExclusion struct {
+	Host   string
+	Reason string
+	//If zero, the exclusion does not expire
+	Expiry time.Time
+}
+
+func (excl *HostExclusion) IsActive(now time.Time) bool {
+	return excl.Expiry.IsZero() || now.Before(excl.Expiry)
+}
+
+type HostExclusions []HostExclusion
+
+func (excls HostExclusions) GetExclusion(host string) (*HostExclusion, bool) {
+	now := time.Now()
+	for idx, excl := range excls {
+		if excl.Host == host && excl.IsActive(now) {
+			return &excls[idx], true
+		}
+	}
+
+	return nil, false
+}
+
+func (excls HostExclusions) IsExcluded(host string) bool {
+	_, excluded := excls.GetExclusion(host)
+	return excluded
+}
+
+/*
+Returns the given hosts without the hosts that are currently excluded.
+*/
+func (excls HostExclusions) FilterHosts(hosts []string) []string {
+	filtered := []string{}
+	for _, host := range hosts {
+		if !excls.IsExcluded(host) {
+			filtered = append(filtered, host)
+		}
+	}
+	return filtered
+}
+
+func parseHostExclusions(value string) (HostExclusions, error) {
+	excls := HostExclusions{}
+
+	err := yaml.Unmarshal([]byte(value), &excls)
+	if err != nil {
+		return excls, errors.New(fmt.Sprintf("Error parsing the host exclusions: %s", err.Error()))
+	}
+
+	return excls, nil
+}
+
+/*
+Returns the hosts excluded from updates in the configuration prefix.
+Expired exclusions are included and should be filtered out with IsActive.
+*/
+func GetHostExclusions(cli *client.EtcdClient, prefix string) (HostExclusions, error) {
+	info, err := cli.GetKey(fmt.Sprintf(ETCD_EXCLUSIONS_CONFIG_KEY, prefix), client.GetKeyOptions{})
+	if err != nil {
+		return HostExclusions{}, err
+	}
+
+	if !info.Found() {
+		return HostExclusions{}, nil
+	}
+
+	return parseHostExclusions(info.Value)
+}
+
+/*
+Waits until the host is no longer excluded, either because its exclusion was removed or because it expired.
+The exclusions key is watched for changes and a timer is only used to wait on the expiry of the exclusion.
+*/
+func WaitOnHostExclusion(cli *client.EtcdClient, prefix string, host string, log logger.Logger) error {
+	key := fmt.Sprintf(ETCD_EXCLUSIONS_CONFIG_KEY, prefix)
+	info, err := cli.GetPrefix(key)
+	if err != nil {
+		return err
+	}
+
+	values := map[string]string{}
+	if val, ok := info.Keys[key]; ok {
+		values[key] = val.Value
+	}
+
+	ctx, cancel := context.WithCancel(cli.Context)
+	wcCh := cli.SetContext(ctx).Watch(key, client.WatchOptions{Revision: info.Revision + 1})
+	defer func() {
+		cancel()
+		go func() {
+			for range wcCh {}
+		}()
+	}()
+
+	logged := false
+	for true {
+		excls, parseErr := parseHostExclusions(values[key])
+		if parseErr != nil {
+			return parseErr
+		}
+
+		excl, excluded := excls.GetExclusion(host)
+		if !excluded {
+			if logged {
+				log.Infof("[etcd] Host %s is no longer excluded from updates", host)
+			}
+			return nil
+		}
+
+		if !logged {
+			if excl.Expiry.IsZero() {
+				log.Infof("[etcd] Host %s is excluded from updates (reason: %s). Waiting for the exclusion to be removed", host, excl.Reason)
+			} else {
+				log.Infof("[etcd] Host %s is excluded from updates until %s (reason: %s). Waiting for the exclusion to be removed or to expire", host, excl.Expiry.Format(time.RFC3339), excl.Reason)
+			}
+			logged = true
+		}
+
+		var expiryCh <-chan time.Time
+		var expiryTimer *time.Timer
+		if !excl.Expiry.IsZero() {
+			expiryTimer = time.NewTimer(time.Until(excl.Expiry))
+			expiryCh = expiryTimer.C
+		}
+
+		select {
+		case res, ok := <-wcCh:
+			if !ok {
+				return errors.New(fmt.Sprintf("Watch on exclusions key %s stopped unexpectedly", key))
+			}
+
+			if res.Error != nil {
+				return res.Error
+			}
+
+			res.Changes.ApplyOn(values)
+		case <-expiryCh:
+		}
+
+		if expiryTimer != nil {
+			expiryTimer.Stop()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package etcd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+	"github.com/Ferlab-Ste-Justine/ferio/pool"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/testutils"
+)
+
+func TestHostExclusions(t *testing.T) {
+	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
+	if launchErr != nil {
+		t.Errorf("Error occured launching test etcd cluster: %s", launchErr.Error())
+		return
+	}
+
+	defer func() {
+		errs := tearDown()
+		if len(errs) > 0 {
+			t.Errorf("Errors occured tearing down etcd cluster: %s", errs[0].Error())
+		}
+	}()
+
+	retryInterval, _ := time.ParseDuration("1s")
+	timeouts, _ := time.ParseDuration("10s")
+	retries := uint64(10)
+	cli := setupTestEnv(t, timeouts, retryInterval, retries)
+
+	excls, exclsErr := GetHostExclusions(cli, "/cfg/")
+	if exclsErr != nil {
+		t.Errorf("Error occured getting host exclusions: %s", exclsErr.Error())
+	}
+
+	if len(excls) != 0 {
+		t.Errorf("Expected no host exclusions when the exclusions key is absent and got: %v", excls)
+	}
+
+	expired := time.Now().Add(-1 * time.Hour).UTC().Format(time.RFC3339)
+	_, putErr := cli.PutKey("/cfg/exclusions", "- host: server2.minio.ferlab.lan\n  reason: disk replacement\n- host: server3.minio.ferlab.lan\n  reason: past maintenance\n  expiry: " + expired + "\n")
+	if putErr != nil {
+		t.Errorf("Error occured putting host exclusions: %s", putErr.Error())
+	}
+
+	excls, exclsErr = GetHostExclusions(cli, "/cfg/")
+	if exclsErr != nil {
+		t.Errorf("Error occured getting host exclusions: %s", exclsErr.Error())
+	}
+
+	if !excls.IsExcluded("server2.minio.ferlab.lan") {
+		t.Errorf("Expected host without exclusion expiry to be excluded")
+	}
+
+	if excls.IsExcluded("server3.minio.ferlab.lan") {
+		t.Errorf("Expected host with an expired exclusion not to be excluded")
+	}
+
+	pools := &MinioServerPools{
+		Version: "v1",
+		Pools: pool.MinioServerPools{
+			pool.MinioServerPool{DomainTemplate: "server%s.minio.ferlab.lan", ServerCountBegin: 1, ServerCountEnd: 3},
+		},
+	}
+	rel := &MinioRelease{Version: "v1"}
+
+	upd, updErr := rel.GetUpdate(cli, "/ws/", pools, excls)
+	if updErr != nil {
+		t.Errorf("Error occured getting release update: %s", updErr.Error())
+	}
+
+	if len(upd.RequiredHosts) != 2 {
+		t.Errorf("Expected excluded hosts not to be required by the release update and required hosts were: %v", upd.RequiredHosts)
+	}
+
+	for _, host := range []string{"server1.minio.ferlab.lan", "server3.minio.ferlab.lan"} {
+		err := MarkTaskDoneBySelf(cli, rel.GetTaskPhases("/ws/")[0].Key, host)
+		if err != nil {
+			t.Errorf("Error occured marking task done: %s", err.Error())
+		}
+	}
+
+	upd, updErr = rel.GetUpdate(cli, "/ws/", pools, excls)
+	if updErr != nil {
+		t.Errorf("Error occured getting release update: %s", updErr.Error())
+	}
+
+	if !upd.DownloadDone {
+		t.Errorf("Expected the binary download phase to be passed once all the hosts that are not excluded completed it")
+	}
+
+	upd, updErr = rel.GetUpdate(cli, "/ws/", pools, HostExclusions{})
+	if updErr != nil {
+		t.Errorf("Error occured getting release update: %s", updErr.Error())
+	}
+
+	if upd.DownloadDone {
+		t.Errorf("Expected the binary download phase not to be passed without exclusions when a host did not complete it")
+	}
+
+	delErr := cli.DeleteKey("/cfg/exclusions")
+	if delErr != nil {
+		t.Errorf("Error occured deleting host exclusions: %s", delErr.Error())
+	}
+
+	waitErr := WaitOnHostExclusion(cli, "/cfg/", "server2.minio.ferlab.lan", logger.Logger{LogLevel: logger.ERROR})
+	if waitErr != nil {
+		t.Errorf("Error occured waiting on host exclusion: %s", waitErr.Error())
+	}
+
+	waitOnExclusion := func(exclusion string, removal func()) {
+		_, putErr := cli.PutKey("/cfg/exclusions", exclusion)
+		if putErr != nil {
+			t.Errorf("Error occured putting host exclusions: %s", putErr.Error())
+		}
+
+		done := make(chan error)
+		go func() {
+			done <- WaitOnHostExclusion(cli, "/cfg/", "server2.minio.ferlab.lan", logger.Logger{LogLevel: logger.ERROR})
+		}()
+
+		time.Sleep(500 * time.Millisecond)
+		removal()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Error occured waiting on host exclusion: %s", err.Error())
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("Expected the wait on the host exclusion to stop shortly after it was lifted and it didn't")
+		}
+	}
+
+	waitOnExclusion("- host: server2.minio.ferlab.lan\n", func() {
+		delErr := cli.DeleteKey("/cfg/exclusions")
+		if delErr != nil {
+			t.Errorf("Error occured deleting host exclusions: %s", delErr.Error())
+		}
+	})
+
+	expiry := time.Now().Add(2 * time.Second).UTC().Format(time.RFC3339Nano)
+	waitOnExclusion("- host: server2.minio.ferlab.lan\n  expiry: " + expiry + "\n", func() {})
+}
@@ -0,0 +1,263 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const ETCD_TASKS_KIND_PREFIX = "%stasks/%s/"
+const ETCD_WORKSPACE_GC_LOCK_KEY = "%sgc/lock"
+const ETCD_QUEUE_DONE_KIND_PREFIX = "%squeue/done/%s/"
+const ETCD_QUEUE_REJECTIONS_KIND_PREFIX = "%squeue/rejections/%s/"
+const ETCD_PREFETCH_VERSIONS_PREFIX = "%sprefetch/"
+
+type WorkspaceGcConfig struct {
+	KeepVersions int           `yaml:"keep_versions"`
+	//Duration during which the instance that ran the garbage collection prevents others from running it again
+	LockTtl      time.Duration `yaml:"lock_ttl"`
+}
+
+func (conf *WorkspaceGcConfig) SetDefaults() {
+	if conf.KeepVersions <= 0 {
+		conf.KeepVersions = 10
+	}
+
+	if conf.LockTtl == 0 {
+		conf.LockTtl = 5 * time.Minute
+	}
+}
+
+type TaskVersion struct {
+	Kind           string
+	Version        string
+	Keys           int
+	CreateRevision int64
+}
+
+/*
+Returns the workspace prefixes under which keys are kept for each version of the kind, with the version as the first path segment after the prefix.
+*/
+func getVersionedPrefixes(prefix string, kind string) []string {
+	prefixes := []string{
+		fmt.Sprintf(ETCD_TASKS_KIND_PREFIX, prefix, kind),
+		fmt.Sprintf(ETCD_QUEUE_DONE_KIND_PREFIX, prefix, kind),
+		fmt.Sprintf(ETCD_QUEUE_REJECTIONS_KIND_PREFIX, prefix, kind),
+	}
+
+	if kind == QUEUE_KIND_RELEASE {
+		prefixes = append(prefixes, fmt.Sprintf(ETCD_PREFETCH_VERSIONS_PREFIX, prefix))
+	}
+
+	return prefixes
+}
+
+func getTaskVersions(cli *client.EtcdClient, prefix string, kind string) ([]TaskVersion, error) {
+	versions := map[string]*TaskVersion{}
+	for _, versionedPrefix := range getVersionedPrefixes(prefix, kind) {
+		info, err := cli.GetPrefix(versionedPrefix)
+		if err != nil {
+			return nil, err
+		}
+
+		for key, val := range info.Keys {
+			version := strings.SplitN(strings.TrimPrefix(key, versionedPrefix), "/", 2)[0]
+
+			taskVersion, ok := versions[version]
+			if !ok {
+				taskVersion = &TaskVersion{Kind: kind, Version: version, CreateRevision: val.CreateRevision}
+				versions[version] = taskVersion
+			}
+
+			taskVersion.Keys++
+			if val.CreateRevision < taskVersion.CreateRevision {
+				taskVersion.CreateRevision = val.CreateRevision
+			}
+		}
+	}
+
+	result := []TaskVersion{}
+	for _, taskVersion := range versions {
+		result = append(result, *taskVersion)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].CreateRevision > result[j].CreateRevision
+	})
+
+	return result, nil
+}
+
+func getDocumentVersion(document string) (string, error) {
+	var doc struct {
+		Version string
+	}
+
+	err := yaml.Unmarshal([]byte(document), &doc)
+	if err != nil {
+		return "", err
+	}
+
+	return doc.Version, nil
+}
+
+/*
+Returns the versions of each kind whose task keys should never be collected: the applied versions, the versions in the configuration prefix (including the next release) and the versions in the update queue.
+*/
+func getProtectedVersions(cli *client.EtcdClient, confPrefix string, workspacePrefix string) (map[string]bool, error) {
+	protected := map[string]bool{}
+
+	documents := []struct {
+		Kind string
+		Key  string
+	}{
+		{QUEUE_KIND_POOLS, fmt.Sprintf(ETCD_POOLS_CONFIG_KEY, confPrefix)},
+		{QUEUE_KIND_RELEASE, fmt.Sprintf(ETCD_RELEASE_CONFIG_KEY, confPrefix)},
+		{QUEUE_KIND_RELEASE, fmt.Sprintf(ETCD_NEXT_RELEASE_CONFIG_KEY, confPrefix)},
+		{QUEUE_KIND_POOLS, fmt.Sprintf(ETCD_APPLIED_POOLS_KEY, workspacePrefix)},
+		{QUEUE_KIND_RELEASE, fmt.Sprintf(ETCD_APPLIED_RELEASE_KEY, workspacePrefix)},
+	}
+
+	for _, document := range documents {
+		value, _, found, err := GetConfigDocument(cli, document.Key)
+		if err != nil {
+			return protected, err
+		}
+
+		if !found {
+			continue
+		}
+
+		version, versionErr := getDocumentVersion(value)
+		if versionErr != nil {
+			return protected, versionErr
+		}
+
+		protected[document.Kind + "/" + version] = true
+	}
+
+	queue, queueErr := GetQueue(cli, workspacePrefix)
+	if queueErr != nil {
+		return protected, queueErr
+	}
+
+	for _, upd := range queue {
+		protected[upd.Kind + "/" + upd.Version] = true
+	}
+
+	return protected, nil
+}
+
+/*
+Returns the versions whose task keys can be deleted from the workspace.
+For each kind, the task keys of the most recent versions are kept, along with the task keys of the applied, configured and queued versions.
+*/
+func GetCollectableTaskVersions(cli *client.EtcdClient, confPrefix string, workspacePrefix string, keepVersions int) ([]TaskVersion, error) {
+	protected, protectedErr := getProtectedVersions(cli, confPrefix, workspacePrefix)
+	if protectedErr != nil {
+		return nil, protectedErr
+	}
+
+	collectable := []TaskVersion{}
+	for _, kind := range []string{QUEUE_KIND_POOLS, QUEUE_KIND_RELEASE} {
+		versions, err := getTaskVersions(cli, workspacePrefix, kind)
+		if err != nil {
+			return nil, err
+		}
+
+		for idx, version := range versions {
+			if idx < keepVersions || protected[kind + "/" + version.Version] {
+				continue
+			}
+
+			collectable = append(collectable, version)
+		}
+	}
+
+	return collectable, nil
+}
+
+/*
+Deletes the task keys of the versions returned by GetCollectableTaskVersions, along with their queue outcome, rejection and prefetch keys, and returns those versions.
+If dryRun is true, the versions are only returned.
+*/
+func CollectTaskVersions(cli *client.EtcdClient, confPrefix string, workspacePrefix string, keepVersions int, dryRun bool, log logger.Logger) ([]TaskVersion, error) {
+	collectable, err := GetCollectableTaskVersions(cli, confPrefix, workspacePrefix, keepVersions)
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		return collectable, nil
+	}
+
+	for _, version := range collectable {
+		log.Infof("[etcd] Deleting %d task, queue and prefetch keys of %s version %s from the workspace", version.Keys, version.Kind, version.Version)
+		ops := []clientv3.Op{}
+		for _, versionedPrefix := range getVersionedPrefixes(workspacePrefix, version.Kind) {
+			ops = append(
+				ops,
+				clientv3.OpDelete(versionedPrefix + version.Version),
+				clientv3.OpDelete(versionedPrefix + version.Version + "/", clientv3.WithPrefix()),
+			)
+		}
+
+		_, delErr := commitTransaction(cli, []clientv3.Cmp{}, ops)
+		if delErr != nil {
+			return nil, delErr
+		}
+	}
+
+	return collectable, nil
+}
+
+func revokeLease(cli *client.EtcdClient, lease clientv3.LeaseID) error {
+	ctx, cancel := context.WithTimeout(cli.Context, cli.RequestTimeout)
+	defer cancel()
+
+	_, err := cli.Client.Revoke(ctx, lease)
+	return err
+}
+
+/*
+Runs the garbage collection of task keys if no other ferio instance ran it within the lock's ttl.
+The instance that gets to create the lock key is elected to run it and the lock is left to expire, so that the other instances completing the same update skip it.
+*/
+func RunWorkspaceGc(cli *client.EtcdClient, confPrefix string, workspacePrefix string, host string, conf WorkspaceGcConfig, log logger.Logger) error {
+	lease, leaseErr := grantLeaseWithRetries(cli, conf.LockTtl, cli.Retries)
+	if leaseErr != nil {
+		return leaseErr
+	}
+
+	lockKey := fmt.Sprintf(ETCD_WORKSPACE_GC_LOCK_KEY, workspacePrefix)
+	elected, lockErr := commitTransaction(
+		cli,
+		[]clientv3.Cmp{clientv3.Compare(clientv3.Version(lockKey), "=", 0)},
+		[]clientv3.Op{clientv3.OpPut(lockKey, host, clientv3.WithLease(lease))},
+	)
+	if lockErr != nil {
+		return lockErr
+	}
+
+	if !elected {
+		revokeErr := revokeLease(cli, lease)
+		if revokeErr != nil {
+			return revokeErr
+		}
+
+		log.Debugf("[etcd] Workspace garbage collection was run recently by another instance. Skipping it")
+		return nil
+	}
+
+	log.Infof("[etcd] Collecting task keys in the workspace, keeping the last %d versions of each kind", conf.KeepVersions)
+	_, err := CollectTaskVersions(cli, confPrefix, workspacePrefix, conf.KeepVersions, false, log)
+	return err
+}
@@ -0,0 +1,175 @@
+package etcd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/testutils"
+)
+
+func TestCollectTaskVersions(t *testing.T) {
+	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
+	if launchErr != nil {
+		t.Errorf("Error occured launching test etcd cluster: %s", launchErr.Error())
+		return
+	}
+
+	defer func() {
+		errs := tearDown()
+		if len(errs) > 0 {
+			t.Errorf("Errors occured tearing down etcd cluster: %s", errs[0].Error())
+		}
+	}()
+
+	retryInterval, _ := time.ParseDuration("1s")
+	timeouts, _ := time.ParseDuration("10s")
+	retries := uint64(10)
+	cli := setupTestEnv(t, timeouts, retryInterval, retries)
+	log := logger.Logger{LogLevel: logger.ERROR}
+
+	for _, version := range []string{"v1", "v2", "v3", "v4", "v5"} {
+		rel := &MinioRelease{Version: version}
+		for _, phase := range rel.GetTaskPhases("/ws/") {
+			for _, host := range []string{"server1", "server2"} {
+				err := MarkTaskDoneBySelf(cli, phase.Key, host)
+				if err != nil {
+					t.Errorf("Error occured marking task done: %s", err.Error())
+				}
+			}
+		}
+	}
+
+	_, putErr := cli.PutKey("/ws/applied/release", "version: v1\n")
+	if putErr != nil {
+		t.Errorf("Error occured putting applied release: %s", putErr.Error())
+	}
+
+	_, putErr = cli.PutKey("/cfg/release", "version: v2\n")
+	if putErr != nil {
+		t.Errorf("Error occured putting release configuration: %s", putErr.Error())
+	}
+
+	versions, err := CollectTaskVersions(cli, "/cfg/", "/ws/", 2, true, log)
+	if err != nil {
+		t.Errorf("Error occured collecting task versions: %s", err.Error())
+	}
+
+	if len(versions) != 1 || versions[0].Version != "v3" || versions[0].Keys != 8 {
+		t.Errorf("Expected only the task keys of release v3 to be collectable and got: %v", versions)
+	}
+
+	info, infoErr := cli.GetPrefix("/ws/tasks/release/v3/")
+	if infoErr != nil {
+		t.Errorf("Error occured getting task keys: %s", infoErr.Error())
+	}
+
+	if len(info.Keys) != 8 {
+		t.Errorf("Expected a dry run not to delete task keys and %d keys remain", len(info.Keys))
+	}
+
+	err = RunWorkspaceGc(cli, "/cfg/", "/ws/", "server1", WorkspaceGcConfig{KeepVersions: 2, LockTtl: time.Minute}, log)
+	if err != nil {
+		t.Errorf("Error occured running workspace garbage collection: %s", err.Error())
+	}
+
+	for version, expected := range map[string]int{"v1": 8, "v2": 8, "v3": 0, "v4": 8, "v5": 8} {
+		info, infoErr = cli.GetPrefix("/ws/tasks/release/" + version + "/")
+		if infoErr != nil {
+			t.Errorf("Error occured getting task keys: %s", infoErr.Error())
+		}
+
+		if len(info.Keys) != expected {
+			t.Errorf("Expected %d task keys to remain for release %s after garbage collection and got %d", expected, version, len(info.Keys))
+		}
+	}
+
+	err = MarkTaskDoneBySelf(cli, (&MinioRelease{Version: "v6"}).GetTaskPhases("/ws/")[0].Key, "server1")
+	if err != nil {
+		t.Errorf("Error occured marking task done: %s", err.Error())
+	}
+
+	err = RunWorkspaceGc(cli, "/cfg/", "/ws/", "server2", WorkspaceGcConfig{KeepVersions: 2, LockTtl: time.Minute}, log)
+	if err != nil {
+		t.Errorf("Error occured running workspace garbage collection: %s", err.Error())
+	}
+
+	info, infoErr = cli.GetPrefix("/ws/tasks/release/v4/")
+	if infoErr != nil {
+		t.Errorf("Error occured getting task keys: %s", infoErr.Error())
+	}
+
+	if len(info.Keys) != 8 {
+		t.Errorf("Expected garbage collection not to run again while another instance holds its lock")
+	}
+}
+
+func TestCollectQueueAndPrefetchKeys(t *testing.T) {
+	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
+	if launchErr != nil {
+		t.Errorf("Error occured launching test etcd cluster: %s", launchErr.Error())
+		return
+	}
+
+	defer func() {
+		errs := tearDown()
+		if len(errs) > 0 {
+			t.Errorf("Errors occured tearing down etcd cluster: %s", errs[0].Error())
+		}
+	}()
+
+	retryInterval, _ := time.ParseDuration("1s")
+	timeouts, _ := time.ParseDuration("10s")
+	retries := uint64(10)
+	cli := setupTestEnv(t, timeouts, retryInterval, retries)
+	log := logger.Logger{LogLevel: logger.ERROR}
+
+	for _, key := range []string{
+		"/ws/queue/done/release/v1",
+		"/ws/queue/done/release/v2",
+		"/ws/queue/rejections/release/v2",
+		"/ws/tasks/release/v2/binary_download/server1",
+		"/ws/prefetch/v2/server1",
+		"/ws/queue/done/pools/v1",
+		"/ws/queue/done/release/v3",
+		"/ws/prefetch/v3/server1",
+		"/ws/prefetch/v4/server1",
+	} {
+		_, putErr := cli.PutKey(key, "value")
+		if putErr != nil {
+			t.Errorf("Error occured putting workspace key: %s", putErr.Error())
+		}
+	}
+
+	_, putErr := cli.PutKey("/cfg/next_release", "version: v1\n")
+	if putErr != nil {
+		t.Errorf("Error occured putting next release configuration: %s", putErr.Error())
+	}
+
+	versions, err := CollectTaskVersions(cli, "/cfg/", "/ws/", 2, false, log)
+	if err != nil {
+		t.Errorf("Error occured collecting task versions: %s", err.Error())
+	}
+
+	if len(versions) != 1 || versions[0].Version != "v2" || versions[0].Keys != 4 {
+		t.Errorf("Expected only the keys of release v2 to be collected and got: %v", versions)
+	}
+
+	info, infoErr := cli.GetPrefix("/ws/")
+	if infoErr != nil {
+		t.Errorf("Error occured getting workspace keys: %s", infoErr.Error())
+	}
+
+	for _, key := range []string{"/ws/queue/done/release/v2", "/ws/queue/rejections/release/v2", "/ws/tasks/release/v2/binary_download/server1", "/ws/prefetch/v2/server1"} {
+		if _, ok := info.Keys[key]; ok {
+			t.Errorf("Expected key %s to be collected and it wasn't", key)
+		}
+	}
+
+	for _, key := range []string{"/ws/queue/done/release/v1", "/ws/queue/done/pools/v1", "/ws/queue/done/release/v3", "/ws/prefetch/v3/server1", "/ws/prefetch/v4/server1"} {
+		if _, ok := info.Keys[key]; !ok {
+			t.Errorf("Expected key %s to be kept and it wasn't", key)
+		}
+	}
+}
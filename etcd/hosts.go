@@ -0,0 +1,156 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const ETCD_HOSTS_PREFIX = "%shosts/"
+const ETCD_HOST_KEY = "%shosts/%s"
+
+type HostRegistryConfig struct {
+	Ttl time.Duration
+}
+
+func (conf *HostRegistryConfig) SetDefaults() {
+	if conf.Ttl == 0 {
+		conf.Ttl = 30 * time.Second
+	}
+}
+
+type HostRegistration struct {
+	Host         string
+	RegisteredAt time.Time `yaml:"registered_at"`
+}
+
+func grantLeaseWithRetries(cli *client.EtcdClient, ttl time.Duration, retries uint64) (clientv3.LeaseID, error) {
+	ctx, cancel := context.WithTimeout(cli.Context, cli.RequestTimeout)
+	defer cancel()
+
+	resp, err := cli.Client.Grant(ctx, int64(ttl.Seconds()))
+	if err != nil {
+		if retries == 0 || !client.ErrorIsRetryable(err) {
+			return 0, err
+		}
+
+		time.Sleep(cli.RetryInterval)
+		return grantLeaseWithRetries(cli, ttl, retries-1)
+	}
+
+	return resp.ID, nil
+}
+
+func keepLeaseAlive(cli *client.EtcdClient, lease clientv3.LeaseID) error {
+	ctx, cancel := context.WithTimeout(cli.Context, cli.RequestTimeout)
+	defer cancel()
+
+	_, err := cli.Client.KeepAliveOnce(ctx, lease)
+	return err
+}
+
+func registerHost(cli *client.EtcdClient, prefix string, host string, ttl time.Duration) (clientv3.LeaseID, error) {
+	lease, leaseErr := grantLeaseWithRetries(cli, ttl, cli.Retries)
+	if leaseErr != nil {
+		return 0, leaseErr
+	}
+
+	output, err := yaml.Marshal(&HostRegistration{Host: host, RegisteredAt: time.Now()})
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("Error serializing host registration: %s", err.Error()))
+	}
+
+	_, err = commitTransaction(
+		cli,
+		[]clientv3.Cmp{},
+		[]clientv3.Op{clientv3.OpPut(fmt.Sprintf(ETCD_HOST_KEY, prefix, host), string(output), clientv3.WithLease(lease))},
+	)
+	return lease, err
+}
+
+/*
+Registers the host in the workspace with a key attached to a lease and keeps the lease alive in the background.
+If the lease expires, the host is registered again.
+An error is returned on the channel if the lease could not be kept alive for longer than the client's retries allow.
+*/
+func RegisterHost(cli *client.EtcdClient, prefix string, host string, conf HostRegistryConfig, log logger.Logger) <-chan error {
+	errCh := make(chan error, 1)
+
+	log.Infof("[etcd] Registering host %s with a lease of %s", host, conf.Ttl.String())
+	lease, regErr := registerHost(cli, prefix, host, conf.Ttl)
+	if regErr != nil {
+		errCh <- regErr
+		return errCh
+	}
+
+	go func() {
+		failures := uint64(0)
+		for true {
+			select {
+			case <-cli.Context.Done():
+				return
+			case <-time.After(conf.Ttl / 3):
+			}
+
+			err := keepLeaseAlive(cli, lease)
+			if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+				log.Warnf("[etcd] Lease of host %s expired. Registering it again", host)
+				lease, err = registerHost(cli, prefix, host, conf.Ttl)
+			}
+
+			if err != nil {
+				failures++
+				if failures > cli.Retries {
+					errCh <- errors.New(fmt.Sprintf("Error keeping the registration of host %s alive: %s", host, err.Error()))
+					return
+				}
+
+				log.Warnf("[etcd] Error keeping the registration of host %s alive: %s", host, err.Error())
+				continue
+			}
+
+			failures = 0
+		}
+	}()
+
+	return errCh
+}
+
+func GetLiveHosts(cli *client.EtcdClient, prefix string) ([]string, error) {
+	hostsPrefix := fmt.Sprintf(ETCD_HOSTS_PREFIX, prefix)
+
+	info, err := cli.GetPrefix(hostsPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := []string{}
+	for key := range info.Keys {
+		hosts = append(hosts, strings.TrimPrefix(key, hostsPrefix))
+	}
+	sort.Strings(hosts)
+
+	return hosts, nil
+}
+
+/*
+Returns the given hosts that are not registered as live in the workspace.
+*/
+func GetDeadHosts(cli *client.EtcdClient, prefix string, hosts []string) ([]string, error) {
+	live, err := GetLiveHosts(cli, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return getMissingHosts(hosts, live), nil
+}
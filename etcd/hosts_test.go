@@ -0,0 +1,67 @@
+package etcd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/testutils"
+)
+
+func TestRegisterHost(t *testing.T) {
+	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
+	if launchErr != nil {
+		t.Errorf("Error occured launching test etcd cluster: %s", launchErr.Error())
+		return
+	}
+
+	defer func() {
+		errs := tearDown()
+		if len(errs) > 0 {
+			t.Errorf("Errors occured tearing down etcd cluster: %s", errs[0].Error())
+		}
+	}()
+
+	retryInterval, _ := time.ParseDuration("1s")
+	timeouts, _ := time.ParseDuration("10s")
+	retries := uint64(10)
+	cli := setupTestEnv(t, timeouts, retryInterval, retries)
+	log := logger.Logger{LogLevel: logger.ERROR}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conf := HostRegistryConfig{Ttl: 3 * time.Second}
+	errCh := RegisterHost(cli.SetContext(ctx), "/ws/", "server1", conf, log)
+
+	time.Sleep(2 * conf.Ttl)
+
+	select {
+	case err := <-errCh:
+		t.Errorf("Error occured keeping host registration alive: %s", err.Error())
+	default:
+	}
+
+	dead, deadErr := GetDeadHosts(cli, "/ws/", []string{"server1", "server2"})
+	if deadErr != nil {
+		t.Errorf("Error occured getting dead hosts: %s", deadErr.Error())
+	}
+
+	if len(dead) != 1 || dead[0] != "server2" {
+		t.Errorf("Expected only the unregistered host to be dead while the registration is kept alive and dead hosts were: %v", dead)
+	}
+
+	cancel()
+	time.Sleep(2 * conf.Ttl)
+
+	live, liveErr := GetLiveHosts(cli, "/ws/")
+	if liveErr != nil {
+		t.Errorf("Error occured getting live hosts: %s", liveErr.Error())
+	}
+
+	if len(live) != 0 {
+		t.Errorf("Expected host registration to expire once it is no longer kept alive and live hosts were: %v", live)
+	}
+}
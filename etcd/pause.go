@@ -0,0 +1,22 @@
+package etcd
+
+import (
+	"fmt"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+)
+
+const ETCD_PAUSED_CONFIG_KEY = "%spaused"
+
+/*
+Returns whether updates are paused cluster-wide by the presence of the paused key in the configuration prefix.
+The value of the key, if any, is returned as the reason of the pause.
+*/
+func IsPaused(cli *client.EtcdClient, prefix string) (bool, string, error) {
+	info, err := cli.GetKey(fmt.Sprintf(ETCD_PAUSED_CONFIG_KEY, prefix), client.GetKeyOptions{})
+	if err != nil {
+		return false, "", err
+	}
+
+	return info.Found(), info.Value, nil
+}
@@ -3,11 +3,14 @@ package etcd
 import (
 	"errors"
 	"fmt"
+	"time"
 	yaml "gopkg.in/yaml.v2"
 
+	"github.com/Ferlab-Ste-Justine/ferio/metrics"
 	"github.com/Ferlab-Ste-Justine/ferio/pool"
 
 	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
 const ETCD_POOLS_CONFIG_KEY = "%spools"
@@ -17,41 +20,157 @@ type MinioServerPools struct {
 	Pools   pool.MinioServerPools
 }
 
-func GetMinioServerPools(cli *client.EtcdClient, prefix string) (*MinioServerPools, int64, error) {
+func GetMinioServerPools(cli *client.EtcdClient, prefix string, sigs DocumentSignatureConfig) (*MinioServerPools, int64, error) {
 	var pools MinioServerPools
 	
-	info, err := cli.GetKey(fmt.Sprintf(ETCD_POOLS_CONFIG_KEY, prefix), client.GetKeyOptions{})
+	value, rev, found, err := GetSignedDocument(cli, fmt.Sprintf(ETCD_POOLS_CONFIG_KEY, prefix), sigs)
 	if err != nil {
 		return nil, -1, err
 	}
 
-	if !info.Found() {
+	if !found {
 		return nil, -1, errors.New("Minio server pools configuration is not set")
 	}
 
-	err = yaml.Unmarshal([]byte(info.Value), &pools)
+	err = yaml.Unmarshal([]byte(value), &pools)
 	if err != nil {
 		return nil, -1, errors.New(fmt.Sprintf("Error parsing the server pools configuration: %s", err.Error()))
 	}
 
-	return &pools, info.ModRevision, nil
+	return &pools, rev, nil
 }
 
 const ETCD_POOLS_TASKS_ACKNOWLEDGMENT_KEY = "%stasks/pools/%s/acknowledgment/"
 const ETCD_POOLS_TASKS_MINIO_SHUTDOWN_KEY = "%stasks/pools/%s/minio_shutdown/"
 const ETCD_POOLS_TASKS_SYSTEMD_UPDATE_KEY = "%stasks/pools/%s/systemd_update/"
+const ETCD_POOLS_TASKS_HEALTH_CHECK_KEY = "%stasks/pools/%s/health_check/"
+const ETCD_POOLS_TASKS_ABORT_KEY = "%stasks/pools/%s/abort"
+const ETCD_POOLS_TASKS_HOSTS_KEY = "%stasks/pools/%s/hosts"
+const ETCD_POOLS_TASKS_JOIN_KEY = "%stasks/pools/%s/join/"
+const ETCD_POOLS_TASKS_WINDOW_KEY = "%stasks/pools/%s/window"
 
-func (pools *MinioServerPools) getTaskKeys(prefix string) (string, string, string) {
+func (pools *MinioServerPools) getTaskKeys(prefix string) (string, string, string, string) {
 	return fmt.Sprintf(ETCD_POOLS_TASKS_ACKNOWLEDGMENT_KEY, prefix, pools.Version),
 	fmt.Sprintf(ETCD_POOLS_TASKS_MINIO_SHUTDOWN_KEY, prefix, pools.Version),
-	fmt.Sprintf(ETCD_POOLS_TASKS_SYSTEMD_UPDATE_KEY, prefix, pools.Version)
+	fmt.Sprintf(ETCD_POOLS_TASKS_SYSTEMD_UPDATE_KEY, prefix, pools.Version),
+	fmt.Sprintf(ETCD_POOLS_TASKS_HEALTH_CHECK_KEY, prefix, pools.Version)
+}
+
+func (pools *MinioServerPools) GetTaskPhases(prefix string) []TaskPhase {
+	ackKey, shutdownKey, systemdKey, healthKey := pools.getTaskKeys(prefix)
+	return []TaskPhase{
+		TaskPhase{"acknowledgment", ackKey},
+		TaskPhase{"minio_shutdown", shutdownKey},
+		TaskPhase{"systemd_update", systemdKey},
+		TaskPhase{"health_check", healthKey},
+	}
+}
+
+func (pools *MinioServerPools) GetAbortKey(prefix string) string {
+	return fmt.Sprintf(ETCD_POOLS_TASKS_ABORT_KEY, prefix, pools.Version)
+}
+
+func (pools *MinioServerPools) GetHealthCheckKey(prefix string) string {
+	return fmt.Sprintf(ETCD_POOLS_TASKS_HEALTH_CHECK_KEY, prefix, pools.Version)
+}
+
+func (pools *MinioServerPools) GetWindowKey(prefix string) string {
+	return fmt.Sprintf(ETCD_POOLS_TASKS_WINDOW_KEY, prefix, pools.Version)
+}
+
+func (pools *MinioServerPools) GetJoinKey(prefix string) string {
+	return fmt.Sprintf(ETCD_POOLS_TASKS_JOIN_KEY, prefix, pools.Version)
+}
+
+/*
+Returns the hosts of the server pools that were already part of the applied server pools.
+*/
+func (pools *MinioServerPools) getHostsOfAppliedPools(cli *client.EtcdClient, prefix string, sigs DocumentSignatureConfig) ([]string, error) {
+	applied, appliedErr := GetAppliedPools(cli, prefix, sigs)
+	if appliedErr != nil {
+		return nil, appliedErr
+	}
+
+	hosts := pools.Pools.GetHosts()
+	if applied != nil {
+		hosts = []string{}
+		for _, host := range pools.Pools.GetHosts() {
+			if _, found := applied.Pools.GetHostPool(host); found {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+
+	return hosts, nil
+}
+
+/*
+Stores the hosts that must complete each phase of the server pools update in the workspace, unless they were already stored.
+It is called when the update is started, so that the hosts remain those of the server pools that were applied at that point for the entire update.
+*/
+func (pools *MinioServerPools) PinRequiredHosts(cli *client.EtcdClient, prefix string, sigs DocumentSignatureConfig) error {
+	hostsKey := fmt.Sprintf(ETCD_POOLS_TASKS_HOSTS_KEY, prefix, pools.Version)
+
+	hosts, hostsErr := pools.getHostsOfAppliedPools(cli, prefix, sigs)
+	if hostsErr != nil {
+		return hostsErr
+	}
+
+	output, err := yaml.Marshal(&hosts)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error serializing the hosts of the server pools update: %s", err.Error()))
+	}
+
+	_, err = commitTransaction(
+		cli,
+		[]clientv3.Cmp{clientv3.Compare(clientv3.Version(hostsKey), "=", 0)},
+		[]clientv3.Op{clientv3.OpPut(hostsKey, string(output))},
+	)
+	return err
+}
+
+/*
+Returns the hosts that must complete each phase of the server pools update.
+Those are the hosts that were already part of the applied server pools when the update started.
+Hosts of the pools added by the update join the update separately, so that existing hosts do not wait on machines that may not be ready yet.
+If the update was not started yet, the hosts are computed from the currently applied server pools without being stored.
+*/
+func (pools *MinioServerPools) GetRequiredHosts(cli *client.EtcdClient, prefix string, sigs DocumentSignatureConfig) ([]string, error) {
+	info, err := cli.GetKey(fmt.Sprintf(ETCD_POOLS_TASKS_HOSTS_KEY, prefix, pools.Version), client.GetKeyOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.Found() {
+		return pools.getHostsOfAppliedPools(cli, prefix, sigs)
+	}
+
+	hosts := []string{}
+	err = yaml.Unmarshal([]byte(info.Value), &hosts)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error parsing the hosts of the server pools update: %s", err.Error()))
+	}
+
+	return hosts, nil
 }
 
 type PoolsUpdate struct {
 	AcknowledgmentDone bool
 	MinioShutdownDone  bool
 	SystemdUpdateDone  bool
+	HealthCheckDone    bool
 	CurrentTaskStatus  *Task
+	RequiredHosts      []string
+}
+
+func (upd *PoolsUpdate) IsRequiredHost(host string) bool {
+	for _, reqHost := range upd.RequiredHosts {
+		if reqHost == host {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (upd *PoolsUpdate) GetTaskKey(prefix string, pools *MinioServerPools) string  {
@@ -59,30 +178,56 @@ func (upd *PoolsUpdate) GetTaskKey(prefix string, pools *MinioServerPools) strin
 		return fmt.Sprintf(ETCD_POOLS_TASKS_ACKNOWLEDGMENT_KEY, prefix, pools.Version)
 	} else if !upd.MinioShutdownDone {
 		return fmt.Sprintf(ETCD_POOLS_TASKS_MINIO_SHUTDOWN_KEY, prefix, pools.Version)
+	} else if !upd.SystemdUpdateDone {
+		return fmt.Sprintf(ETCD_POOLS_TASKS_SYSTEMD_UPDATE_KEY, prefix, pools.Version)
 	}
 
-	return fmt.Sprintf(ETCD_POOLS_TASKS_SYSTEMD_UPDATE_KEY, prefix, pools.Version)
+	return fmt.Sprintf(ETCD_POOLS_TASKS_HEALTH_CHECK_KEY, prefix, pools.Version)
+}
+
+func (upd *PoolsUpdate) GetTaskPhase() string {
+	if !upd.AcknowledgmentDone {
+		return "acknowledgment"
+	} else if !upd.MinioShutdownDone {
+		return "minio_shutdown"
+	} else if !upd.SystemdUpdateDone {
+		return "systemd_update"
+	}
+
+	return "health_check"
 }
 
 func (upd *PoolsUpdate) IsDone() bool {
-	return upd.AcknowledgmentDone && upd.MinioShutdownDone && upd.SystemdUpdateDone
+	return upd.AcknowledgmentDone && upd.MinioShutdownDone && upd.SystemdUpdateDone && upd.HealthCheckDone
 }
 
-func (pools *MinioServerPools) GetUpdate(cli *client.EtcdClient, prefix string) (*PoolsUpdate, error) {
-	ackKey, shutdownKey, systemdKey := pools.getTaskKeys(prefix)
+/*
+Returns the progress of the server pools update.
+Excluded hosts are not required to complete its phases.
+*/
+func (pools *MinioServerPools) GetUpdate(cli *client.EtcdClient, prefix string, excls HostExclusions, sigs DocumentSignatureConfig) (*PoolsUpdate, error) {
+	ackKey, shutdownKey, systemdKey, healthKey := pools.getTaskKeys(prefix)
+
+	allHosts, hostsErr := pools.GetRequiredHosts(cli, prefix, sigs)
+	if hostsErr != nil {
+		return nil, hostsErr
+	}
+	hosts := excls.FilterHosts(allHosts)
 	
-	for _, key := range []string{ackKey, shutdownKey, systemdKey} {
+	for _, key := range []string{ackKey, shutdownKey, systemdKey, healthKey} {
 		tk, _, err := GetTask(cli, key)
 		if err != nil {
 			return nil, err
 		}
 
-		if !tk.CanContinue(pools.Pools.CountHosts()) {
+		if !tk.CanContinueWithHosts(hosts) {
 			return &PoolsUpdate{
 				AcknowledgmentDone: key != ackKey,
 				MinioShutdownDone: key != ackKey && key != shutdownKey,
-				SystemdUpdateDone: false,
+				SystemdUpdateDone: key == healthKey,
+				HealthCheckDone: false,
 				CurrentTaskStatus: tk,
+				RequiredHosts: hosts,
 			}, nil
 		}
 	}
@@ -91,31 +236,51 @@ func (pools *MinioServerPools) GetUpdate(cli *client.EtcdClient, prefix string)
 		AcknowledgmentDone: true,
 		MinioShutdownDone: true,
 		SystemdUpdateDone: true,
+		HealthCheckDone: true,
 		CurrentTaskStatus: nil,
+		RequiredHosts: hosts,
 	}, nil
 }
 
-func (upd *PoolsUpdate) HandleNextTask(cli *client.EtcdClient, prefix string, pools *MinioServerPools, host string, action TaskAction) error {
+func (upd *PoolsUpdate) HandleNextTask(cli *client.EtcdClient, prefix string, pools *MinioServerPools, host string, timeouts BarrierTimeouts, action TaskAction) error {
 	tkKey := upd.GetTaskKey(prefix, pools)
+
+	aborted, abortErr := IsTaskAborted(cli, pools.GetAbortKey(prefix))
+	if abortErr != nil {
+		return abortErr
+	}
+
+	if aborted {
+		return ErrUpdateAborted
+	}
 	
 	if upd.CurrentTaskStatus.HasToDo(host) {
 		err := action()
-		if err != nil {
-			return err
+		if errors.Is(err, ErrTaskFailed) {
+			err = MarkTaskFailedBySelf(cli, tkKey, host)
+		} else if err == nil {
+			err = MarkTaskDoneBySelf(cli, tkKey, host)
 		}
-
-		err = MarkTaskDoneBySelf(cli, tkKey, host)
 		if err != nil {
 			return err
 		}
 	}
 
-	err := WaitOnTaskCompletion(cli, tkKey, pools.Pools.CountHosts())
+	metrics.SetUpdatePhase(QUEUE_KIND_POOLS, pools.Version, upd.GetTaskPhase())
+	waitStart := time.Now()
+	err := WaitOnTaskCompletionWithOptions(cli, tkKey, TaskWaitOptions{
+		Hosts:          upd.RequiredHosts,
+		Host:           host,
+		Timeout:        timeouts.GetTimeout(upd.GetTaskPhase()),
+		AbortKey:       pools.GetAbortKey(prefix),
+		RegistryPrefix: prefix,
+	})
+	metrics.ObserveBarrierWait(QUEUE_KIND_POOLS, upd.GetTaskPhase(), time.Since(waitStart))
 	if err != nil {
 		return err
 	}
 
-	_, shutdownKey, systemdKey := pools.getTaskKeys(prefix)
+	_, shutdownKey, systemdKey, healthKey := pools.getTaskKeys(prefix)
 	if !upd.AcknowledgmentDone {
 		upd.AcknowledgmentDone = true
 		tk, _, err := GetTask(cli, shutdownKey)
@@ -130,10 +295,76 @@ func (upd *PoolsUpdate) HandleNextTask(cli *client.EtcdClient, prefix string, po
 			return err
 		}
 		upd.CurrentTaskStatus = tk
-	} else {
+	} else if !upd.SystemdUpdateDone {
 		upd.SystemdUpdateDone = true
+		tk, _, err := GetTask(cli, healthKey)
+		if err != nil {
+			return err
+		}
+		upd.CurrentTaskStatus = tk
+	} else {
+		upd.HealthCheckDone = true
 		upd.CurrentTaskStatus = nil
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+/*
+Waits until the hosts required by the update completed the given phase, without taking part in it.
+*/
+func (upd *PoolsUpdate) WaitOnPhase(cli *client.EtcdClient, prefix string, pools *MinioServerPools, phase string, host string, timeouts BarrierTimeouts) error {
+	for _, tkPhase := range pools.GetTaskPhases(prefix) {
+		if tkPhase.Name != phase {
+			continue
+		}
+
+		tk, _, err := GetTask(cli, tkPhase.Key)
+		if err != nil {
+			return err
+		}
+
+		if tk.CanContinueWithHosts(upd.RequiredHosts) {
+			return nil
+		}
+
+		return WaitOnTaskCompletionWithOptions(cli, tkPhase.Key, TaskWaitOptions{
+			Hosts:          upd.RequiredHosts,
+			Host:           host,
+			Timeout:        timeouts.GetTimeout(phase),
+			AbortKey:       pools.GetAbortKey(prefix),
+			RegistryPrefix: prefix,
+		})
+	}
+
+	return errors.New(fmt.Sprintf("Unknown server pools update phase %s", phase))
+}
+
+/*
+Runs the action of a host that is added to the cluster by the update and records its outcome in the join task of the update.
+The action is skipped if it already succeeded on the host.
+*/
+func (upd *PoolsUpdate) Join(cli *client.EtcdClient, prefix string, pools *MinioServerPools, host string, action TaskAction) error {
+	members, _, err := cli.GetGroupMembers(fmt.Sprintf(ETCD_TASK_COMPLETERS_PREFIX, pools.GetJoinKey(prefix)))
+	if err != nil {
+		return err
+	}
+
+	if val, ok := members[host]; ok && val == TASK_COMPLETER_DONE {
+		return nil
+	}
+
+	actErr := action()
+	if errors.Is(actErr, ErrTaskFailed) {
+		markErr := MarkTaskFailedBySelf(cli, pools.GetJoinKey(prefix), host)
+		if markErr != nil {
+			return markErr
+		}
+
+		return actErr
+	} else if actErr != nil {
+		return actErr
+	}
+
+	return MarkTaskDoneBySelf(cli, pools.GetJoinKey(prefix), host)
+}
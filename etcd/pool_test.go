@@ -0,0 +1,149 @@
+package etcd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+	"github.com/Ferlab-Ste-Justine/ferio/pool"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/testutils"
+)
+
+func TestPoolsUpdateWithAddedHosts(t *testing.T) {
+	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
+	if launchErr != nil {
+		t.Errorf("Error occured launching test etcd cluster: %s", launchErr.Error())
+		return
+	}
+
+	defer func() {
+		errs := tearDown()
+		if len(errs) > 0 {
+			t.Errorf("Errors occured tearing down etcd cluster: %s", errs[0].Error())
+		}
+	}()
+
+	retryInterval, _ := time.ParseDuration("1s")
+	timeouts, _ := time.ParseDuration("10s")
+	retries := uint64(10)
+	cli := setupTestEnv(t, timeouts, retryInterval, retries)
+	log := logger.Logger{LogLevel: logger.ERROR}
+
+	first := pool.MinioServerPool{DomainTemplate: "server%s.minio.ferlab.lan", ServerCountBegin: 1, ServerCountEnd: 2}
+	second := pool.MinioServerPool{DomainTemplate: "server%s.minio.ferlab.lan", ServerCountBegin: 3, ServerCountEnd: 4}
+
+	err := enqueueTestPools(cli, "/ws/", &MinioServerPools{Version: "v1", Pools: pool.MinioServerPools{first}}, log)
+	if err != nil {
+		t.Errorf("Error occured queuing server pools update: %s", err.Error())
+	}
+
+	queue, queueErr := GetQueue(cli, "/ws/")
+	if queueErr != nil {
+		t.Errorf("Error occured getting update queue: %s", queueErr.Error())
+	}
+
+	err = CompleteQueuedUpdate(cli, "/ws/", &queue[0], QUEUE_OUTCOME_APPLIED)
+	if err != nil {
+		t.Errorf("Error occured completing queued update: %s", err.Error())
+	}
+
+	pools := &MinioServerPools{Version: "v2", Pools: pool.MinioServerPools{first, second}}
+	err = pools.PinRequiredHosts(cli, "/ws/", DocumentSignatureConfig{})
+	if err != nil {
+		t.Errorf("Error occured pinning required hosts: %s", err.Error())
+	}
+
+	upd, updErr := pools.GetUpdate(cli, "/ws/", HostExclusions{}, DocumentSignatureConfig{})
+	if updErr != nil {
+		t.Errorf("Error occured getting server pools update: %s", updErr.Error())
+	}
+
+	if len(upd.RequiredHosts) != 2 || !upd.IsRequiredHost("server1.minio.ferlab.lan") || !upd.IsRequiredHost("server2.minio.ferlab.lan") {
+		t.Errorf("Expected only the hosts of the applied server pools to be required by the update and required hosts were: %v", upd.RequiredHosts)
+	}
+
+	for _, host := range upd.RequiredHosts {
+		for _, phase := range pools.GetTaskPhases("/ws/") {
+			err = MarkTaskDoneBySelf(cli, phase.Key, host)
+			if err != nil {
+				t.Errorf("Error occured marking task done: %s", err.Error())
+			}
+		}
+	}
+
+	err = upd.WaitOnPhase(cli, "/ws/", pools, "systemd_update", "server3.minio.ferlab.lan", BarrierTimeouts{Default: 5 * time.Second})
+	if err != nil {
+		t.Errorf("Error occured waiting on the systemd update phase: %s", err.Error())
+	}
+
+	actions := 0
+	action := func() error {
+		actions++
+		return nil
+	}
+
+	for idx := 0; idx < 2; idx++ {
+		err = upd.Join(cli, "/ws/", pools, "server3.minio.ferlab.lan", action)
+		if err != nil {
+			t.Errorf("Error occured joining server pools update: %s", err.Error())
+		}
+	}
+
+	if actions != 1 {
+		t.Errorf("Expected the join action of an added host to run once and it ran %d times", actions)
+	}
+
+	upd, updErr = pools.GetUpdate(cli, "/ws/", HostExclusions{}, DocumentSignatureConfig{})
+	if updErr != nil {
+		t.Errorf("Error occured getting server pools update: %s", updErr.Error())
+	}
+
+	if !upd.IsDone() {
+		t.Errorf("Expected server pools update to be done once the required hosts completed it, with an added host having joined it")
+	}
+
+	err = enqueueTestPools(cli, "/ws/", pools, log)
+	if err != nil {
+		t.Errorf("Error occured queuing server pools update: %s", err.Error())
+	}
+
+	queue, queueErr = GetQueue(cli, "/ws/")
+	if queueErr != nil {
+		t.Errorf("Error occured getting update queue: %s", queueErr.Error())
+	}
+
+	err = CompleteQueuedUpdate(cli, "/ws/", &queue[0], QUEUE_OUTCOME_APPLIED)
+	if err != nil {
+		t.Errorf("Error occured completing queued update: %s", err.Error())
+	}
+
+	hosts, hostsErr := pools.GetRequiredHosts(cli, "/ws/", DocumentSignatureConfig{})
+	if hostsErr != nil {
+		t.Errorf("Error occured getting required hosts: %s", hostsErr.Error())
+	}
+
+	if len(hosts) != 2 {
+		t.Errorf("Expected the required hosts of an update to remain the same after it is applied and they were: %v", hosts)
+	}
+
+	later := &MinioServerPools{Version: "v3", Pools: pool.MinioServerPools{first, second}}
+	upd, updErr = later.GetUpdate(cli, "/ws/", HostExclusions{}, DocumentSignatureConfig{})
+	if updErr != nil {
+		t.Errorf("Error occured getting server pools update: %s", updErr.Error())
+	}
+
+	if len(upd.RequiredHosts) != 4 {
+		t.Errorf("Expected the required hosts of an update that was not started to be the hosts of the applied server pools and they were: %v", upd.RequiredHosts)
+	}
+
+	info, infoErr := cli.GetKey("/ws/tasks/pools/v3/hosts", client.GetKeyOptions{})
+	if infoErr != nil {
+		t.Errorf("Error occured getting required hosts key: %s", infoErr.Error())
+	}
+
+	if info.Found() {
+		t.Errorf("Expected getting an update that was not started not to pin its required hosts")
+	}
+}
@@ -0,0 +1,63 @@
+package etcd
+
+import (
+	"errors"
+	"fmt"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+)
+
+const ETCD_NEXT_RELEASE_CONFIG_KEY = "%snext_release"
+const ETCD_PREFETCH_PREFIX = "%sprefetch/%s/"
+
+const PREFETCH_READY = "ready"
+
+type ReleasePrefetchAction func(*MinioRelease) error
+
+/*
+Returns the upcoming release to prefetch, or nil if the next release key is not set in the configuration prefix.
+Its signature is verified against the publisher keys like the signature of the release configuration, as its binary is downloaded.
+*/
+func GetNextRelease(cli *client.EtcdClient, prefix string, sigs DocumentSignatureConfig) (*MinioRelease, error) {
+	value, _, found, err := GetSignedDocument(cli, fmt.Sprintf(ETCD_NEXT_RELEASE_CONFIG_KEY, prefix), sigs)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, nil
+	}
+
+	var rel MinioRelease
+	err = yaml.Unmarshal([]byte(value), &rel)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error parsing the next minio release configuration: %s", err.Error()))
+	}
+
+	return &rel, nil
+}
+
+/*
+Records in the workspace that the binary of the release was downloaded and verified on the host.
+*/
+func ReportPrefetch(cli *client.EtcdClient, prefix string, rel *MinioRelease, host string) error {
+	return cli.JoinGroup(fmt.Sprintf(ETCD_PREFETCH_PREFIX, prefix, rel.Version), host, PREFETCH_READY)
+}
+
+/*
+Returns the hosts that reported the binary of the release as prefetched.
+*/
+func GetPrefetchedHosts(cli *client.EtcdClient, prefix string, rel *MinioRelease) ([]string, error) {
+	members, _, err := cli.GetGroupMembers(fmt.Sprintf(ETCD_PREFETCH_PREFIX, prefix, rel.Version))
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := []string{}
+	for member := range members {
+		hosts = append(hosts, member)
+	}
+
+	return hosts, nil
+}
@@ -0,0 +1,193 @@
+package etcd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/testutils"
+)
+
+func TestHandleChangesPrefetch(t *testing.T) {
+	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
+	if launchErr != nil {
+		t.Errorf("Error occured launching test etcd cluster: %s", launchErr.Error())
+		return
+	}
+
+	defer func() {
+		errs := tearDown()
+		if len(errs) > 0 {
+			t.Errorf("Errors occured tearing down etcd cluster: %s", errs[0].Error())
+		}
+	}()
+
+	retryInterval, _ := time.ParseDuration("1s")
+	timeouts, _ := time.ParseDuration("10s")
+	retries := uint64(10)
+	cli := setupTestEnv(t, timeouts, retryInterval, retries)
+	log := logger.Logger{LogLevel: logger.ERROR}
+
+	for key, val := range map[string]string{
+		"/conf/pools": "version: v1\n",
+		"/conf/release": "version: v1\n",
+		"/conf/next_release": "version: v2\n",
+	} {
+		_, putErr := cli.PutKey(key, val)
+		if putErr != nil {
+			t.Errorf("Error occured putting configuration key: %s", putErr.Error())
+		}
+	}
+
+	prefetched := make(chan string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := HandleChanges(
+		cli.SetContext(ctx),
+		"/conf/",
+		"/ws/",
+		func(pools *MinioServerPools, rel *MinioRelease) error {
+			return nil
+		},
+		func(rel *MinioRelease, pools *MinioServerPools) error {
+			return nil
+		},
+		func(rel *MinioRelease) error {
+			err := ReportPrefetch(cli, "/ws/", rel, "server1")
+			prefetched <- rel.Version
+			return err
+		},
+		DocumentSignatureConfig{},
+		log,
+	)
+
+	for _, expected := range []string{"v2", "v3"} {
+		select {
+		case version := <-prefetched:
+			if version != expected {
+				t.Errorf("Expected next release %s to be prefetched and got %s", expected, version)
+			}
+		case err := <-errCh:
+			t.Errorf("Expected changes to be handled until cancellation and got error: %v", err)
+		case <-time.After(10 * time.Second):
+			t.Errorf("Expected next release %s to be prefetched", expected)
+		}
+
+		if expected == "v2" {
+			_, putErr := cli.PutKey("/conf/next_release", "version: v3\n")
+			if putErr != nil {
+				t.Errorf("Error occured putting next release key: %s", putErr.Error())
+			}
+		}
+	}
+
+	hosts, hostsErr := GetPrefetchedHosts(cli, "/ws/", &MinioRelease{Version: "v3"})
+	if hostsErr != nil {
+		t.Errorf("Error occured getting prefetched hosts: %s", hostsErr.Error())
+	}
+
+	if len(hosts) != 1 || hosts[0] != "server1" {
+		t.Errorf("Expected the prefetch of the next release to be reported and the hosts were: %v", hosts)
+	}
+
+	cancel()
+	for range errCh {}
+}
+
+func TestHandleChangesPrefetchInBackground(t *testing.T) {
+	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
+	if launchErr != nil {
+		t.Errorf("Error occured launching test etcd cluster: %s", launchErr.Error())
+		return
+	}
+
+	defer func() {
+		errs := tearDown()
+		if len(errs) > 0 {
+			t.Errorf("Errors occured tearing down etcd cluster: %s", errs[0].Error())
+		}
+	}()
+
+	retryInterval, _ := time.ParseDuration("1s")
+	timeouts, _ := time.ParseDuration("10s")
+	retries := uint64(10)
+	cli := setupTestEnv(t, timeouts, retryInterval, retries)
+	log := logger.Logger{LogLevel: logger.ERROR}
+
+	for key, val := range map[string]string{
+		"/conf/pools": "version: v1\n",
+		"/conf/release": "version: v1\n",
+	} {
+		_, putErr := cli.PutKey(key, val)
+		if putErr != nil {
+			t.Errorf("Error occured putting configuration key: %s", putErr.Error())
+		}
+	}
+
+	applied := make(chan string, 10)
+	prefetches := make(chan string, 10)
+	unblock := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := HandleChanges(
+		cli.SetContext(ctx),
+		"/conf/",
+		"/ws/",
+		func(pools *MinioServerPools, rel *MinioRelease) error {
+			return nil
+		},
+		func(rel *MinioRelease, pools *MinioServerPools) error {
+			applied <- rel.Version
+			return nil
+		},
+		func(rel *MinioRelease) error {
+			prefetches <- rel.Version
+			<-unblock
+			return nil
+		},
+		DocumentSignatureConfig{},
+		log,
+	)
+
+	expect := func(ch chan string, expected string, what string) {
+		select {
+		case version := <-ch:
+			if version != expected {
+				t.Errorf("Expected %s of version %s and got version %s", what, expected, version)
+			}
+		case err := <-errCh:
+			t.Errorf("Expected changes to be handled until cancellation and got error: %v", err)
+		case <-time.After(10 * time.Second):
+			t.Errorf("Expected %s of version %s and it didn't happen", what, expected)
+		}
+	}
+
+	expect(applied, "v1", "release update")
+
+	_, putErr := cli.PutKey("/conf/next_release", "version: v2\n")
+	if putErr != nil {
+		t.Errorf("Error occured putting next release key: %s", putErr.Error())
+	}
+	expect(prefetches, "v2", "prefetch")
+
+	_, putErr = cli.PutKey("/conf/next_release", "version: v2\nurl: https://mirror.ferlab.lan/minio\n")
+	if putErr != nil {
+		t.Errorf("Error occured putting next release key: %s", putErr.Error())
+	}
+
+	_, putErr = cli.PutKey("/conf/release", "version: v2\n")
+	if putErr != nil {
+		t.Errorf("Error occured putting release key: %s", putErr.Error())
+	}
+	expect(applied, "v2", "release update while the prefetch is in progress")
+
+	select {
+	case version := <-prefetches:
+		t.Errorf("Expected release %s not to be prefetched again while its prefetch is in progress", version)
+	default:
+	}
+
+	close(unblock)
+	cancel()
+	for range errCh {}
+}
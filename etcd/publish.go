@@ -0,0 +1,52 @@
+package etcd
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+/*
+Returns the value and modification revision of a configuration key, with a revision of 0 if the key is not set.
+*/
+func GetConfigDocument(cli *client.EtcdClient, key string) (string, int64, bool, error) {
+	info, err := cli.GetKey(key, client.GetKeyOptions{})
+	if err != nil {
+		return "", 0, false, err
+	}
+
+	if !info.Found() {
+		return "", 0, false, nil
+	}
+
+	return info.Value, info.ModRevision, true, nil
+}
+
+/*
+Writes a configuration key only if it was not modified since the given revision.
+A revision of 0 means that the key is expected not to exist.
+The signature of the document, if any, is written in the same transaction. Otherwise, a previous signature is deleted.
+Returns false if the key was modified in the meantime.
+*/
+func PublishConfigDocument(cli *client.EtcdClient, key string, document string, signature []byte, modRevision int64) (bool, error) {
+	sigOp := clientv3.OpDelete(GetDocumentSignatureKey(key))
+	if len(signature) > 0 {
+		sigOp = clientv3.OpPut(GetDocumentSignatureKey(key), base64.StdEncoding.EncodeToString(signature))
+	}
+
+	return commitTransaction(
+		cli,
+		[]clientv3.Cmp{clientv3.Compare(clientv3.ModRevision(key), "=", modRevision)},
+		[]clientv3.Op{clientv3.OpPut(key, document), sigOp},
+	)
+}
+
+func GetReleaseConfigKey(prefix string) string {
+	return fmt.Sprintf(ETCD_RELEASE_CONFIG_KEY, prefix)
+}
+
+func GetPoolsConfigKey(prefix string) string {
+	return fmt.Sprintf(ETCD_POOLS_CONFIG_KEY, prefix)
+}
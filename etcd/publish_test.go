@@ -0,0 +1,75 @@
+package etcd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/testutils"
+)
+
+func TestPublishConfigDocument(t *testing.T) {
+	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
+	if launchErr != nil {
+		t.Errorf("Error occured launching test etcd cluster: %s", launchErr.Error())
+		return
+	}
+
+	defer func() {
+		errs := tearDown()
+		if len(errs) > 0 {
+			t.Errorf("Errors occured tearing down etcd cluster: %s", errs[0].Error())
+		}
+	}()
+
+	retryInterval, _ := time.ParseDuration("1s")
+	timeouts, _ := time.ParseDuration("10s")
+	retries := uint64(10)
+	cli := setupTestEnv(t, timeouts, retryInterval, retries)
+
+	key := GetReleaseConfigKey("/conf/")
+
+	_, rev, found, getErr := GetConfigDocument(cli, key)
+	if getErr != nil {
+		t.Errorf("Error occured getting config document: %s", getErr.Error())
+	}
+
+	if found || rev != 0 {
+		t.Errorf("Expected unset config document to have a revision of 0 and it was %d", rev)
+	}
+
+	published, pubErr := PublishConfigDocument(cli, key, "version: v1", nil, rev)
+	if pubErr != nil {
+		t.Errorf("Error occured publishing config document: %s", pubErr.Error())
+	}
+
+	if !published {
+		t.Errorf("Expected config document to be published when the key is not set and it wasn't")
+	}
+
+	published, pubErr = PublishConfigDocument(cli, key, "version: v2", nil, rev)
+	if pubErr != nil {
+		t.Errorf("Error occured publishing config document: %s", pubErr.Error())
+	}
+
+	if published {
+		t.Errorf("Expected config document not to be published when the key was modified since it was read and it was")
+	}
+
+	doc, rev, found, getErr := GetConfigDocument(cli, key)
+	if getErr != nil {
+		t.Errorf("Error occured getting config document: %s", getErr.Error())
+	}
+
+	if !found || doc != "version: v1" {
+		t.Errorf("Expected config document to be the first published one and it was '%s'", doc)
+	}
+
+	published, pubErr = PublishConfigDocument(cli, key, "version: v2", nil, rev)
+	if pubErr != nil {
+		t.Errorf("Error occured publishing config document: %s", pubErr.Error())
+	}
+
+	if !published {
+		t.Errorf("Expected config document to be published at the revision it was last read and it wasn't")
+	}
+}
@@ -0,0 +1,707 @@
+package etcd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+	"github.com/Ferlab-Ste-Justine/ferio/metrics"
+	"github.com/Ferlab-Ste-Justine/ferio/version"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const ETCD_QUEUE_PREFIX = "%squeue/"
+const ETCD_QUEUE_ENTRIES_PREFIX = "%squeue/entries/"
+const ETCD_QUEUE_ENTRY_KEY = "%squeue/entries/%s/%s"
+const ETCD_QUEUE_STARTED_KEY = "%squeue/started/%s/%s"
+const ETCD_QUEUE_DONE_KEY = "%squeue/done/%s/%s"
+const ETCD_QUEUE_REJECTION_KEY = "%squeue/rejections/%s/%s"
+const ETCD_QUEUE_SIGNATURE_KEY = "%squeue/signatures/%s/%s"
+
+const ETCD_APPLIED_POOLS_KEY = "%sapplied/pools"
+const ETCD_APPLIED_RELEASE_KEY = "%sapplied/release"
+
+const QUEUE_KIND_POOLS = "pools"
+const QUEUE_KIND_RELEASE = "release"
+
+const QUEUE_OUTCOME_APPLIED = "applied"
+const QUEUE_OUTCOME_SUPERSEDED = "superseded"
+const QUEUE_OUTCOME_ABORTED = "aborted"
+const QUEUE_OUTCOME_ROLLED_BACK = "rolled_back"
+const QUEUE_OUTCOME_REJECTED = "rejected"
+
+type QueuedUpdate struct {
+	Kind      string
+	Version   string
+	//Document as it was signed in the configuration prefix
+	Document  string
+	//Signature of the document as it was stored in the configuration prefix, if it was signed
+	Signature string
+	Revision  int64
+}
+
+func (upd *QueuedUpdate) GetPools() (*MinioServerPools, error) {
+	var pools MinioServerPools
+
+	err := yaml.Unmarshal([]byte(upd.Document), &pools)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error parsing the queued server pools configuration: %s", err.Error()))
+	}
+
+	return &pools, nil
+}
+
+func (upd *QueuedUpdate) GetRelease() (*MinioRelease, error) {
+	var rel MinioRelease
+
+	err := yaml.Unmarshal([]byte(upd.Document), &rel)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error parsing the queued minio release configuration: %s", err.Error()))
+	}
+
+	return &rel, nil
+}
+
+/*
+Returns the version of the queued document, after parsing it as the configuration of its kind.
+*/
+func (upd *QueuedUpdate) GetDocumentVersion() (string, error) {
+	if upd.Kind == QUEUE_KIND_RELEASE {
+		rel, err := upd.GetRelease()
+		if err != nil {
+			return "", err
+		}
+
+		return rel.Version, nil
+	}
+
+	pools, err := upd.GetPools()
+	if err != nil {
+		return "", err
+	}
+
+	return pools.Version, nil
+}
+
+func getQueuedUpdateFromKey(prefix string, info client.KeyInfo) (QueuedUpdate, error) {
+	kindAndVersion := strings.TrimPrefix(info.Key, fmt.Sprintf(ETCD_QUEUE_ENTRIES_PREFIX, prefix))
+	parts := strings.SplitN(kindAndVersion, "/", 2)
+	if len(parts) != 2 {
+		return QueuedUpdate{}, errors.New(fmt.Sprintf("Malformed update queue key: %s", info.Key))
+	}
+
+	return QueuedUpdate{
+		Kind:     parts[0],
+		Version:  parts[1],
+		Document: info.Value,
+		Revision: info.CreateRevision,
+	}, nil
+}
+
+/*
+Returns the queued updates in the order they were queued, along with the signatures of their documents.
+*/
+func GetQueue(cli *client.EtcdClient, prefix string) ([]QueuedUpdate, error) {
+	info, err := cli.GetPrefix(fmt.Sprintf(ETCD_QUEUE_PREFIX, prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	queue := []QueuedUpdate{}
+	for key, val := range info.Keys {
+		if !strings.HasPrefix(key, fmt.Sprintf(ETCD_QUEUE_ENTRIES_PREFIX, prefix)) {
+			continue
+		}
+
+		upd, updErr := getQueuedUpdateFromKey(prefix, val)
+		if updErr != nil {
+			return nil, updErr
+		}
+
+		if sig, ok := info.Keys[fmt.Sprintf(ETCD_QUEUE_SIGNATURE_KEY, prefix, upd.Kind, upd.Version)]; ok {
+			upd.Signature = sig.Value
+		}
+		queue = append(queue, upd)
+	}
+
+	sort.SliceStable(queue, func(i, j int) bool {
+		return queue[i].Revision < queue[j].Revision
+	})
+
+	return queue, nil
+}
+
+/*
+Adds an update to the queue, unless it was already queued or processed.
+The signature of the document, if any, is stored alongside it so that the document can be verified again when the update is processed.
+Queued updates of the same kind that were not started yet are superseded by the new update in the same transaction.
+*/
+func enqueueUpdate(cli *client.EtcdClient, prefix string, kind string, version string, document string, signature string, log logger.Logger) (bool, error) {
+	entryKey := fmt.Sprintf(ETCD_QUEUE_ENTRY_KEY, prefix, kind, version)
+	doneKey := fmt.Sprintf(ETCD_QUEUE_DONE_KEY, prefix, kind, version)
+
+	for true {
+		info, err := cli.GetPrefix(fmt.Sprintf(ETCD_QUEUE_PREFIX, prefix))
+		if err != nil {
+			return false, err
+		}
+
+		_, queued := info.Keys[entryKey]
+		_, done := info.Keys[doneKey]
+		if queued || done {
+			return false, nil
+		}
+
+		cmps := []clientv3.Cmp{
+			clientv3.Compare(clientv3.Version(entryKey), "=", 0),
+			clientv3.Compare(clientv3.Version(doneKey), "=", 0),
+		}
+		sigKey := fmt.Sprintf(ETCD_QUEUE_SIGNATURE_KEY, prefix, kind, version)
+		ops := []clientv3.Op{clientv3.OpPut(entryKey, document), clientv3.OpDelete(sigKey)}
+		if signature != "" {
+			ops = []clientv3.Op{clientv3.OpPut(entryKey, document), clientv3.OpPut(sigKey, signature)}
+		}
+		superseded := []string{}
+
+		for key, val := range info.Keys {
+			if !strings.HasPrefix(key, fmt.Sprintf(ETCD_QUEUE_ENTRIES_PREFIX, prefix)) {
+				continue
+			}
+
+			upd, updErr := getQueuedUpdateFromKey(prefix, val)
+			if updErr != nil {
+				return false, updErr
+			}
+
+			if upd.Kind != kind {
+				continue
+			}
+
+			startedKey := fmt.Sprintf(ETCD_QUEUE_STARTED_KEY, prefix, upd.Kind, upd.Version)
+			if _, started := info.Keys[startedKey]; started {
+				continue
+			}
+
+			cmps = append(
+				cmps,
+				clientv3.Compare(clientv3.Version(startedKey), "=", 0),
+				clientv3.Compare(clientv3.ModRevision(key), "=", val.ModRevision),
+			)
+			ops = append(
+				ops,
+				clientv3.OpDelete(key),
+				clientv3.OpDelete(fmt.Sprintf(ETCD_QUEUE_SIGNATURE_KEY, prefix, upd.Kind, upd.Version)),
+				clientv3.OpPut(fmt.Sprintf(ETCD_QUEUE_DONE_KEY, prefix, upd.Kind, upd.Version), QUEUE_OUTCOME_SUPERSEDED),
+			)
+			superseded = append(superseded, upd.Version)
+		}
+
+		succeeded, txErr := commitTransaction(cli, cmps, ops)
+		if txErr != nil {
+			return false, txErr
+		}
+
+		if succeeded {
+			for _, supersededVersion := range superseded {
+				log.Infof("[etcd] Queued %s update at version %s was superseded by version %s before it started", kind, supersededVersion, version)
+			}
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func enqueueDocument(cli *client.EtcdClient, prefix string, kind string, version string, document string, signature string, log logger.Logger) error {
+	queued, queueErr := enqueueUpdate(cli, prefix, kind, version, document, signature, log)
+	if queueErr != nil {
+		return queueErr
+	}
+
+	if queued && kind == QUEUE_KIND_POOLS {
+		log.Infof("[etcd] Queued server pools update at version %s", version)
+	} else if queued {
+		log.Infof("[etcd] Queued minio release update at version %s", version)
+	}
+
+	return nil
+}
+
+/*
+Queues the server pools or release document of the configuration prefix as it was published, along with its signature.
+An error wrapping ErrInvalidDocumentSignature is returned if the signature of the document is not valid.
+*/
+func EnqueueConfigDocument(cli *client.EtcdClient, confPrefix string, workspacePrefix string, kind string, sigs DocumentSignatureConfig, log logger.Logger) error {
+	key := fmt.Sprintf(ETCD_POOLS_CONFIG_KEY, confPrefix)
+	if kind == QUEUE_KIND_RELEASE {
+		key = fmt.Sprintf(ETCD_RELEASE_CONFIG_KEY, confPrefix)
+	}
+
+	doc, sig, _, found, err := getSignedDocument(cli, key, sigs)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return errors.New(fmt.Sprintf("Configuration document %s is not set", key))
+	}
+
+	version, versionErr := (&QueuedUpdate{Kind: kind, Document: doc}).GetDocumentVersion()
+	if versionErr != nil {
+		return versionErr
+	}
+
+	return enqueueDocument(cli, workspacePrefix, kind, version, doc, sig, log)
+}
+
+/*
+Marks a queued update as started. Once started, an update can no longer be superseded.
+Returns false if the update was superseded or completed in the meantime.
+*/
+func StartQueuedUpdate(cli *client.EtcdClient, prefix string, upd *QueuedUpdate) (bool, error) {
+	entryKey := fmt.Sprintf(ETCD_QUEUE_ENTRY_KEY, prefix, upd.Kind, upd.Version)
+	startedKey := fmt.Sprintf(ETCD_QUEUE_STARTED_KEY, prefix, upd.Kind, upd.Version)
+
+	return commitTransaction(
+		cli,
+		[]clientv3.Cmp{clientv3.Compare(clientv3.Version(entryKey), ">", 0)},
+		[]clientv3.Op{clientv3.OpPut(startedKey, "true")},
+	)
+}
+
+/*
+Removes an update from the queue and records its outcome.
+If the update was applied, it also becomes the applied configuration of its kind.
+Calling it on an update that was already removed from the queue does nothing.
+*/
+func CompleteQueuedUpdate(cli *client.EtcdClient, prefix string, upd *QueuedUpdate, outcome string) error {
+	entryKey := fmt.Sprintf(ETCD_QUEUE_ENTRY_KEY, prefix, upd.Kind, upd.Version)
+
+	ops := []clientv3.Op{
+		clientv3.OpDelete(entryKey),
+		clientv3.OpDelete(fmt.Sprintf(ETCD_QUEUE_STARTED_KEY, prefix, upd.Kind, upd.Version)),
+		clientv3.OpDelete(fmt.Sprintf(ETCD_QUEUE_SIGNATURE_KEY, prefix, upd.Kind, upd.Version)),
+		clientv3.OpPut(fmt.Sprintf(ETCD_QUEUE_DONE_KEY, prefix, upd.Kind, upd.Version), outcome),
+	}
+
+	if outcome == QUEUE_OUTCOME_APPLIED {
+		appliedKey := ETCD_APPLIED_POOLS_KEY
+		if upd.Kind == QUEUE_KIND_RELEASE {
+			appliedKey = ETCD_APPLIED_RELEASE_KEY
+		}
+		ops = append(ops, clientv3.OpPut(fmt.Sprintf(appliedKey, prefix), upd.Document))
+
+		//The signature of the document is kept next to the applied document, so that it is verified whenever it is read
+		sigKey := GetDocumentSignatureKey(fmt.Sprintf(appliedKey, prefix))
+		if upd.Signature != "" {
+			ops = append(ops, clientv3.OpPut(sigKey, upd.Signature))
+		} else {
+			ops = append(ops, clientv3.OpDelete(sigKey))
+		}
+	}
+
+	_, err := commitTransaction(
+		cli,
+		[]clientv3.Cmp{clientv3.Compare(clientv3.Version(entryKey), ">", 0)},
+		ops,
+	)
+	return err
+}
+
+const QUEUE_STATE_QUEUED = "queued"
+const QUEUE_STATE_STARTED = "started"
+
+/*
+Returns the state of the update of the given kind and version in the queue.
+It is either queued, started, the outcome the update was completed with or an empty string if the update is not known to the queue.
+*/
+func GetQueuedUpdateState(cli *client.EtcdClient, prefix string, kind string, version string) (string, error) {
+	info, err := cli.GetKey(fmt.Sprintf(ETCD_QUEUE_DONE_KEY, prefix, kind, version), client.GetKeyOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	if info.Found() {
+		return info.Value, nil
+	}
+
+	info, err = cli.GetKey(fmt.Sprintf(ETCD_QUEUE_STARTED_KEY, prefix, kind, version), client.GetKeyOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	if info.Found() {
+		return QUEUE_STATE_STARTED, nil
+	}
+
+	info, err = cli.GetKey(fmt.Sprintf(ETCD_QUEUE_ENTRY_KEY, prefix, kind, version), client.GetKeyOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	if info.Found() {
+		return QUEUE_STATE_QUEUED, nil
+	}
+
+	return "", nil
+}
+
+type QueueRejection struct {
+	Kind      string
+	Version   string
+	Reason    string
+	Timestamp time.Time
+}
+
+/*
+Removes an update that failed validation from the queue without processing it.
+The reason of the rejection is recorded alongside its outcome.
+Calling it on an update that was already removed from the queue does nothing.
+*/
+func RejectQueuedUpdate(cli *client.EtcdClient, prefix string, upd *QueuedUpdate, reason string) error {
+	entryKey := fmt.Sprintf(ETCD_QUEUE_ENTRY_KEY, prefix, upd.Kind, upd.Version)
+
+	rejection := QueueRejection{
+		Kind:      upd.Kind,
+		Version:   upd.Version,
+		Reason:    reason,
+		Timestamp: time.Now(),
+	}
+
+	output, err := yaml.Marshal(&rejection)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error serializing update rejection: %s", err.Error()))
+	}
+
+	_, err = commitTransaction(
+		cli,
+		[]clientv3.Cmp{clientv3.Compare(clientv3.Version(entryKey), ">", 0)},
+		[]clientv3.Op{
+			clientv3.OpDelete(entryKey),
+			clientv3.OpDelete(fmt.Sprintf(ETCD_QUEUE_STARTED_KEY, prefix, upd.Kind, upd.Version)),
+			clientv3.OpDelete(fmt.Sprintf(ETCD_QUEUE_SIGNATURE_KEY, prefix, upd.Kind, upd.Version)),
+			clientv3.OpPut(fmt.Sprintf(ETCD_QUEUE_DONE_KEY, prefix, upd.Kind, upd.Version), QUEUE_OUTCOME_REJECTED),
+			clientv3.OpPut(fmt.Sprintf(ETCD_QUEUE_REJECTION_KEY, prefix, upd.Kind, upd.Version), string(output)),
+		},
+	)
+	return err
+}
+
+func GetQueueRejection(cli *client.EtcdClient, prefix string, kind string, version string) (*QueueRejection, error) {
+	info, err := cli.GetKey(fmt.Sprintf(ETCD_QUEUE_REJECTION_KEY, prefix, kind, version), client.GetKeyOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.Found() {
+		return nil, nil
+	}
+
+	var rejection QueueRejection
+	err = yaml.Unmarshal([]byte(info.Value), &rejection)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error parsing update rejection: %s", err.Error()))
+	}
+
+	return &rejection, nil
+}
+
+func checkVersionIncrease(kind string, newVersion string, appliedVersion string) string {
+	greater, cmpErr := version.IsGreater(newVersion, appliedVersion)
+	if cmpErr != nil {
+		return cmpErr.Error()
+	}
+
+	if !greater {
+		return fmt.Sprintf("Version %s of the %s is not greater than its applied version %s", newVersion, kind, appliedVersion)
+	}
+
+	return ""
+}
+
+/*
+Returns the reason why the document of a queued update cannot be trusted, or an empty string if it can be.
+The document is verified again against the publisher keys, as anyone who can write the workspace could have queued it,
+and its version must match the version it was queued under.
+*/
+func verifyQueuedUpdate(prefix string, upd *QueuedUpdate, sigs DocumentSignatureConfig) (string, error) {
+	entryKey := fmt.Sprintf(ETCD_QUEUE_ENTRY_KEY, prefix, upd.Kind, upd.Version)
+	verifyErr := sigs.VerifyDocument(entryKey, upd.Document, upd.Signature, upd.Signature != "")
+	if errors.Is(verifyErr, ErrInvalidDocumentSignature) {
+		return verifyErr.Error(), nil
+	} else if verifyErr != nil {
+		return "", verifyErr
+	}
+
+	version, versionErr := upd.GetDocumentVersion()
+	if versionErr != nil {
+		return versionErr.Error(), nil
+	}
+
+	if version != upd.Version {
+		return fmt.Sprintf("Version %s of the queued document does not match the version %s it was queued under", version, upd.Version), nil
+	}
+
+	return "", nil
+}
+
+/*
+Returns the reason why a queued update cannot be applied on top of the applied configurations, or an empty string if it can be.
+*/
+func validateQueuedUpdate(cli *client.EtcdClient, prefix string, upd *QueuedUpdate, sigs DocumentSignatureConfig) (string, error) {
+	if upd.Kind == QUEUE_KIND_RELEASE {
+		rel, relErr := upd.GetRelease()
+		if relErr != nil {
+			return "", relErr
+		}
+
+		formatErr := rel.ValidateFormat()
+		if formatErr != nil {
+			return formatErr.Error(), nil
+		}
+
+		applied, appliedErr := GetAppliedRelease(cli, prefix, sigs)
+		if appliedErr != nil {
+			return "", appliedErr
+		}
+
+		if applied == nil || rel.AllowDowngrade {
+			return "", nil
+		}
+
+		return checkVersionIncrease("release", rel.Version, applied.Version), nil
+	}
+
+	pools, poolsErr := upd.GetPools()
+	if poolsErr != nil {
+		return "", poolsErr
+	}
+
+	applied, appliedErr := GetAppliedPools(cli, prefix, sigs)
+	if appliedErr != nil {
+		return "", appliedErr
+	}
+
+	if applied == nil {
+		return "", nil
+	}
+
+	reason := checkVersionIncrease("server pools", pools.Version, applied.Version)
+	if reason != "" {
+		return reason, nil
+	}
+
+	if !pools.Pools.IsAppendOf(applied.Pools) {
+		return fmt.Sprintf("Server pools at version %s remove, reorder or modify pools of the applied server pools at version %s. Only appending new pools is supported", pools.Version, applied.Version), nil
+	}
+
+	return "", nil
+}
+
+/*
+Returns the applied server pools, after verifying their signature against the publisher keys, or nil if no server pools were applied through the queue.
+*/
+func GetAppliedPools(cli *client.EtcdClient, prefix string, sigs DocumentSignatureConfig) (*MinioServerPools, error) {
+	value, _, found, err := GetSignedDocument(cli, fmt.Sprintf(ETCD_APPLIED_POOLS_KEY, prefix), sigs)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, nil
+	}
+
+	upd := QueuedUpdate{Kind: QUEUE_KIND_POOLS, Document: value}
+	return upd.GetPools()
+}
+
+/*
+Returns the applied minio release, after verifying its signature against the publisher keys, or nil if no release was applied through the queue.
+*/
+func GetAppliedRelease(cli *client.EtcdClient, prefix string, sigs DocumentSignatureConfig) (*MinioRelease, error) {
+	value, _, found, err := GetSignedDocument(cli, fmt.Sprintf(ETCD_APPLIED_RELEASE_KEY, prefix), sigs)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, nil
+	}
+
+	upd := QueuedUpdate{Kind: QUEUE_KIND_RELEASE, Document: value}
+	return upd.GetRelease()
+}
+
+/*
+Reports the versions of the applied server pools and release in the metrics.
+*/
+func reportAppliedVersions(cli *client.EtcdClient, prefix string, sigs DocumentSignatureConfig) error {
+	pools, poolsErr := GetAppliedPools(cli, prefix, sigs)
+	if poolsErr != nil {
+		return poolsErr
+	}
+
+	if pools != nil {
+		metrics.SetAppliedVersion(QUEUE_KIND_POOLS, pools.Version)
+	}
+
+	rel, relErr := GetAppliedRelease(cli, prefix, sigs)
+	if relErr != nil {
+		return relErr
+	}
+
+	if rel != nil {
+		metrics.SetAppliedVersion(QUEUE_KIND_RELEASE, rel.Version)
+	}
+
+	return nil
+}
+
+/*
+Processes the queued updates one at a time, in the order they were queued, until the queue is empty.
+Configurations that were never applied through the queue are taken from the configuration prefix.
+Updates whose action returns ErrUpdateAborted or ErrUpdateRolledBack are removed from the queue without becoming the applied configuration.
+*/
+func ProcessQueue(cli *client.EtcdClient, confPrefix string, workspacePrefix string, poolsAction ServerPoolsChangeAction, relAction ReleaseChangeAction, sigs DocumentSignatureConfig, log logger.Logger) error {
+	for true {
+		queue, err := GetQueue(cli, workspacePrefix)
+		if err != nil {
+			return err
+		}
+
+		if len(queue) == 0 {
+			return reportAppliedVersions(cli, workspacePrefix, sigs)
+		}
+
+		upd := queue[0]
+		paused, pauseReason, pausedErr := IsPaused(cli, confPrefix)
+		if pausedErr != nil {
+			return pausedErr
+		}
+
+		if paused {
+			state, stateErr := GetQueuedUpdateState(cli, workspacePrefix, upd.Kind, upd.Version)
+			if stateErr != nil {
+				return stateErr
+			}
+
+			if state != QUEUE_STATE_STARTED {
+				if pauseReason != "" {
+					log.Infof("[etcd] Updates are paused: %s", pauseReason)
+				}
+				log.Infof("[etcd] Updates are paused. %d queued updates will be processed once the paused key is removed", len(queue))
+				return nil
+			}
+
+			log.Infof("[etcd] Updates are paused, but the %s update at version %s was already started. Will complete it", upd.Kind, upd.Version)
+		}
+
+		reason, validErr := verifyQueuedUpdate(workspacePrefix, &upd, sigs)
+		if validErr != nil {
+			return validErr
+		}
+
+		if reason == "" {
+			reason, validErr = validateQueuedUpdate(cli, workspacePrefix, &upd, sigs)
+			if validErr != nil {
+				return validErr
+			}
+		}
+
+		if reason != "" {
+			log.Errorf("[etcd] Rejecting %s update at version %s: %s", upd.Kind, upd.Version, reason)
+			rejectErr := RejectQueuedUpdate(cli, workspacePrefix, &upd, reason)
+			if rejectErr != nil {
+				return rejectErr
+			}
+			continue
+		}
+
+		outcome := QUEUE_OUTCOME_APPLIED
+		started, startErr := StartQueuedUpdate(cli, workspacePrefix, &upd)
+		if startErr != nil {
+			return startErr
+		}
+
+		if !started {
+			continue
+		}
+
+		if upd.Kind == QUEUE_KIND_POOLS {
+			pools, poolsErr := upd.GetPools()
+			if poolsErr != nil {
+				return poolsErr
+			}
+
+			pinErr := pools.PinRequiredHosts(cli, workspacePrefix, sigs)
+			if pinErr != nil {
+				return pinErr
+			}
+
+			rel, relErr := GetAppliedRelease(cli, workspacePrefix, sigs)
+			if relErr != nil {
+				return relErr
+			}
+
+			if rel == nil {
+				rel, _, relErr = GetMinioRelease(cli, confPrefix, sigs)
+				if relErr != nil {
+					return relErr
+				}
+			}
+
+			log.Infof("[etcd] Handling new server pools configuration at version %s", pools.Version)
+			actErr := poolsAction(pools, rel)
+			if errors.Is(actErr, ErrUpdateAborted) {
+				outcome = QUEUE_OUTCOME_ABORTED
+			} else if errors.Is(actErr, ErrUpdateRolledBack) {
+				outcome = QUEUE_OUTCOME_ROLLED_BACK
+			} else if actErr != nil {
+				return actErr
+			}
+		} else if upd.Kind == QUEUE_KIND_RELEASE {
+			rel, relErr := upd.GetRelease()
+			if relErr != nil {
+				return relErr
+			}
+
+			pools, poolsErr := GetAppliedPools(cli, workspacePrefix, sigs)
+			if poolsErr != nil {
+				return poolsErr
+			}
+
+			if pools == nil {
+				pools, _, poolsErr = GetMinioServerPools(cli, confPrefix, sigs)
+				if poolsErr != nil {
+					return poolsErr
+				}
+			}
+
+			log.Infof("[etcd] Handling new minio release at version %s", rel.Version)
+			actErr := relAction(rel, pools)
+			if errors.Is(actErr, ErrUpdateAborted) {
+				outcome = QUEUE_OUTCOME_ABORTED
+			} else if errors.Is(actErr, ErrUpdateRolledBack) {
+				outcome = QUEUE_OUTCOME_ROLLED_BACK
+			} else if actErr != nil {
+				return actErr
+			}
+		} else {
+			return errors.New(fmt.Sprintf("Unknown update kind %s in update queue", upd.Kind))
+		}
+
+		completeErr := CompleteQueuedUpdate(cli, workspacePrefix, &upd, outcome)
+		if completeErr != nil {
+			return completeErr
+		}
+		metrics.ClearUpdatePhase(upd.Kind)
+	}
+
+	return nil
+}
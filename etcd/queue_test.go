@@ -0,0 +1,624 @@
+package etcd
+
+import (
+	"context"
+	"testing"
+	"time"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+	"github.com/Ferlab-Ste-Justine/ferio/pool"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/testutils"
+)
+
+func enqueueTestPools(cli *client.EtcdClient, prefix string, pools *MinioServerPools, log logger.Logger) error {
+	doc, _ := yaml.Marshal(pools)
+	return enqueueDocument(cli, prefix, QUEUE_KIND_POOLS, pools.Version, string(doc), "", log)
+}
+
+func enqueueTestRelease(cli *client.EtcdClient, prefix string, rel *MinioRelease, log logger.Logger) error {
+	doc, _ := yaml.Marshal(rel)
+	return enqueueDocument(cli, prefix, QUEUE_KIND_RELEASE, rel.Version, string(doc), "", log)
+}
+
+func TestEnqueueUpdates(t *testing.T) {
+	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
+	if launchErr != nil {
+		t.Errorf("Error occured launching test etcd cluster: %s", launchErr.Error())
+		return
+	}
+
+	defer func() {
+		errs := tearDown()
+		if len(errs) > 0 {
+			t.Errorf("Errors occured tearing down etcd cluster: %s", errs[0].Error())
+		}
+	}()
+
+	retryInterval, _ := time.ParseDuration("1s")
+	timeouts, _ := time.ParseDuration("10s")
+	retries := uint64(10)
+	cli := setupTestEnv(t, timeouts, retryInterval, retries)
+	log := logger.Logger{LogLevel: logger.ERROR}
+
+	err := enqueueTestPools(cli, "/ws/", &MinioServerPools{Version: "v1"}, log)
+	if err != nil {
+		t.Errorf("Error occured queuing server pools update: %s", err.Error())
+	}
+
+	err = enqueueTestRelease(cli, "/ws/", &MinioRelease{Version: "v1"}, log)
+	if err != nil {
+		t.Errorf("Error occured queuing release update: %s", err.Error())
+	}
+
+	err = enqueueTestPools(cli, "/ws/", &MinioServerPools{Version: "v1"}, log)
+	if err != nil {
+		t.Errorf("Error occured queuing server pools update: %s", err.Error())
+	}
+
+	queue, queueErr := GetQueue(cli, "/ws/")
+	if queueErr != nil {
+		t.Errorf("Error occured getting update queue: %s", queueErr.Error())
+	}
+
+	if len(queue) != 2 || queue[0].Kind != QUEUE_KIND_POOLS || queue[1].Kind != QUEUE_KIND_RELEASE {
+		t.Errorf("Expected queue to contain the server pools update followed by the release update and that was not the case")
+	}
+
+	started, startErr := StartQueuedUpdate(cli, "/ws/", &queue[0])
+	if startErr != nil {
+		t.Errorf("Error occured starting queued update: %s", startErr.Error())
+	}
+
+	if !started {
+		t.Errorf("Expected to be able to start the update at the head of the queue and that was not the case")
+	}
+
+	err = enqueueTestPools(cli, "/ws/", &MinioServerPools{Version: "v2"}, log)
+	if err != nil {
+		t.Errorf("Error occured queuing server pools update: %s", err.Error())
+	}
+
+	err = enqueueTestRelease(cli, "/ws/", &MinioRelease{Version: "v2"}, log)
+	if err != nil {
+		t.Errorf("Error occured queuing release update: %s", err.Error())
+	}
+
+	queue, queueErr = GetQueue(cli, "/ws/")
+	if queueErr != nil {
+		t.Errorf("Error occured getting update queue: %s", queueErr.Error())
+	}
+
+	if len(queue) != 3 || queue[0].Version != "v1" || queue[1].Kind != QUEUE_KIND_POOLS || queue[1].Version != "v2" || queue[2].Kind != QUEUE_KIND_RELEASE || queue[2].Version != "v2" {
+		t.Errorf("Expected started server pools update to remain in the queue and the unstarted release update to be superseded and that was not the case")
+	}
+
+	err = CompleteQueuedUpdate(cli, "/ws/", &queue[0], QUEUE_OUTCOME_APPLIED)
+	if err != nil {
+		t.Errorf("Error occured completing queued update: %s", err.Error())
+	}
+
+	applied, appliedErr := GetAppliedPools(cli, "/ws/", DocumentSignatureConfig{})
+	if appliedErr != nil {
+		t.Errorf("Error occured getting applied server pools: %s", appliedErr.Error())
+	}
+
+	if applied == nil || applied.Version != "v1" {
+		t.Errorf("Expected applied server pools to be at version v1 after completing its update and that was not the case")
+	}
+
+	err = enqueueTestPools(cli, "/ws/", &MinioServerPools{Version: "v1"}, log)
+	if err != nil {
+		t.Errorf("Error occured queuing server pools update: %s", err.Error())
+	}
+
+	queue, queueErr = GetQueue(cli, "/ws/")
+	if queueErr != nil {
+		t.Errorf("Error occured getting update queue: %s", queueErr.Error())
+	}
+
+	if len(queue) != 2 {
+		t.Errorf("Expected an already processed update not to be queued again and that was not the case")
+	}
+
+	for _, expected := range []struct {
+		kind    string
+		version string
+		state   string
+	}{
+		{QUEUE_KIND_POOLS, "v1", QUEUE_OUTCOME_APPLIED},
+		{QUEUE_KIND_RELEASE, "v1", QUEUE_OUTCOME_SUPERSEDED},
+		{QUEUE_KIND_POOLS, "v2", QUEUE_STATE_QUEUED},
+		{QUEUE_KIND_POOLS, "v3", ""},
+	} {
+		state, stateErr := GetQueuedUpdateState(cli, "/ws/", expected.kind, expected.version)
+		if stateErr != nil {
+			t.Errorf("Error occured getting queued update state: %s", stateErr.Error())
+		}
+
+		if state != expected.state {
+			t.Errorf("Expected %s update at version %s to have state '%s' and it was '%s'", expected.kind, expected.version, expected.state, state)
+		}
+	}
+}
+
+func TestProcessQueueRejectsPoolsChanges(t *testing.T) {
+	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
+	if launchErr != nil {
+		t.Errorf("Error occured launching test etcd cluster: %s", launchErr.Error())
+		return
+	}
+
+	defer func() {
+		errs := tearDown()
+		if len(errs) > 0 {
+			t.Errorf("Errors occured tearing down etcd cluster: %s", errs[0].Error())
+		}
+	}()
+
+	retryInterval, _ := time.ParseDuration("1s")
+	timeouts, _ := time.ParseDuration("10s")
+	retries := uint64(10)
+	cli := setupTestEnv(t, timeouts, retryInterval, retries)
+	log := logger.Logger{LogLevel: logger.ERROR}
+
+	first := pool.MinioServerPool{DomainTemplate: "server%s.minio.ferlab.lan", ServerCountBegin: 1, ServerCountEnd: 4}
+	second := pool.MinioServerPool{DomainTemplate: "server%s.minio.ferlab.lan", ServerCountBegin: 5, ServerCountEnd: 8}
+
+	err := enqueueTestPools(cli, "/ws/", &MinioServerPools{Version: "v1", Pools: pool.MinioServerPools{first}}, log)
+	if err != nil {
+		t.Errorf("Error occured queuing server pools update: %s", err.Error())
+	}
+
+	queue, queueErr := GetQueue(cli, "/ws/")
+	if queueErr != nil {
+		t.Errorf("Error occured getting update queue: %s", queueErr.Error())
+	}
+
+	err = CompleteQueuedUpdate(cli, "/ws/", &queue[0], QUEUE_OUTCOME_APPLIED)
+	if err != nil {
+		t.Errorf("Error occured completing queued update: %s", err.Error())
+	}
+
+	err = enqueueTestPools(cli, "/ws/", &MinioServerPools{Version: "v2", Pools: pool.MinioServerPools{second}}, log)
+	if err != nil {
+		t.Errorf("Error occured queuing server pools update: %s", err.Error())
+	}
+
+	actions := 0
+	err = ProcessQueue(
+		cli,
+		"/conf/",
+		"/ws/",
+		func(pools *MinioServerPools, rel *MinioRelease) error {
+			actions++
+			return nil
+		},
+		func(rel *MinioRelease, pools *MinioServerPools) error {
+			actions++
+			return nil
+		},
+		DocumentSignatureConfig{},
+		log,
+	)
+	if err != nil {
+		t.Errorf("Error occured processing update queue: %s", err.Error())
+	}
+
+	if actions != 0 {
+		t.Errorf("Expected server pools update that replaces a pool not to be processed and it was")
+	}
+
+	state, stateErr := GetQueuedUpdateState(cli, "/ws/", QUEUE_KIND_POOLS, "v2")
+	if stateErr != nil {
+		t.Errorf("Error occured getting queued update state: %s", stateErr.Error())
+	}
+
+	if state != QUEUE_OUTCOME_REJECTED {
+		t.Errorf("Expected server pools update that replaces a pool to be rejected and its state was '%s'", state)
+	}
+
+	rejection, rejectionErr := GetQueueRejection(cli, "/ws/", QUEUE_KIND_POOLS, "v2")
+	if rejectionErr != nil {
+		t.Errorf("Error occured getting update rejection: %s", rejectionErr.Error())
+	}
+
+	if rejection == nil || rejection.Reason == "" {
+		t.Errorf("Expected the reason of the server pools update rejection to be recorded and it wasn't")
+	}
+
+	applied, appliedErr := GetAppliedPools(cli, "/ws/", DocumentSignatureConfig{})
+	if appliedErr != nil {
+		t.Errorf("Error occured getting applied server pools: %s", appliedErr.Error())
+	}
+
+	if applied == nil || applied.Version != "v1" {
+		t.Errorf("Expected applied server pools to remain at version v1 after the rejection and that was not the case")
+	}
+}
+
+func TestProcessQueueRejectsDowngrades(t *testing.T) {
+	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
+	if launchErr != nil {
+		t.Errorf("Error occured launching test etcd cluster: %s", launchErr.Error())
+		return
+	}
+
+	defer func() {
+		errs := tearDown()
+		if len(errs) > 0 {
+			t.Errorf("Errors occured tearing down etcd cluster: %s", errs[0].Error())
+		}
+	}()
+
+	retryInterval, _ := time.ParseDuration("1s")
+	timeouts, _ := time.ParseDuration("10s")
+	retries := uint64(10)
+	cli := setupTestEnv(t, timeouts, retryInterval, retries)
+	log := logger.Logger{LogLevel: logger.ERROR}
+
+	pools := &MinioServerPools{Version: "2024-01-01", Pools: pool.MinioServerPools{pool.MinioServerPool{DomainTemplate: "server%s.minio.ferlab.lan", ServerCountBegin: 1, ServerCountEnd: 4}}}
+	err := enqueueTestRelease(cli, "/ws/", &MinioRelease{Version: "RELEASE.2024-05-01T01-11-10Z"}, log)
+	if err != nil {
+		t.Errorf("Error occured queuing release update: %s", err.Error())
+	}
+
+	err = enqueueTestPools(cli, "/ws/", pools, log)
+	if err != nil {
+		t.Errorf("Error occured queuing server pools update: %s", err.Error())
+	}
+
+	queue, queueErr := GetQueue(cli, "/ws/")
+	if queueErr != nil {
+		t.Errorf("Error occured getting update queue: %s", queueErr.Error())
+	}
+
+	for idx := range queue {
+		err = CompleteQueuedUpdate(cli, "/ws/", &queue[idx], QUEUE_OUTCOME_APPLIED)
+		if err != nil {
+			t.Errorf("Error occured completing queued update: %s", err.Error())
+		}
+	}
+
+	err = enqueueTestRelease(cli, "/ws/", &MinioRelease{Version: "RELEASE.2024-04-18T19-09-19Z"}, log)
+	if err != nil {
+		t.Errorf("Error occured queuing release update: %s", err.Error())
+	}
+
+	err = enqueueTestPools(cli, "/ws/", &MinioServerPools{Version: "2023-12-31", Pools: pools.Pools}, log)
+	if err != nil {
+		t.Errorf("Error occured queuing server pools update: %s", err.Error())
+	}
+
+	processed := []string{}
+	process := func() {
+		err := ProcessQueue(
+			cli,
+			"/conf/",
+			"/ws/",
+			func(pools *MinioServerPools, rel *MinioRelease) error {
+				processed = append(processed, pools.Version)
+				return nil
+			},
+			func(rel *MinioRelease, pools *MinioServerPools) error {
+				processed = append(processed, rel.Version)
+				return nil
+			},
+			DocumentSignatureConfig{},
+			log,
+		)
+		if err != nil {
+			t.Errorf("Error occured processing update queue: %s", err.Error())
+		}
+	}
+
+	process()
+	if len(processed) != 0 {
+		t.Errorf("Expected older release and server pools versions not to be processed and they were")
+	}
+
+	for _, kind := range []string{QUEUE_KIND_RELEASE, QUEUE_KIND_POOLS} {
+		version := "RELEASE.2024-04-18T19-09-19Z"
+		if kind == QUEUE_KIND_POOLS {
+			version = "2023-12-31"
+		}
+
+		state, stateErr := GetQueuedUpdateState(cli, "/ws/", kind, version)
+		if stateErr != nil {
+			t.Errorf("Error occured getting queued update state: %s", stateErr.Error())
+		}
+
+		if state != QUEUE_OUTCOME_REJECTED {
+			t.Errorf("Expected older %s version to be rejected and its state was '%s'", kind, state)
+		}
+	}
+
+	err = enqueueTestRelease(cli, "/ws/", &MinioRelease{Version: "RELEASE.2024-04-01T00-00-00Z", AllowDowngrade: true}, log)
+	if err != nil {
+		t.Errorf("Error occured queuing release update: %s", err.Error())
+	}
+
+	process()
+	if len(processed) != 1 || processed[0] != "RELEASE.2024-04-01T00-00-00Z" {
+		t.Errorf("Expected older release version that allows downgrades to be processed and it wasn't")
+	}
+}
+
+func TestProcessQueueRejectsUnsupportedArchives(t *testing.T) {
+	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
+	if launchErr != nil {
+		t.Errorf("Error occured launching test etcd cluster: %s", launchErr.Error())
+		return
+	}
+
+	defer func() {
+		errs := tearDown()
+		if len(errs) > 0 {
+			t.Errorf("Errors occured tearing down etcd cluster: %s", errs[0].Error())
+		}
+	}()
+
+	retryInterval, _ := time.ParseDuration("1s")
+	timeouts, _ := time.ParseDuration("10s")
+	retries := uint64(10)
+	cli := setupTestEnv(t, timeouts, retryInterval, retries)
+	log := logger.Logger{LogLevel: logger.ERROR}
+
+	pools := &MinioServerPools{Version: "v1", Pools: pool.MinioServerPools{pool.MinioServerPool{DomainTemplate: "server%s.minio.ferlab.lan", ServerCountBegin: 1, ServerCountEnd: 4}}}
+	err := enqueueTestPools(cli, "/ws/", pools, log)
+	if err != nil {
+		t.Errorf("Error occured queuing server pools update: %s", err.Error())
+	}
+
+	err = enqueueTestRelease(cli, "/ws/", &MinioRelease{Version: "v1"}, log)
+	if err != nil {
+		t.Errorf("Error occured queuing release update: %s", err.Error())
+	}
+
+	queue, queueErr := GetQueue(cli, "/ws/")
+	if queueErr != nil {
+		t.Errorf("Error occured getting update queue: %s", queueErr.Error())
+	}
+
+	for idx := range queue {
+		err = CompleteQueuedUpdate(cli, "/ws/", &queue[idx], QUEUE_OUTCOME_APPLIED)
+		if err != nil {
+			t.Errorf("Error occured completing queued update: %s", err.Error())
+		}
+	}
+
+	err = enqueueTestRelease(cli, "/ws/", &MinioRelease{Version: "v2", Archive: "rar"}, log)
+	if err != nil {
+		t.Errorf("Error occured queuing release update: %s", err.Error())
+	}
+
+	releases := 0
+	err = ProcessQueue(
+		cli,
+		"/conf/",
+		"/ws/",
+		func(pools *MinioServerPools, rel *MinioRelease) error {
+			return nil
+		},
+		func(rel *MinioRelease, pools *MinioServerPools) error {
+			releases++
+			return nil
+		},
+		DocumentSignatureConfig{},
+		log,
+	)
+	if err != nil {
+		t.Errorf("Error occured processing update queue: %s", err.Error())
+	}
+
+	if releases != 0 {
+		t.Errorf("Expected release with an unsupported archive format not to be processed and it was")
+	}
+
+	state, stateErr := GetQueuedUpdateState(cli, "/ws/", QUEUE_KIND_RELEASE, "v2")
+	if stateErr != nil {
+		t.Errorf("Error occured getting queued update state: %s", stateErr.Error())
+	}
+
+	if state != QUEUE_OUTCOME_REJECTED {
+		t.Errorf("Expected release with an unsupported archive format to be rejected and its state was '%s'", state)
+	}
+}
+
+func TestProcessQueueWhilePaused(t *testing.T) {
+	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
+	if launchErr != nil {
+		t.Errorf("Error occured launching test etcd cluster: %s", launchErr.Error())
+		return
+	}
+
+	defer func() {
+		errs := tearDown()
+		if len(errs) > 0 {
+			t.Errorf("Errors occured tearing down etcd cluster: %s", errs[0].Error())
+		}
+	}()
+
+	retryInterval, _ := time.ParseDuration("1s")
+	timeouts, _ := time.ParseDuration("10s")
+	retries := uint64(10)
+	cli := setupTestEnv(t, timeouts, retryInterval, retries)
+	log := logger.Logger{LogLevel: logger.ERROR}
+
+	_, putErr := cli.PutKey("/conf/pools", "version: v1\n")
+	if putErr != nil {
+		t.Errorf("Error occured putting server pools configuration: %s", putErr.Error())
+	}
+
+	_, putErr = cli.PutKey("/conf/paused", "data migration")
+	if putErr != nil {
+		t.Errorf("Error occured putting paused key: %s", putErr.Error())
+	}
+
+	processed := []string{}
+	process := func() {
+		err := ProcessQueue(
+			cli,
+			"/conf/",
+			"/ws/",
+			func(pools *MinioServerPools, rel *MinioRelease) error {
+				processed = append(processed, pools.Version)
+				return nil
+			},
+			func(rel *MinioRelease, pools *MinioServerPools) error {
+				processed = append(processed, rel.Version)
+				return nil
+			},
+			DocumentSignatureConfig{},
+			log,
+		)
+		if err != nil {
+			t.Errorf("Error occured processing update queue: %s", err.Error())
+		}
+	}
+
+	for _, version := range []string{"v1", "v2"} {
+		err := enqueueTestRelease(cli, "/ws/", &MinioRelease{Version: version}, log)
+		if err != nil {
+			t.Errorf("Error occured queuing release update: %s", err.Error())
+		}
+
+		process()
+	}
+
+	if len(processed) != 0 {
+		t.Errorf("Expected queued updates not to be processed while updates are paused and %v were", processed)
+	}
+
+	state, stateErr := GetQueuedUpdateState(cli, "/ws/", QUEUE_KIND_RELEASE, "v2")
+	if stateErr != nil {
+		t.Errorf("Error occured getting queued update state: %s", stateErr.Error())
+	}
+
+	if state != QUEUE_STATE_QUEUED {
+		t.Errorf("Expected the latest release version to remain queued while updates are paused and its state was '%s'", state)
+	}
+
+	delErr := cli.DeleteKey("/conf/paused")
+	if delErr != nil {
+		t.Errorf("Error occured deleting paused key: %s", delErr.Error())
+	}
+
+	process()
+	if len(processed) != 1 || processed[0] != "v2" {
+		t.Errorf("Expected only the latest release version to be processed once updates are no longer paused and %v were", processed)
+	}
+}
+
+func TestHandleChangesQueuesDuringUpdates(t *testing.T) {
+	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
+	if launchErr != nil {
+		t.Errorf("Error occured launching test etcd cluster: %s", launchErr.Error())
+		return
+	}
+
+	defer func() {
+		errs := tearDown()
+		if len(errs) > 0 {
+			t.Errorf("Errors occured tearing down etcd cluster: %s", errs[0].Error())
+		}
+	}()
+
+	retryInterval, _ := time.ParseDuration("1s")
+	timeouts, _ := time.ParseDuration("10s")
+	retries := uint64(10)
+	cli := setupTestEnv(t, timeouts, retryInterval, retries)
+	log := logger.Logger{LogLevel: logger.ERROR}
+
+	for key, val := range map[string]string{
+		"/conf/pools": "version: v1\n",
+		"/conf/release": "version: v1\n",
+	} {
+		_, putErr := cli.PutKey(key, val)
+		if putErr != nil {
+			t.Errorf("Error occured putting configuration key: %s", putErr.Error())
+		}
+	}
+
+	applied := make(chan string, 10)
+	unblock := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := HandleChanges(
+		cli.SetContext(ctx),
+		"/conf/",
+		"/ws/",
+		func(pools *MinioServerPools, rel *MinioRelease) error {
+			return nil
+		},
+		func(rel *MinioRelease, pools *MinioServerPools) error {
+			applied <- rel.Version
+			if rel.Version == "v2" {
+				<-unblock
+			}
+			return nil
+		},
+		func(rel *MinioRelease) error {
+			return nil
+		},
+		DocumentSignatureConfig{},
+		log,
+	)
+
+	expectApplied := func(expected string) {
+		select {
+		case version := <-applied:
+			if version != expected {
+				t.Errorf("Expected release %s to be applied and got %s", expected, version)
+			}
+		case err := <-errCh:
+			t.Errorf("Expected changes to be handled until cancellation and got error: %v", err)
+		case <-time.After(10 * time.Second):
+			t.Errorf("Expected release %s to be applied and it wasn't", expected)
+		}
+	}
+
+	expectApplied("v1")
+
+	_, putErr := cli.PutKey("/conf/release", "version: v2\n")
+	if putErr != nil {
+		t.Errorf("Error occured putting release key: %s", putErr.Error())
+	}
+	expectApplied("v2")
+
+	for _, version := range []string{"v3", "v4"} {
+		_, putErr = cli.PutKey("/conf/release", "version: " + version + "\n")
+		if putErr != nil {
+			t.Errorf("Error occured putting release key: %s", putErr.Error())
+		}
+
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			state, stateErr := GetQueuedUpdateState(cli, "/ws/", QUEUE_KIND_RELEASE, version)
+			if stateErr != nil {
+				t.Errorf("Error occured getting queued update state: %s", stateErr.Error())
+			}
+
+			if state == QUEUE_STATE_QUEUED {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	state, stateErr := GetQueuedUpdateState(cli, "/ws/", QUEUE_KIND_RELEASE, "v3")
+	if stateErr != nil {
+		t.Errorf("Error occured getting queued update state: %s", stateErr.Error())
+	}
+
+	if state != QUEUE_OUTCOME_SUPERSEDED {
+		t.Errorf("Expected release published during an update to be superseded by a later release and its state was '%s'", state)
+	}
+
+	close(unblock)
+	expectApplied("v4")
+
+	cancel()
+	for range errCh {}
+}
@@ -1,56 +1,299 @@
 package etcd
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"time"
 	yaml "gopkg.in/yaml.v2"
 
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+	"github.com/Ferlab-Ste-Justine/ferio/metrics"
+
 	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
 const ETCD_RELEASE_CONFIG_KEY = "%srelease"
 
+const MIRROR_SELECTION_ORDERED = "ordered"
+const MIRROR_SELECTION_FASTEST = "fastest"
+
+const ARCHIVE_NONE = "none"
+const ARCHIVE_GZIP = "gzip"
+const ARCHIVE_XZ = "xz"
+const ARCHIVE_ZSTD = "zstd"
+const ARCHIVE_TAR_GZ = "tar.gz"
+
+const CHECKSUM_TARGET_ARCHIVE = "archive"
+const CHECKSUM_TARGET_BINARY = "binary"
+
 type MinioRelease struct {
-	Version  string
-	Url      string
-	Checksum string
+	Version         string
+	Url             string
+	//Fallback urls of the binary, tried after the url if it fails
+	Mirrors         []string `yaml:",omitempty"`
+	//Either ordered (the default) or fastest, to try the urls from the fastest to respond to the slowest
+	MirrorSelection string   `yaml:"mirror_selection,omitempty"`
+	//Checksum of the binary, optionally prefixed with its algorithm (ex: sha512:<hash>). Defaults to sha256
+	Checksum        string
+	//Url of a checksum file in the format of the sha256sum command. If the checksum is also set, both must match
+	ChecksumUrl     string   `yaml:"checksum_url,omitempty"`
+	//Url of a detached minisign or ed25519 signature of the binary
+	SignatureUrl    string   `yaml:"signature_url,omitempty"`
+	//Format of the downloaded file: none (the default), gzip, xz, zstd or tar.gz
+	Archive         string   `yaml:"archive,omitempty"`
+	//Path of the binary in a tar.gz archive. Defaults to minio
+	ArchivePath     string   `yaml:"archive_path,omitempty"`
+	//Whether the checksum is over the archive (the default) or the extracted binary
+	ChecksumTarget  string   `yaml:"checksum_target,omitempty"`
+	AllowDowngrade  bool     `yaml:"allow_downgrade,omitempty"`
+	RequireApproval bool     `yaml:"require_approval,omitempty"`
 }
 
-func GetMinioRelease(cli *client.EtcdClient, prefix string) (*MinioRelease, int64, error) {
+func (rel *MinioRelease) IsArchived() bool {
+	return rel.Archive != "" && rel.Archive != ARCHIVE_NONE
+}
+
+/*
+Returns an error if the mirror selection, archive format, archive path or checksum target of the release is not supported.
+It is checked when the release is published and queued, so that it is rejected before it is downloaded on every host.
+*/
+func (rel *MinioRelease) ValidateFormat() error {
+	if rel.MirrorSelection != "" && rel.MirrorSelection != MIRROR_SELECTION_ORDERED && rel.MirrorSelection != MIRROR_SELECTION_FASTEST {
+		return errors.New(fmt.Sprintf("Release mirror selection must be either %s or %s", MIRROR_SELECTION_ORDERED, MIRROR_SELECTION_FASTEST))
+	}
+
+	supportedArchive := false
+	for _, archive := range []string{"", ARCHIVE_NONE, ARCHIVE_GZIP, ARCHIVE_XZ, ARCHIVE_ZSTD, ARCHIVE_TAR_GZ} {
+		if rel.Archive == archive {
+			supportedArchive = true
+		}
+	}
+
+	if !supportedArchive {
+		return errors.New(fmt.Sprintf("Release archive must be one of %s, %s, %s, %s or %s", ARCHIVE_NONE, ARCHIVE_GZIP, ARCHIVE_XZ, ARCHIVE_ZSTD, ARCHIVE_TAR_GZ))
+	}
+
+	if rel.ChecksumTarget != "" && rel.ChecksumTarget != CHECKSUM_TARGET_ARCHIVE && rel.ChecksumTarget != CHECKSUM_TARGET_BINARY {
+		return errors.New(fmt.Sprintf("Release checksum target must be either %s or %s", CHECKSUM_TARGET_ARCHIVE, CHECKSUM_TARGET_BINARY))
+	}
+
+	if rel.ArchivePath != "" && rel.Archive != ARCHIVE_TAR_GZ {
+		return errors.New(fmt.Sprintf("Release archive path is only supported for %s archives", ARCHIVE_TAR_GZ))
+	}
+
+	return nil
+}
+
+/*
+Returns whether the checksum of the release is over the extracted binary rather than the downloaded file.
+*/
+func (rel *MinioRelease) IsBinaryChecksum() bool {
+	return rel.IsArchived() && rel.ChecksumTarget == CHECKSUM_TARGET_BINARY
+}
+
+/*
+Returns the url of the release, if any, followed by its mirrors.
+*/
+func (rel *MinioRelease) GetUrls() []string {
+	urls := []string{}
+	if rel.Url != "" {
+		urls = append(urls, rel.Url)
+	}
+
+	return append(urls, rel.Mirrors...)
+}
+
+func GetMinioRelease(cli *client.EtcdClient, prefix string, sigs DocumentSignatureConfig) (*MinioRelease, int64, error) {
 	var rel MinioRelease
 	
-	info, err := cli.GetKey(fmt.Sprintf(ETCD_RELEASE_CONFIG_KEY, prefix), client.GetKeyOptions{})
+	value, rev, found, err := GetSignedDocument(cli, fmt.Sprintf(ETCD_RELEASE_CONFIG_KEY, prefix), sigs)
 	if err != nil {
 		return nil, -1, err
 	}
 
-	if !info.Found() {
+	if !found {
 		return nil, -1, errors.New("Minio release configuration is not set")
 	}
 
-	err = yaml.Unmarshal([]byte(info.Value), &rel)
+	err = yaml.Unmarshal([]byte(value), &rel)
 	if err != nil {
 		return nil, -1, errors.New(fmt.Sprintf("Error parsing the minio release configuration: %s", err.Error()))
 	}
 
-	return &rel, info.ModRevision, nil
+	return &rel, rev, nil
 }
 
 const ETCD_RELEASE_TASKS_BINARY_DOWNLOAD_KEY = "%stasks/release/%s/binary_download/"
 const ETCD_RELEASE_TASKS_MINIO_SHUTDOWN_KEY = "%stasks/release/%s/minio_shutdown/"
 const ETCD_RELEASE_TASKS_SYSTEMD_UPDATE_KEY = "%stasks/release/%s/systemd_update/"
+const ETCD_RELEASE_TASKS_HEALTH_CHECK_KEY = "%stasks/release/%s/health_check/"
+const ETCD_RELEASE_TASKS_ABORT_KEY = "%stasks/release/%s/abort"
+const ETCD_RELEASE_TASKS_APPROVAL_KEY = "%stasks/release/%s/approval"
+const ETCD_RELEASE_TASKS_CHECKSUM_KEY = "%stasks/release/%s/checksum"
+const ETCD_RELEASE_TASKS_WINDOW_KEY = "%stasks/release/%s/window"
+const ETCD_RELEASE_TASKS_PREFIX = "%stasks/release/%s/"
 
-func (rel *MinioRelease) getTaskKeys(prefix string) (string, string, string) {
+func (rel *MinioRelease) getTaskKeys(prefix string) (string, string, string, string) {
 	return fmt.Sprintf(ETCD_RELEASE_TASKS_BINARY_DOWNLOAD_KEY, prefix, rel.Version),
 	fmt.Sprintf(ETCD_RELEASE_TASKS_MINIO_SHUTDOWN_KEY, prefix, rel.Version),
-	fmt.Sprintf(ETCD_RELEASE_TASKS_SYSTEMD_UPDATE_KEY, prefix, rel.Version)
+	fmt.Sprintf(ETCD_RELEASE_TASKS_SYSTEMD_UPDATE_KEY, prefix, rel.Version),
+	fmt.Sprintf(ETCD_RELEASE_TASKS_HEALTH_CHECK_KEY, prefix, rel.Version)
+}
+
+func (rel *MinioRelease) GetTaskPhases(prefix string) []TaskPhase {
+	downloadKey, shutdownKey, systemdKey, healthKey := rel.getTaskKeys(prefix)
+	return []TaskPhase{
+		TaskPhase{"binary_download", downloadKey},
+		TaskPhase{"minio_shutdown", shutdownKey},
+		TaskPhase{"systemd_update", systemdKey},
+		TaskPhase{"health_check", healthKey},
+	}
+}
+
+func (rel *MinioRelease) GetAbortKey(prefix string) string {
+	return fmt.Sprintf(ETCD_RELEASE_TASKS_ABORT_KEY, prefix, rel.Version)
+}
+
+func (rel *MinioRelease) GetWindowKey(prefix string) string {
+	return fmt.Sprintf(ETCD_RELEASE_TASKS_WINDOW_KEY, prefix, rel.Version)
+}
+
+func (rel *MinioRelease) GetApprovalKey(prefix string) string {
+	return fmt.Sprintf(ETCD_RELEASE_TASKS_APPROVAL_KEY, prefix, rel.Version)
+}
+
+type ReleaseChecksumResolver func(*MinioRelease) (string, error)
+
+/*
+Pins the checksum of a release with a checksum url in the workspace, so that all hosts verify its binary against the same checksum.
+The first host to resolve the checksum pins it and the other hosts use the pinned value instead of fetching it again.
+The release's checksum is set to the pinned value.
+*/
+func (rel *MinioRelease) PinChecksum(cli *client.EtcdClient, prefix string, resolve ReleaseChecksumResolver) error {
+	if rel.ChecksumUrl == "" {
+		return nil
+	}
+
+	pinKey := fmt.Sprintf(ETCD_RELEASE_TASKS_CHECKSUM_KEY, prefix, rel.Version)
+	info, err := cli.GetKey(pinKey, client.GetKeyOptions{})
+	if err != nil {
+		return err
+	}
+
+	if !info.Found() {
+		checksum, resolveErr := resolve(rel)
+		if resolveErr != nil {
+			return resolveErr
+		}
+
+		pinned, pinErr := commitTransaction(
+			cli,
+			[]clientv3.Cmp{clientv3.Compare(clientv3.Version(pinKey), "=", 0)},
+			[]clientv3.Op{clientv3.OpPut(pinKey, checksum)},
+		)
+		if pinErr != nil {
+			return pinErr
+		}
+
+		if !pinned {
+			info, err = cli.GetKey(pinKey, client.GetKeyOptions{})
+			if err != nil {
+				return err
+			}
+		} else {
+			info.Value = checksum
+		}
+	}
+
+	rel.Checksum = info.Value
+	rel.ChecksumUrl = ""
+	return nil
+}
+
+func (rel *MinioRelease) IsApproved(cli *client.EtcdClient, prefix string) (bool, error) {
+	info, err := cli.GetKey(rel.GetApprovalKey(prefix), client.GetKeyOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	return info.Found(), nil
+}
+
+/*
+Waits until the approval key of the release update is created, if the release requires approval.
+ErrUpdateAborted is returned if the abort key of the update is created while waiting.
+Both keys are watched, so that the wait stops as soon as either is created.
+*/
+func (rel *MinioRelease) WaitOnApproval(cli *client.EtcdClient, prefix string, log logger.Logger) error {
+	if !rel.RequireApproval {
+		return nil
+	}
+
+	approvalKey := rel.GetApprovalKey(prefix)
+	abortKey := rel.GetAbortKey(prefix)
+	tasksPrefix := fmt.Sprintf(ETCD_RELEASE_TASKS_PREFIX, prefix, rel.Version)
+
+	info, err := cli.GetPrefix(tasksPrefix)
+	if err != nil {
+		return err
+	}
+
+	if _, approved := info.Keys[approvalKey]; approved {
+		return nil
+	}
+
+	if _, aborted := info.Keys[abortKey]; aborted {
+		return ErrUpdateAborted
+	}
+
+	metrics.SetUpdatePhase(QUEUE_KIND_RELEASE, rel.Version, "approval")
+	log.Infof("[etcd] Release update at version %s requires approval. Waiting for key %s to be created before stopping minio", rel.Version, approvalKey)
+
+	ctx, cancel := context.WithCancel(cli.Context)
+	wcCh := cli.SetContext(ctx).Watch(tasksPrefix, client.WatchOptions{IsPrefix: true, Revision: info.Revision + 1})
+	defer func() {
+		cancel()
+		go func() {
+			for range wcCh {}
+		}()
+	}()
+
+	for true {
+		res, ok := <-wcCh
+		if !ok {
+			return errors.New(fmt.Sprintf("Watch on approval key %s stopped unexpectedly", approvalKey))
+		}
+
+		if res.Error != nil {
+			return res.Error
+		}
+
+		if _, approved := res.Changes.Upserts[approvalKey]; approved {
+			log.Infof("[etcd] Release update at version %s was approved", rel.Version)
+			return nil
+		}
+
+		if _, aborted := res.Changes.Upserts[abortKey]; aborted {
+			return ErrUpdateAborted
+		}
+	}
+
+	return nil
+}
+
+func (rel *MinioRelease) GetHealthCheckKey(prefix string) string {
+	return fmt.Sprintf(ETCD_RELEASE_TASKS_HEALTH_CHECK_KEY, prefix, rel.Version)
 }
 
 type ReleaseUpdate struct {
 	DownloadDone       bool
 	MinioShutdownDone  bool
 	SystemdUpdateDone  bool
+	HealthCheckDone    bool
 	CurrentTaskStatus  *Task
+	RequiredHosts      []string
 }
 
 func (upd *ReleaseUpdate) GetTaskKey(prefix string, rel *MinioRelease) string  {
@@ -58,30 +301,51 @@ func (upd *ReleaseUpdate) GetTaskKey(prefix string, rel *MinioRelease) string  {
 		return fmt.Sprintf(ETCD_RELEASE_TASKS_BINARY_DOWNLOAD_KEY, prefix, rel.Version)
 	} else if !upd.MinioShutdownDone {
 		return fmt.Sprintf(ETCD_RELEASE_TASKS_MINIO_SHUTDOWN_KEY, prefix, rel.Version)
+	} else if !upd.SystemdUpdateDone {
+		return fmt.Sprintf(ETCD_RELEASE_TASKS_SYSTEMD_UPDATE_KEY, prefix, rel.Version)
 	}
 
-	return fmt.Sprintf(ETCD_RELEASE_TASKS_SYSTEMD_UPDATE_KEY, prefix, rel.Version)
+	return fmt.Sprintf(ETCD_RELEASE_TASKS_HEALTH_CHECK_KEY, prefix, rel.Version)
+}
+
+func (upd *ReleaseUpdate) GetTaskPhase() string {
+	if !upd.DownloadDone {
+		return "binary_download"
+	} else if !upd.MinioShutdownDone {
+		return "minio_shutdown"
+	} else if !upd.SystemdUpdateDone {
+		return "systemd_update"
+	}
+
+	return "health_check"
 }
 
 func (upd *ReleaseUpdate) IsDone() bool {
-	return upd.DownloadDone && upd.MinioShutdownDone && upd.SystemdUpdateDone
+	return upd.DownloadDone && upd.MinioShutdownDone && upd.SystemdUpdateDone && upd.HealthCheckDone
 }
 
-func (rel *MinioRelease) GetUpdate(cli *client.EtcdClient, prefix string, pools *MinioServerPools) (*ReleaseUpdate, error) {
-	downloadKey, shutdownKey, systemdKey := rel.getTaskKeys(prefix)
+/*
+Returns the progress of the release update across the hosts of the server pools.
+Excluded hosts are not required to complete its phases.
+*/
+func (rel *MinioRelease) GetUpdate(cli *client.EtcdClient, prefix string, pools *MinioServerPools, excls HostExclusions) (*ReleaseUpdate, error) {
+	downloadKey, shutdownKey, systemdKey, healthKey := rel.getTaskKeys(prefix)
+	hosts := excls.FilterHosts(pools.Pools.GetHosts())
 
-	for _, key := range []string{downloadKey, shutdownKey, systemdKey} {
+	for _, key := range []string{downloadKey, shutdownKey, systemdKey, healthKey} {
 		tk, _, err := GetTask(cli, key)
 		if err != nil {
 			return nil, err
 		}
 
-		if !tk.CanContinue(pools.Pools.CountHosts()) {
+		if !tk.CanContinueWithHosts(hosts) {
 			return &ReleaseUpdate{
 				DownloadDone:      key != downloadKey,
 				MinioShutdownDone: key != downloadKey && key != shutdownKey,
-				SystemdUpdateDone: false,
+				SystemdUpdateDone: key == healthKey,
+				HealthCheckDone:   false,
 				CurrentTaskStatus: tk,
+				RequiredHosts:     hosts,
 			}, nil
 		}
 	}
@@ -90,31 +354,51 @@ func (rel *MinioRelease) GetUpdate(cli *client.EtcdClient, prefix string, pools
 		DownloadDone:      true,
 		MinioShutdownDone: true,
 		SystemdUpdateDone: true,
+		HealthCheckDone:   true,
 		CurrentTaskStatus: nil,
+		RequiredHosts:     hosts,
 	}, nil
 }
 
-func (upd *ReleaseUpdate) HandleNextTask(cli *client.EtcdClient, prefix string, rel *MinioRelease, pools *MinioServerPools, host string, action TaskAction) error {	
+func (upd *ReleaseUpdate) HandleNextTask(cli *client.EtcdClient, prefix string, rel *MinioRelease, pools *MinioServerPools, host string, timeouts BarrierTimeouts, action TaskAction) error {	
 	tkKey := upd.GetTaskKey(prefix, rel)
+
+	aborted, abortErr := IsTaskAborted(cli, rel.GetAbortKey(prefix))
+	if abortErr != nil {
+		return abortErr
+	}
+
+	if aborted {
+		return ErrUpdateAborted
+	}
 	
 	if upd.CurrentTaskStatus.HasToDo(host) {
 		err := action()
-		if err != nil {
-			return err
+		if errors.Is(err, ErrTaskFailed) {
+			err = MarkTaskFailedBySelf(cli, tkKey, host)
+		} else if err == nil {
+			err = MarkTaskDoneBySelf(cli, tkKey, host)
 		}
-
-		err = MarkTaskDoneBySelf(cli, tkKey, host)
 		if err != nil {
 			return err
 		}
 	}
 
-	err := WaitOnTaskCompletion(cli, tkKey, pools.Pools.CountHosts())
+	metrics.SetUpdatePhase(QUEUE_KIND_RELEASE, rel.Version, upd.GetTaskPhase())
+	waitStart := time.Now()
+	err := WaitOnTaskCompletionWithOptions(cli, tkKey, TaskWaitOptions{
+		Hosts:          upd.RequiredHosts,
+		Host:           host,
+		Timeout:        timeouts.GetTimeout(upd.GetTaskPhase()),
+		AbortKey:       rel.GetAbortKey(prefix),
+		RegistryPrefix: prefix,
+	})
+	metrics.ObserveBarrierWait(QUEUE_KIND_RELEASE, upd.GetTaskPhase(), time.Since(waitStart))
 	if err != nil {
 		return err
 	}
 
-	_, shutdownKey, systemdKey := rel.getTaskKeys(prefix)
+	_, shutdownKey, systemdKey, healthKey := rel.getTaskKeys(prefix)
 	if !upd.DownloadDone {
 		upd.DownloadDone = true
 		tk, _, err := GetTask(cli, shutdownKey)
@@ -129,10 +413,17 @@ func (upd *ReleaseUpdate) HandleNextTask(cli *client.EtcdClient, prefix string,
 			return err
 		}
 		upd.CurrentTaskStatus = tk
-	} else {
+	} else if !upd.SystemdUpdateDone {
 		upd.SystemdUpdateDone = true
+		tk, _, err := GetTask(cli, healthKey)
+		if err != nil {
+			return err
+		}
+		upd.CurrentTaskStatus = tk
+	} else {
+		upd.HealthCheckDone = true
 		upd.CurrentTaskStatus = nil
 	}
 
 	return nil
-}
\ No newline at end of file
+}
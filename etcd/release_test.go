@@ -0,0 +1,162 @@
+package etcd
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/testutils"
+)
+
+func TestReleaseWaitOnApproval(t *testing.T) {
+	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
+	if launchErr != nil {
+		t.Errorf("Error occured launching test etcd cluster: %s", launchErr.Error())
+		return
+	}
+
+	defer func() {
+		errs := tearDown()
+		if len(errs) > 0 {
+			t.Errorf("Errors occured tearing down etcd cluster: %s", errs[0].Error())
+		}
+	}()
+
+	retryInterval, _ := time.ParseDuration("1s")
+	timeouts, _ := time.ParseDuration("10s")
+	retries := uint64(10)
+	cli := setupTestEnv(t, timeouts, retryInterval, retries)
+	log := logger.Logger{LogLevel: logger.ERROR}
+
+	err := (&MinioRelease{Version: "v1"}).WaitOnApproval(cli, "/ws/", log)
+	if err != nil {
+		t.Errorf("Expected a release that does not require approval not to wait on it and got error: %s", err.Error())
+	}
+
+	rel := &MinioRelease{Version: "v2", RequireApproval: true}
+	_, putErr := cli.PutKey(rel.GetApprovalKey("/ws/"), "")
+	if putErr != nil {
+		t.Errorf("Error occured putting approval key: %s", putErr.Error())
+	}
+
+	err = rel.WaitOnApproval(cli, "/ws/", log)
+	if err != nil {
+		t.Errorf("Expected an approved release not to wait on approval and got error: %s", err.Error())
+	}
+
+	rel = &MinioRelease{Version: "v3", RequireApproval: true}
+	done := make(chan error)
+	go func() {
+		done <- rel.WaitOnApproval(cli, "/ws/", log)
+	}()
+
+	select {
+	case err = <-done:
+		t.Errorf("Expected a release requiring approval to wait on it")
+	case <-time.After(2 * time.Second):
+	}
+
+	_, putErr = cli.PutKey(rel.GetAbortKey("/ws/"), "")
+	if putErr != nil {
+		t.Errorf("Error occured putting abort key: %s", putErr.Error())
+	}
+
+	select {
+	case err = <-done:
+		if !errors.Is(err, ErrUpdateAborted) {
+			t.Errorf("Expected waiting on the approval of an aborted release update to return an aborted error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected waiting on approval to stop once the release update was aborted")
+	}
+
+	rel = &MinioRelease{Version: "v4", RequireApproval: true}
+	go func() {
+		done <- rel.WaitOnApproval(cli, "/ws/", log)
+	}()
+
+	time.Sleep(500 * time.Millisecond)
+	_, putErr = cli.PutKey(rel.GetApprovalKey("/ws/"), "")
+	if putErr != nil {
+		t.Errorf("Error occured putting approval key: %s", putErr.Error())
+	}
+
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Errorf("Expected waiting on the approval of a release to succeed once it was approved and got error: %s", err.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected waiting on approval to stop shortly after the release update was approved")
+	}
+}
+
+func TestReleasePinChecksum(t *testing.T) {
+	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
+	if launchErr != nil {
+		t.Errorf("Error occured launching test etcd cluster: %s", launchErr.Error())
+		return
+	}
+
+	defer func() {
+		errs := tearDown()
+		if len(errs) > 0 {
+			t.Errorf("Errors occured tearing down etcd cluster: %s", errs[0].Error())
+		}
+	}()
+
+	retryInterval, _ := time.ParseDuration("1s")
+	timeouts, _ := time.ParseDuration("10s")
+	retries := uint64(10)
+	cli := setupTestEnv(t, timeouts, retryInterval, retries)
+
+	resolutions := 0
+	resolver := func(checksum string) ReleaseChecksumResolver {
+		return func(rel *MinioRelease) (string, error) {
+			resolutions++
+			return checksum, nil
+		}
+	}
+
+	rel := &MinioRelease{Version: "v1", Checksum: "sha256:aaaa"}
+	err := rel.PinChecksum(cli, "/ws/", resolver("sha256:bbbb"))
+	if err != nil {
+		t.Errorf("Error occured pinning checksum: %s", err.Error())
+	}
+
+	if resolutions != 0 || rel.Checksum != "sha256:aaaa" {
+		t.Errorf("Expected the checksum of a release without a checksum url to be left untouched and it wasn't")
+	}
+
+	first := &MinioRelease{Version: "v2", ChecksumUrl: "https://dl.min.io/minio.sha256sum"}
+	err = first.PinChecksum(cli, "/ws/", resolver("sha256:cccc"))
+	if err != nil {
+		t.Errorf("Error occured pinning checksum: %s", err.Error())
+	}
+
+	second := &MinioRelease{Version: "v2", ChecksumUrl: "https://dl.min.io/minio.sha256sum"}
+	err = second.PinChecksum(cli, "/ws/", resolver("sha256:dddd"))
+	if err != nil {
+		t.Errorf("Error occured pinning checksum: %s", err.Error())
+	}
+
+	if resolutions != 1 {
+		t.Errorf("Expected the checksum to be resolved once and it was resolved %d times", resolutions)
+	}
+
+	for _, pinned := range []*MinioRelease{first, second} {
+		if pinned.Checksum != "sha256:cccc" || pinned.ChecksumUrl != "" {
+			t.Errorf("Expected releases to use the first pinned checksum and got checksum %s with checksum url '%s'", pinned.Checksum, pinned.ChecksumUrl)
+		}
+	}
+
+	resolveErr := errors.New("checksum file not found")
+	err = (&MinioRelease{Version: "v3", ChecksumUrl: "https://dl.min.io/minio.sha256sum"}).PinChecksum(cli, "/ws/", func(rel *MinioRelease) (string, error) {
+		return "", resolveErr
+	})
+	if !errors.Is(err, resolveErr) {
+		t.Errorf("Expected pinning to fail when the checksum cannot be resolved and it didn't")
+	}
+}
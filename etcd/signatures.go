@@ -0,0 +1,109 @@
+package etcd
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+)
+
+const ETCD_DOCUMENT_SIGNATURE_KEY = "%s.sig"
+
+var ErrInvalidDocumentSignature = errors.New("Invalid configuration document signature")
+
+type DocumentSignatureConfig struct {
+	//Base64 encoded ed25519 public keys of the publishers trusted to sign the release and server pools documents
+	PublisherKeys []string `yaml:"publisher_keys"`
+}
+
+/*
+Returns the key of the detached signature of a configuration document, which is a sibling of the document's key.
+*/
+func GetDocumentSignatureKey(documentKey string) string {
+	return fmt.Sprintf(ETCD_DOCUMENT_SIGNATURE_KEY, documentKey)
+}
+
+/*
+Decodes an ed25519 signature, either raw or base64 encoded.
+*/
+func DecodeDocumentSignature(signature []byte) ([]byte, error) {
+	if len(signature) == ed25519.SignatureSize {
+		return signature, nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil || len(decoded) != ed25519.SignatureSize {
+		return nil, errors.New("Signature is not a raw or base64 encoded ed25519 signature")
+	}
+
+	return decoded, nil
+}
+
+/*
+Verifies the signature of a configuration document against the publisher keys.
+The signature is over the exact value of the document's key.
+Verification is skipped if no publisher keys are configured.
+*/
+func (conf *DocumentSignatureConfig) VerifyDocument(documentKey string, document string, signature string, found bool) error {
+	if len(conf.PublisherKeys) == 0 {
+		return nil
+	}
+
+	if !found {
+		return fmt.Errorf("%w: configuration document %s is not signed", ErrInvalidDocumentSignature, documentKey)
+	}
+
+	sig, sigErr := DecodeDocumentSignature([]byte(signature))
+	if sigErr != nil {
+		return fmt.Errorf("%w: error decoding the signature of configuration document %s: %s", ErrInvalidDocumentSignature, documentKey, sigErr.Error())
+	}
+
+	for _, key := range conf.PublisherKeys {
+		pubKey, keyErr := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+		if keyErr != nil || len(pubKey) != ed25519.PublicKeySize {
+			return errors.New(fmt.Sprintf("Publisher key %s is not a base64 encoded ed25519 public key", key))
+		}
+
+		if ed25519.Verify(ed25519.PublicKey(pubKey), []byte(document), sig) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: signature of configuration document %s does not match any publisher key", ErrInvalidDocumentSignature, documentKey)
+}
+
+/*
+Returns the value, signature and modification revision of a configuration key, after verifying its signature against the publisher keys.
+The document and its signature are read in the same request, so that they are consistent with each other.
+*/
+func getSignedDocument(cli *client.EtcdClient, documentKey string, sigs DocumentSignatureConfig) (string, string, int64, bool, error) {
+	info, err := cli.GetPrefix(documentKey)
+	if err != nil {
+		return "", "", 0, false, err
+	}
+
+	doc, found := info.Keys[documentKey]
+	if !found {
+		return "", "", 0, false, nil
+	}
+
+	sig, sigFound := info.Keys[GetDocumentSignatureKey(documentKey)]
+	verifyErr := sigs.VerifyDocument(documentKey, doc.Value, sig.Value, sigFound)
+	if verifyErr != nil {
+		return "", "", 0, false, verifyErr
+	}
+
+	return doc.Value, sig.Value, doc.ModRevision, true, nil
+}
+
+/*
+Returns the value and modification revision of a configuration key, after verifying its signature against the publisher keys.
+The document and its signature are read in the same request, so that they are consistent with each other.
+*/
+func GetSignedDocument(cli *client.EtcdClient, documentKey string, sigs DocumentSignatureConfig) (string, int64, bool, error) {
+	value, _, rev, found, err := getSignedDocument(cli, documentKey, sigs)
+	return value, rev, found, err
+}
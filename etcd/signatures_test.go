@@ -0,0 +1,355 @@
+package etcd
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/testutils"
+)
+
+func TestVerifyDocument(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	sigs := DocumentSignatureConfig{PublisherKeys: []string{
+		base64.StdEncoding.EncodeToString(otherPub),
+		base64.StdEncoding.EncodeToString(pub),
+	}}
+
+	doc := "version: v1\n"
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(doc)))
+
+	err := sigs.VerifyDocument("/conf/release", doc, sig, true)
+	if err != nil {
+		t.Errorf("Expected document signed with a publisher key to be accepted and it wasn't: %s", err.Error())
+	}
+
+	for _, tc := range []struct {
+		document  string
+		signature string
+		found     bool
+	}{
+		{"version: v2\n", sig, true},
+		{doc, "", false},
+		{doc, "not a signature", true},
+		{doc, base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte("version: v1"))), true},
+	} {
+		err = sigs.VerifyDocument("/conf/release", tc.document, tc.signature, tc.found)
+		if !errors.Is(err, ErrInvalidDocumentSignature) {
+			t.Errorf("Expected document '%s' with signature '%s' to be rejected and it wasn't", tc.document, tc.signature)
+		}
+	}
+
+	err = (&DocumentSignatureConfig{}).VerifyDocument("/conf/release", doc, "", false)
+	if err != nil {
+		t.Errorf("Expected unsigned document to be accepted without publisher keys and it wasn't: %s", err.Error())
+	}
+}
+
+func TestHandleChangesSignatures(t *testing.T) {
+	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
+	if launchErr != nil {
+		t.Errorf("Error occured launching test etcd cluster: %s", launchErr.Error())
+		return
+	}
+
+	defer func() {
+		errs := tearDown()
+		if len(errs) > 0 {
+			t.Errorf("Errors occured tearing down etcd cluster: %s", errs[0].Error())
+		}
+	}()
+
+	retryInterval, _ := time.ParseDuration("1s")
+	timeouts, _ := time.ParseDuration("10s")
+	retries := uint64(10)
+	cli := setupTestEnv(t, timeouts, retryInterval, retries)
+	log := logger.Logger{LogLevel: logger.ERROR}
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	sigs := DocumentSignatureConfig{PublisherKeys: []string{base64.StdEncoding.EncodeToString(pub)}}
+
+	publish := func(key string, doc string, signed bool) {
+		var sig []byte
+		if signed {
+			sig = ed25519.Sign(priv, []byte(doc))
+		}
+
+		_, rev, _, getErr := GetConfigDocument(cli, key)
+		if getErr != nil {
+			t.Errorf("Error occured getting config document: %s", getErr.Error())
+		}
+
+		_, pubErr := PublishConfigDocument(cli, key, doc, sig, rev)
+		if pubErr != nil {
+			t.Errorf("Error occured publishing config document: %s", pubErr.Error())
+		}
+	}
+
+	publish(GetPoolsConfigKey("/conf/"), "version: v1\n", true)
+	publish(GetReleaseConfigKey("/conf/"), "version: v1\n", false)
+
+	_, _, _, err := GetConfigs(cli, "/conf/", sigs)
+	if !errors.Is(err, ErrInvalidDocumentSignature) {
+		t.Errorf("Expected getting configurations with an unsigned release to fail and it didn't")
+	}
+
+	publish(GetReleaseConfigKey("/conf/"), "version: v1\n", true)
+
+	_, rel, _, err := GetConfigs(cli, "/conf/", sigs)
+	if err != nil {
+		t.Errorf("Error occured getting signed configurations: %s", err.Error())
+	} else if rel.Version != "v1" {
+		t.Errorf("Expected release configuration to be at version v1 and it was at version %s", rel.Version)
+	}
+
+	applied := make(chan string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := HandleChanges(
+		cli.SetContext(ctx),
+		"/conf/",
+		"/ws/",
+		func(pools *MinioServerPools, rel *MinioRelease) error {
+			return nil
+		},
+		func(rel *MinioRelease, pools *MinioServerPools) error {
+			applied <- rel.Version
+			return nil
+		},
+		func(rel *MinioRelease) error {
+			return nil
+		},
+		sigs,
+		log,
+	)
+
+	expectApplied := func(expected string) {
+		select {
+		case version := <-applied:
+			if version != expected {
+				t.Errorf("Expected release %s to be applied and got %s", expected, version)
+			}
+		case err := <-errCh:
+			t.Errorf("Error occured handling changes: %v", err)
+		case <-time.After(30 * time.Second):
+			t.Errorf("Timed out waiting for release %s to be applied", expected)
+		}
+	}
+
+	expectApplied("v1")
+
+	_, putErr := cli.PutKey(GetReleaseConfigKey("/conf/"), "version: v2\n")
+	if putErr != nil {
+		t.Errorf("Error occured putting configuration key: %s", putErr.Error())
+	}
+
+	publish(GetReleaseConfigKey("/conf/"), "version: v3\n", true)
+	expectApplied("v3")
+
+	state, stateErr := GetQueuedUpdateState(cli, "/ws/", QUEUE_KIND_RELEASE, "v2")
+	if stateErr != nil {
+		t.Errorf("Error occured getting queued update state: %s", stateErr.Error())
+	}
+
+	if state != "" {
+		t.Errorf("Expected release with an invalid signature not to be queued and its state was %s", state)
+	}
+
+	cancel()
+	for range errCh {}
+}
+
+func TestProcessQueueVerifiesSignatures(t *testing.T) {
+	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
+	if launchErr != nil {
+		t.Errorf("Error occured launching test etcd cluster: %s", launchErr.Error())
+		return
+	}
+
+	defer func() {
+		errs := tearDown()
+		if len(errs) > 0 {
+			t.Errorf("Errors occured tearing down etcd cluster: %s", errs[0].Error())
+		}
+	}()
+
+	retryInterval, _ := time.ParseDuration("1s")
+	timeouts, _ := time.ParseDuration("10s")
+	retries := uint64(10)
+	cli := setupTestEnv(t, timeouts, retryInterval, retries)
+	log := logger.Logger{LogLevel: logger.ERROR}
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	sigs := DocumentSignatureConfig{PublisherKeys: []string{base64.StdEncoding.EncodeToString(pub)}}
+
+	doc := "version: v1\n"
+	for _, key := range []string{GetPoolsConfigKey("/conf/"), GetReleaseConfigKey("/conf/")} {
+		_, pubErr := PublishConfigDocument(cli, key, doc, ed25519.Sign(priv, []byte(doc)), 0)
+		if pubErr != nil {
+			t.Errorf("Error occured publishing config document: %s", pubErr.Error())
+		}
+	}
+
+	err := EnqueueConfigs(cli, "/conf/", "/ws/", sigs, log)
+	if err != nil {
+		t.Errorf("Error occured queuing signed configurations: %s", err.Error())
+	}
+
+	queue, queueErr := GetQueue(cli, "/ws/")
+	if queueErr != nil {
+		t.Errorf("Error occured getting update queue: %s", queueErr.Error())
+	}
+
+	if len(queue) != 2 || queue[1].Document != doc || queue[1].Signature == "" {
+		t.Errorf("Expected the signed release to be queued as published along with its signature and it wasn't")
+	}
+
+	_, putErr := cli.PutKey("/ws/queue/entries/release/v2", "version: v2\n")
+	if putErr != nil {
+		t.Errorf("Error occured putting queue entry: %s", putErr.Error())
+	}
+
+	applied := []string{}
+	err = ProcessQueue(
+		cli,
+		"/conf/",
+		"/ws/",
+		func(pools *MinioServerPools, rel *MinioRelease) error {
+			return nil
+		},
+		func(rel *MinioRelease, pools *MinioServerPools) error {
+			applied = append(applied, rel.Version)
+			return nil
+		},
+		sigs,
+		log,
+	)
+	if err != nil {
+		t.Errorf("Error occured processing update queue: %s", err.Error())
+	}
+
+	if len(applied) != 1 || applied[0] != "v1" {
+		t.Errorf("Expected only the signed release to be applied and the applied releases were %v", applied)
+	}
+
+	state, stateErr := GetQueuedUpdateState(cli, "/ws/", QUEUE_KIND_RELEASE, "v2")
+	if stateErr != nil {
+		t.Errorf("Error occured getting queued update state: %s", stateErr.Error())
+	}
+
+	if state != QUEUE_OUTCOME_REJECTED {
+		t.Errorf("Expected unsigned release written in the queue to be rejected and its state was '%s'", state)
+	}
+
+	sigInfo, sigErr := cli.GetPrefix("/ws/queue/signatures/")
+	if sigErr != nil {
+		t.Errorf("Error occured getting queue signatures: %s", sigErr.Error())
+	}
+
+	if len(sigInfo.Keys) != 0 {
+		t.Errorf("Expected signatures of processed updates to be deleted and %d remained", len(sigInfo.Keys))
+	}
+}
+
+func TestReadDocumentsVerifySignatures(t *testing.T) {
+	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
+	if launchErr != nil {
+		t.Errorf("Error occured launching test etcd cluster: %s", launchErr.Error())
+		return
+	}
+
+	defer func() {
+		errs := tearDown()
+		if len(errs) > 0 {
+			t.Errorf("Errors occured tearing down etcd cluster: %s", errs[0].Error())
+		}
+	}()
+
+	retryInterval, _ := time.ParseDuration("1s")
+	timeouts, _ := time.ParseDuration("10s")
+	retries := uint64(10)
+	cli := setupTestEnv(t, timeouts, retryInterval, retries)
+	log := logger.Logger{LogLevel: logger.ERROR}
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	sigs := DocumentSignatureConfig{PublisherKeys: []string{base64.StdEncoding.EncodeToString(pub)}}
+
+	doc := "version: v1\n"
+	nextRelKey := fmt.Sprintf(ETCD_NEXT_RELEASE_CONFIG_KEY, "/conf/")
+	for _, key := range []string{GetPoolsConfigKey("/conf/"), GetReleaseConfigKey("/conf/"), nextRelKey} {
+		_, pubErr := PublishConfigDocument(cli, key, doc, ed25519.Sign(priv, []byte(doc)), 0)
+		if pubErr != nil {
+			t.Errorf("Error occured publishing config document: %s", pubErr.Error())
+		}
+	}
+
+	err := EnqueueConfigs(cli, "/conf/", "/ws/", sigs, log)
+	if err != nil {
+		t.Errorf("Error occured queuing signed configurations: %s", err.Error())
+	}
+
+	err = ProcessQueue(
+		cli,
+		"/conf/",
+		"/ws/",
+		func(pools *MinioServerPools, rel *MinioRelease) error {
+			return nil
+		},
+		func(rel *MinioRelease, pools *MinioServerPools) error {
+			return nil
+		},
+		sigs,
+		log,
+	)
+	if err != nil {
+		t.Errorf("Error occured processing update queue: %s", err.Error())
+	}
+
+	appliedRel, appliedRelErr := GetAppliedRelease(cli, "/ws/", sigs)
+	if appliedRelErr != nil || appliedRel == nil || appliedRel.Version != "v1" {
+		t.Errorf("Expected the signed applied release to be verified and read")
+	}
+
+	appliedPools, appliedPoolsErr := GetAppliedPools(cli, "/ws/", sigs)
+	if appliedPoolsErr != nil || appliedPools == nil || appliedPools.Version != "v1" {
+		t.Errorf("Expected the signed applied server pools to be verified and read")
+	}
+
+	nextRel, nextRelErr := GetNextRelease(cli, "/conf/", sigs)
+	if nextRelErr != nil || nextRel == nil || nextRel.Version != "v1" {
+		t.Errorf("Expected the signed next release to be verified and read")
+	}
+
+	for key, value := range map[string]string{
+		fmt.Sprintf(ETCD_APPLIED_RELEASE_KEY, "/ws/"): "version: v2\n",
+		fmt.Sprintf(ETCD_APPLIED_POOLS_KEY, "/ws/"): "version: v2\n",
+		nextRelKey: "version: v2\n",
+	} {
+		_, putErr := cli.PutKey(key, value)
+		if putErr != nil {
+			t.Errorf("Error occured putting key %s: %s", key, putErr.Error())
+		}
+	}
+
+	_, appliedRelErr = GetAppliedRelease(cli, "/ws/", sigs)
+	if !errors.Is(appliedRelErr, ErrInvalidDocumentSignature) {
+		t.Errorf("Expected an applied release written without its signature to be rejected")
+	}
+
+	_, appliedPoolsErr = GetAppliedPools(cli, "/ws/", sigs)
+	if !errors.Is(appliedPoolsErr, ErrInvalidDocumentSignature) {
+		t.Errorf("Expected applied server pools written without their signature to be rejected")
+	}
+
+	_, nextRelErr = GetNextRelease(cli, "/conf/", sigs)
+	if !errors.Is(nextRelErr, ErrInvalidDocumentSignature) {
+		t.Errorf("Expected a next release written without its signature to be rejected")
+	}
+}
@@ -1,17 +1,75 @@
 package etcd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
+	yaml "gopkg.in/yaml.v2"
 
 	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
 )
 
 const ETCD_TASK_COMPLETION_KEY = "%scomplete"
 const ETCD_TASK_COMPLETERS_PREFIX = "%scompleters/"
+const ETCD_TASK_FAILURES_PREFIX = "%sfailures/"
+const ETCD_TASK_FAILURE_KEY = "%sfailures/%s"
+
+type BarrierTimeouts struct {
+	Default        time.Duration
+	Acknowledgment time.Duration
+	BinaryDownload time.Duration `yaml:"binary_download"`
+	MinioShutdown  time.Duration `yaml:"minio_shutdown"`
+	SystemdUpdate  time.Duration `yaml:"systemd_update"`
+	HealthCheck    time.Duration `yaml:"health_check"`
+}
+
+func (timeouts *BarrierTimeouts) GetTimeout(phase string) time.Duration {
+	timeout := time.Duration(0)
+	switch phase {
+	case "acknowledgment":
+		timeout = timeouts.Acknowledgment
+	case "binary_download":
+		timeout = timeouts.BinaryDownload
+	case "minio_shutdown":
+		timeout = timeouts.MinioShutdown
+	case "systemd_update":
+		timeout = timeouts.SystemdUpdate
+	case "health_check":
+		timeout = timeouts.HealthCheck
+	}
+
+	if timeout == 0 {
+		return timeouts.Default
+	}
+
+	return timeout
+}
+
+type TaskFailure struct {
+	Reporter     string
+	Reason       string
+	Timeout      string
+	MissingHosts []string `yaml:"missing_hosts"`
+	DeadHosts    []string `yaml:"dead_hosts"`
+	Completers   []string
+	Timestamp    time.Time
+}
+
+const TASK_COMPLETER_DONE = "done"
+const TASK_COMPLETER_FAILED = "failed"
+
+/*
+Error that a task action can return to report that it failed on the host without interrupting the synchronization.
+The host is then added to the task completers, but as a failed completer.
+*/
+var ErrTaskFailed = errors.New("Task failed")
 
 type Task struct {
 	Complete bool
 	Completers []string
+	Failed []string
 }
 
 func (tk *Task) HasToDo(host string) bool {
@@ -28,15 +86,26 @@ func (tk *Task) CanContinue(hostsCount int64) bool {
 	return tk.Complete || int64(len(tk.Completers)) >= hostsCount
 }
 
+/*
+Returns whether the task is complete or was completed by all the given hosts.
+Completers that are not among the hosts, such as hosts that completed the task before being excluded, are not counted.
+*/
+func (tk *Task) CanContinueWithHosts(hosts []string) bool {
+	return tk.Complete || len(getMissingHosts(hosts, tk.Completers)) == 0
+}
+
 func GetTask(cli *client.EtcdClient, taskPrefix string) (*Task, int64, error) {
-	tk := Task{false, []string{}}
+	tk := Task{false, []string{}, []string{}}
 	
 	members, rev, getErr := cli.GetGroupMembers(fmt.Sprintf(ETCD_TASK_COMPLETERS_PREFIX, taskPrefix))
 	if getErr != nil {
 		return &tk, rev, getErr
 	}
-	for member, _ := range members {
+	for member, val := range members {
 		tk.Completers = append(tk.Completers, member)
+		if val == TASK_COMPLETER_FAILED {
+			tk.Failed = append(tk.Failed, member)
+		}
 	}
 
 	info, infoErr := cli.GetKey(fmt.Sprintf(ETCD_TASK_COMPLETION_KEY, taskPrefix), client.GetKeyOptions{Revision: rev})
@@ -49,7 +118,11 @@ func GetTask(cli *client.EtcdClient, taskPrefix string) (*Task, int64, error) {
 }
 
 func MarkTaskDoneBySelf(cli *client.EtcdClient, taskPrefix string, host string) error {
-	return cli.JoinGroup(fmt.Sprintf(ETCD_TASK_COMPLETERS_PREFIX, taskPrefix), host, "done")
+	return cli.JoinGroup(fmt.Sprintf(ETCD_TASK_COMPLETERS_PREFIX, taskPrefix), host, TASK_COMPLETER_DONE)
+}
+
+func MarkTaskFailedBySelf(cli *client.EtcdClient, taskPrefix string, host string) error {
+	return cli.JoinGroup(fmt.Sprintf(ETCD_TASK_COMPLETERS_PREFIX, taskPrefix), host, TASK_COMPLETER_FAILED)
 }
 
 func WaitOnTaskCompletion(cli *client.EtcdClient, taskPrefix string, hostsCount int64) error {	
@@ -66,4 +139,228 @@ func WaitOnTaskCompletion(cli *client.EtcdClient, taskPrefix string, hostsCount
 	return putErr
 }
 
-type TaskAction func() error
\ No newline at end of file
+func getMissingHosts(hosts []string, completers []string) []string {
+	missing := []string{}
+	for _, host := range hosts {
+		tk := Task{Completers: completers}
+		if tk.HasToDo(host) {
+			missing = append(missing, host)
+		}
+	}
+	return missing
+}
+
+func ReportTaskFailure(cli *client.EtcdClient, taskPrefix string, failure TaskFailure) error {
+	output, err := yaml.Marshal(&failure)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error serializing task failure: %s", err.Error()))
+	}
+
+	_, putErr := cli.PutKey(fmt.Sprintf(ETCD_TASK_FAILURE_KEY, taskPrefix, failure.Reporter), string(output))
+	return putErr
+}
+
+var ErrUpdateAborted = errors.New("Update was aborted by an operator")
+
+var ErrUpdateRolledBack = errors.New("Update was rolled back")
+
+func IsTaskAborted(cli *client.EtcdClient, abortKey string) (bool, error) {
+	info, err := cli.GetKey(abortKey, client.GetKeyOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	return info.Found(), nil
+}
+
+/*
+Waits until all the given hosts are members of the group, like the WaitGroupCountThreshold method of the etcd client.
+Members that are not among the hosts are not counted.
+The returned channel receives an error if there is an issue or is otherwise closed when all the hosts are members.
+Closing the done channel stops the wait, along with its watch.
+*/
+func waitOnGroupMembers(cli *client.EtcdClient, groupPrefix string, hosts []string, doneCh <-chan struct{}) <-chan error {
+	errCh := make(chan error)
+	go func() {
+		defer close(errCh)
+		members, rev, err := cli.GetGroupMembers(groupPrefix)
+		if err != nil {
+			errCh <- err
+			return
+		}
+
+		hasAllHosts := func() bool {
+			for _, host := range hosts {
+				if _, ok := members[host]; !ok {
+					return false
+				}
+			}
+			return true
+		}
+
+		if hasAllHosts() {
+			return
+		}
+
+		ctx, cancel := context.WithCancel(cli.Context)
+		wcCh := cli.SetContext(ctx).Watch(groupPrefix, client.WatchOptions{IsPrefix: true, TrimPrefix: true, Revision: rev + 1})
+		defer func() {
+			cancel()
+			go func() {
+				for range wcCh {}
+			}()
+		}()
+
+		for true {
+			select {
+			case res, ok := <-wcCh:
+				if !ok {
+					errCh <- errors.New("Watch stopped before all the hosts completed the task")
+					return
+				}
+
+				if res.Error != nil {
+					errCh <- res.Error
+					return
+				}
+
+				res.Changes.ApplyOn(members)
+				if hasAllHosts() {
+					return
+				}
+			case <-doneCh:
+				return
+			}
+		}
+	}()
+	return errCh
+}
+
+type TaskWaitOptions struct {
+	//Hosts that need to complete the task
+	Hosts          []string
+	//Host ferio runs on, which is reported as the author of failure records
+	Host           string
+	//If not zero, deadline after which a failure is reported
+	Timeout        time.Duration
+	//If not empty, key whose creation aborts the wait
+	AbortKey       string
+	//If not empty, workspace prefix where hosts are registered, used to report which missing hosts are dead on timeout
+	RegistryPrefix string
+}
+
+/*
+Waits until all the given hosts completed the task, like WaitOnTaskCompletion.
+If the timeout expires before that, a failure record naming the missing hosts is written under the task prefix and an error is returned.
+If the abort key is set before that, ErrUpdateAborted is returned.
+*/
+func WaitOnTaskCompletionWithOptions(cli *client.EtcdClient, taskPrefix string, opts TaskWaitOptions) error {
+	var abortCh <-chan client.WatchNotification
+	if opts.AbortKey != "" {
+		info, err := cli.GetPrefix(opts.AbortKey)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := info.Keys[opts.AbortKey]; ok {
+			return ErrUpdateAborted
+		}
+
+		ctx, cancel := context.WithCancel(cli.Context)
+		abortCh = cli.SetContext(ctx).Watch(opts.AbortKey, client.WatchOptions{Revision: info.Revision + 1})
+		defer func() {
+			cancel()
+			go func() {
+				for range abortCh {}
+			}()
+		}()
+	}
+
+	var timeoutCh <-chan time.Time
+	if opts.Timeout > 0 {
+		timeoutCh = time.After(opts.Timeout)
+	}
+
+	doneCh := make(chan struct{})
+	errCh := waitOnGroupMembers(cli, fmt.Sprintf(ETCD_TASK_COMPLETERS_PREFIX, taskPrefix), opts.Hosts, doneCh)
+	defer func() {
+		close(doneCh)
+		go func() {
+			for range errCh {}
+		}()
+	}()
+
+	for true {
+		select {
+		case err := <-errCh:
+			if err != nil {
+				return err
+			}
+
+			_, putErr := cli.PutKey(fmt.Sprintf(ETCD_TASK_COMPLETION_KEY, taskPrefix), "true")
+			return putErr
+		case res, ok := <-abortCh:
+			if !ok {
+				return errors.New(fmt.Sprintf("Watch on abort key %s stopped unexpectedly", opts.AbortKey))
+			}
+
+			if res.Error != nil {
+				return res.Error
+			}
+
+			if _, aborted := res.Changes.Upserts[opts.AbortKey]; aborted {
+				return ErrUpdateAborted
+			}
+		case <-timeoutCh:
+			tk, _, tkErr := GetTask(cli, taskPrefix)
+			if tkErr != nil {
+				return tkErr
+			}
+
+			if tk.CanContinueWithHosts(opts.Hosts) {
+				_, putErr := cli.PutKey(fmt.Sprintf(ETCD_TASK_COMPLETION_KEY, taskPrefix), "true")
+				return putErr
+			}
+
+			missing := getMissingHosts(opts.Hosts, tk.Completers)
+			dead := []string{}
+			if opts.RegistryPrefix != "" {
+				var deadErr error
+				dead, deadErr = GetDeadHosts(cli, opts.RegistryPrefix, missing)
+				if deadErr != nil {
+					return deadErr
+				}
+			}
+
+			failure := TaskFailure{
+				Reporter:     opts.Host,
+				Reason:       "timeout",
+				Timeout:      opts.Timeout.String(),
+				MissingHosts: missing,
+				DeadHosts:    dead,
+				Completers:   tk.Completers,
+				Timestamp:    time.Now(),
+			}
+
+			reportErr := ReportTaskFailure(cli, taskPrefix, failure)
+			if reportErr != nil {
+				return reportErr
+			}
+
+			if len(dead) > 0 {
+				return errors.New(fmt.Sprintf("Timed out after %s waiting on task %s. Hosts that did not complete it: %s. Hosts among them whose ferio instance is not running: %s", opts.Timeout.String(), taskPrefix, strings.Join(missing, ", "), strings.Join(dead, ", ")))
+			}
+
+			return errors.New(fmt.Sprintf("Timed out after %s waiting on task %s. Hosts that did not complete it: %s", opts.Timeout.String(), taskPrefix, strings.Join(missing, ", ")))
+		}
+	}
+
+	return nil
+}
+
+type TaskAction func() error
+
+type TaskPhase struct {
+	Name string
+	Key  string
+}
\ No newline at end of file
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"testing"
 	"time"
+	yaml "gopkg.in/yaml.v2"
 
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
 	"github.com/Ferlab-Ste-Justine/etcd-sdk/testutils"
 )
 
@@ -55,6 +57,24 @@ func TestCanContinue(t *testing.T) {
 	}
 }
 
+func TestCanContinueWithHosts(t *testing.T) {
+	tsk := Task{Complete: false, Completers: []string{"host1", "host2", "host3"}}
+
+	if !tsk.CanContinueWithHosts([]string{"host1", "host2"}) {
+		t.Errorf("Expected to be able to continue when all the hosts are completers")
+	}
+
+	if tsk.CanContinueWithHosts([]string{"host1", "host4"}) {
+		t.Errorf("Expected not to be able to continue when a host is not a completer, even if the number of completers is greater than the hosts count")
+	}
+
+	tsk.Complete = true
+
+	if !tsk.CanContinueWithHosts([]string{"host1", "host4"}) {
+		t.Errorf("Expected to be able to continue when the complete flag is at true")
+	}
+}
+
 func TestGetTask(t *testing.T) {
 	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
 	if launchErr != nil {
@@ -254,4 +274,180 @@ func TestWaitOnTaskCompletion(t *testing.T) {
 	if !tsk.Complete {
 		t.Errorf("Expected task to be marked as complete after waiting for task completion and it was not the case")	
 	}
-}
\ No newline at end of file
+}
+
+func TestWaitOnTaskCompletionWithTimeout(t *testing.T) {
+	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
+	if launchErr != nil {
+		t.Errorf("Error occured launching test etcd cluster: %s", launchErr.Error())
+		return
+	}
+
+	defer func() {
+		errs := tearDown()
+		if len(errs) > 0 {
+			t.Errorf("Errors occured tearing down etcd cluster: %s", errs[0].Error())
+		}
+	}()
+
+	retryInterval, _ := time.ParseDuration("1s")
+	timeouts, _ := time.ParseDuration("10s")
+	retries := uint64(10)
+	cli := setupTestEnv(t, timeouts, retryInterval, retries)
+
+	hosts := []string{"host1", "host2", "host3"}
+	for _, host := range hosts[:2] {
+		err := MarkTaskDoneBySelf(cli, "/task1/", host)
+		if err != nil {
+			t.Errorf("Error occured marking task as done by a host: %s", err.Error())
+		}
+	}
+
+	err := WaitOnTaskCompletionWithOptions(cli, "/task1/", TaskWaitOptions{Hosts: hosts, Host: "host1", Timeout: 2 * time.Second})
+	if err == nil {
+		t.Errorf("Expected waiting on a task with a missing host to time out and it didn't")
+	}
+
+	info, infoErr := cli.GetKey("/task1/failures/host1", client.GetKeyOptions{})
+	if infoErr != nil {
+		t.Errorf("Error occured getting task failure: %s", infoErr.Error())
+	}
+
+	failure := TaskFailure{}
+	yamlErr := yaml.Unmarshal([]byte(info.Value), &failure)
+	if yamlErr != nil {
+		t.Errorf("Error occured parsing task failure: %s", yamlErr.Error())
+	}
+
+	if len(failure.MissingHosts) != 1 || failure.MissingHosts[0] != "host3" {
+		t.Errorf("Expected task failure to name host3 as the only missing host and that was not the case")
+	}
+
+	tsk, _, tskErr := GetTask(cli, "/task1/")
+	if tskErr != nil {
+		t.Errorf("Error occured getting task: %s", tskErr.Error())
+	}
+
+	if tsk.Complete {
+		t.Errorf("Expected task not to be marked as complete after timing out and it was")
+	}
+
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		err := MarkTaskDoneBySelf(cli, "/task1/", "host3")
+		if err != nil {
+			t.Errorf("Error occured marking task as done by a host: %s", err.Error())
+		}
+	}()
+
+	err = WaitOnTaskCompletionWithOptions(cli, "/task1/", TaskWaitOptions{Hosts: hosts, Host: "host1", Timeout: 10 * time.Second})
+	if err != nil {
+		t.Errorf("Error occured waiting on task completions: %s", err.Error())
+	}
+
+	tsk, _, tskErr = GetTask(cli, "/task1/")
+	if tskErr != nil {
+		t.Errorf("Error occured getting task: %s", tskErr.Error())
+	}
+
+	if !tsk.Complete {
+		t.Errorf("Expected task to be marked as complete after waiting for task completion and it was not the case")	
+	}
+}
+
+func TestWaitOnTaskCompletionIgnoresOtherCompleters(t *testing.T) {
+	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
+	if launchErr != nil {
+		t.Errorf("Error occured launching test etcd cluster: %s", launchErr.Error())
+		return
+	}
+
+	defer func() {
+		errs := tearDown()
+		if len(errs) > 0 {
+			t.Errorf("Errors occured tearing down etcd cluster: %s", errs[0].Error())
+		}
+	}()
+
+	retryInterval, _ := time.ParseDuration("1s")
+	timeouts, _ := time.ParseDuration("10s")
+	retries := uint64(10)
+	cli := setupTestEnv(t, timeouts, retryInterval, retries)
+
+	for _, host := range []string{"host1", "excluded"} {
+		err := MarkTaskDoneBySelf(cli, "/task1/", host)
+		if err != nil {
+			t.Errorf("Error occured marking task as done by a host: %s", err.Error())
+		}
+	}
+
+	hosts := []string{"host1", "host2"}
+	err := WaitOnTaskCompletionWithOptions(cli, "/task1/", TaskWaitOptions{Hosts: hosts, Host: "host1", Timeout: 2 * time.Second})
+	if err == nil {
+		t.Errorf("Expected a completer that is not among the hosts not to count towards the task completion and it did")
+	}
+
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		for _, host := range []string{"other", "host2"} {
+			err := MarkTaskDoneBySelf(cli, "/task1/", host)
+			if err != nil {
+				t.Errorf("Error occured marking task as done by a host: %s", err.Error())
+			}
+		}
+	}()
+
+	err = WaitOnTaskCompletionWithOptions(cli, "/task1/", TaskWaitOptions{Hosts: hosts, Host: "host1", Timeout: 10 * time.Second})
+	if err != nil {
+		t.Errorf("Error occured waiting on task completions: %s", err.Error())
+	}
+}
+
+func TestWaitOnTaskCompletionAbort(t *testing.T) {
+	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
+	if launchErr != nil {
+		t.Errorf("Error occured launching test etcd cluster: %s", launchErr.Error())
+		return
+	}
+
+	defer func() {
+		errs := tearDown()
+		if len(errs) > 0 {
+			t.Errorf("Errors occured tearing down etcd cluster: %s", errs[0].Error())
+		}
+	}()
+
+	retryInterval, _ := time.ParseDuration("1s")
+	timeouts, _ := time.ParseDuration("10s")
+	retries := uint64(10)
+	cli := setupTestEnv(t, timeouts, retryInterval, retries)
+
+	opts := TaskWaitOptions{Hosts: []string{"host1", "host2"}, Host: "host1", AbortKey: "/abort"}
+
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		_, err := cli.PutKey("/abort", "true")
+		if err != nil {
+			t.Errorf("Error occured setting abort key: %s", err.Error())
+		}
+	}()
+
+	err := WaitOnTaskCompletionWithOptions(cli, "/task1/", opts)
+	if err != ErrUpdateAborted {
+		t.Errorf("Expected waiting on a task to be aborted when the abort key is set and it was not")
+	}
+
+	err = WaitOnTaskCompletionWithOptions(cli, "/task1/", opts)
+	if err != ErrUpdateAborted {
+		t.Errorf("Expected waiting on a task to be aborted when the abort key is already set and it was not")
+	}
+
+	tsk, _, tskErr := GetTask(cli, "/task1/")
+	if tskErr != nil {
+		t.Errorf("Error occured getting task: %s", tskErr.Error())
+	}
+
+	if tsk.Complete {
+		t.Errorf("Expected aborted task not to be marked as complete and it was")
+	}
+}
@@ -0,0 +1,30 @@
+package etcd
+
+import (
+	"context"
+	"time"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func commitTransactionWithRetries(cli *client.EtcdClient, cmps []clientv3.Cmp, ops []clientv3.Op, retries uint64) (bool, error) {
+	ctx, cancel := context.WithTimeout(cli.Context, cli.RequestTimeout)
+	defer cancel()
+
+	resp, err := cli.Client.Txn(ctx).If(cmps...).Then(ops...).Commit()
+	if err != nil {
+		if retries == 0 || !client.ErrorIsRetryable(err) {
+			return false, err
+		}
+
+		time.Sleep(cli.RetryInterval)
+		return commitTransactionWithRetries(cli, cmps, ops, retries-1)
+	}
+
+	return resp.Succeeded, nil
+}
+
+func commitTransaction(cli *client.EtcdClient, cmps []clientv3.Cmp, ops []clientv3.Op) (bool, error) {
+	return commitTransactionWithRetries(cli, cmps, ops, cli.Retries)
+}
@@ -0,0 +1,403 @@
+package etcd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const ETCD_MAINTENANCE_WINDOWS_CONFIG_KEY = "%smaintenance_windows"
+
+const MAINTENANCE_WINDOW_TIME_FORMAT = "15:04"
+
+type MaintenanceWindow struct {
+	//Weekdays on which the window starts. If empty, the window starts every day
+	Days     []string
+	Start    string
+	End      string
+	Timezone string
+}
+
+func parseWeekday(day string) (time.Weekday, error) {
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		name := strings.ToLower(weekday.String())
+		if strings.ToLower(day) == name || strings.ToLower(day) == name[:3] {
+			return weekday, nil
+		}
+	}
+
+	return time.Sunday, errors.New(fmt.Sprintf("Unknown weekday %s", day))
+}
+
+func (window *MaintenanceWindow) startsOn(weekday time.Weekday) (bool, error) {
+	if len(window.Days) == 0 {
+		return true, nil
+	}
+
+	for _, day := range window.Days {
+		parsed, err := parseWeekday(day)
+		if err != nil {
+			return false, err
+		}
+
+		if parsed == weekday {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func parseWindowTime(value string) (int, error) {
+	parsed, err := time.Parse(MAINTENANCE_WINDOW_TIME_FORMAT, value)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("Invalid maintenance window time %s, expected the hh:mm format", value))
+	}
+
+	return parsed.Hour() * 60 + parsed.Minute(), nil
+}
+
+/*
+Returns the timezone of the window and its start and end, in minutes since midnight.
+*/
+func (window *MaintenanceWindow) parse() (*time.Location, int, int, error) {
+	location := time.UTC
+	if window.Timezone != "" {
+		var locErr error
+		location, locErr = time.LoadLocation(window.Timezone)
+		if locErr != nil {
+			return nil, 0, 0, errors.New(fmt.Sprintf("Invalid maintenance window timezone %s: %s", window.Timezone, locErr.Error()))
+		}
+	}
+
+	start, startErr := parseWindowTime(window.Start)
+	if startErr != nil {
+		return nil, 0, 0, startErr
+	}
+
+	end, endErr := parseWindowTime(window.End)
+	if endErr != nil {
+		return nil, 0, 0, endErr
+	}
+
+	return location, start, end, nil
+}
+
+/*
+Returns whether the window is open at the given time.
+A window whose end is before its start ends on the next day and a window whose end is equal to its start lasts the whole day.
+*/
+func (window *MaintenanceWindow) IsOpen(now time.Time) (bool, error) {
+	location, start, end, err := window.parse()
+	if err != nil {
+		return false, err
+	}
+
+	local := now.In(location)
+	minutes := local.Hour() * 60 + local.Minute()
+
+	startsToday, todayErr := window.startsOn(local.Weekday())
+	if todayErr != nil {
+		return false, todayErr
+	}
+
+	if start == end {
+		return startsToday, nil
+	}
+
+	if start < end {
+		return startsToday && minutes >= start && minutes < end, nil
+	}
+
+	if startsToday && minutes >= start {
+		return true, nil
+	}
+
+	startedYesterday, yesterdayErr := window.startsOn(local.AddDate(0, 0, -1).Weekday())
+	if yesterdayErr != nil {
+		return false, yesterdayErr
+	}
+
+	return startedYesterday && minutes < end, nil
+}
+
+/*
+Returns the first time after the given time at which the window opens.
+A window lasting the whole day opens at midnight.
+*/
+func (window *MaintenanceWindow) nextOpening(now time.Time) (time.Time, error) {
+	location, start, end, err := window.parse()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if start == end {
+		start = 0
+	}
+
+	local := now.In(location)
+	for days := 0; days <= 7; days++ {
+		day := local.AddDate(0, 0, days)
+		opening := time.Date(day.Year(), day.Month(), day.Day(), start / 60, start % 60, 0, 0, location)
+		if !opening.After(now) {
+			continue
+		}
+
+		startsOn, startsErr := window.startsOn(opening.Weekday())
+		if startsErr != nil {
+			return time.Time{}, startsErr
+		}
+
+		if startsOn {
+			return opening, nil
+		}
+	}
+
+	return time.Time{}, errors.New("Maintenance window never opens")
+}
+
+type MaintenanceWindows []MaintenanceWindow
+
+/*
+Returns whether one of the windows is open at the given time.
+If there are no windows, updates are not restricted and this always returns true.
+*/
+func (windows MaintenanceWindows) IsOpen(now time.Time) (bool, error) {
+	if len(windows) == 0 {
+		return true, nil
+	}
+
+	for _, window := range windows {
+		open, err := window.IsOpen(now)
+		if err != nil {
+			return false, err
+		}
+
+		if open {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+/*
+Returns the first time after the given time at which one of the windows opens.
+*/
+func (windows MaintenanceWindows) NextOpening(now time.Time) (time.Time, error) {
+	next := time.Time{}
+	for _, window := range windows {
+		opening, err := window.nextOpening(now)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if next.IsZero() || opening.Before(next) {
+			next = opening
+		}
+	}
+
+	return next, nil
+}
+
+func parseMaintenanceWindows(value string) (MaintenanceWindows, error) {
+	windows := MaintenanceWindows{}
+
+	err := yaml.Unmarshal([]byte(value), &windows)
+	if err != nil {
+		return windows, errors.New(fmt.Sprintf("Error parsing the maintenance windows: %s", err.Error()))
+	}
+
+	_, err = windows.IsOpen(time.Now())
+	if err != nil {
+		return windows, errors.New(fmt.Sprintf("Error parsing the maintenance windows: %s", err.Error()))
+	}
+
+	return windows, nil
+}
+
+/*
+Returns the maintenance windows of the configuration prefix, or no windows if the key is not set.
+*/
+func GetMaintenanceWindows(cli *client.EtcdClient, prefix string) (MaintenanceWindows, error) {
+	info, err := cli.GetKey(fmt.Sprintf(ETCD_MAINTENANCE_WINDOWS_CONFIG_KEY, prefix), client.GetKeyOptions{})
+	if err != nil {
+		return MaintenanceWindows{}, err
+	}
+
+	if !info.Found() {
+		return MaintenanceWindows{}, nil
+	}
+
+	return parseMaintenanceWindows(info.Value)
+}
+
+/*
+Waits until a maintenance window of the configuration prefix is open.
+If a decision key is given, the wait also ends when the key exists and the key is created with the time the window was seen open otherwise.
+The windows, abort and decision keys are watched, so that changes to them are taken into account right away, and a timer is set for the next opening of a window.
+ErrUpdateAborted is returned if the abort key of the update is created while waiting.
+*/
+func waitOnMaintenanceWindow(cli *client.EtcdClient, prefix string, decisionKey string, abortKey string, log logger.Logger) error {
+	windowsKey := fmt.Sprintf(ETCD_MAINTENANCE_WINDOWS_CONFIG_KEY, prefix)
+	info, err := cli.GetPrefix(windowsKey)
+	if err != nil {
+		return err
+	}
+
+	values := map[string]string{}
+	if val, ok := info.Keys[windowsKey]; ok {
+		values[windowsKey] = val.Value
+	}
+
+	if decisionKey != "" {
+		decision, decisionErr := cli.GetKey(decisionKey, client.GetKeyOptions{})
+		if decisionErr != nil {
+			return decisionErr
+		}
+
+		if decision.Found() {
+			return nil
+		}
+	}
+
+	windowsCh, stopWindowsWatch := watchKey(cli, windowsKey, info.Revision)
+	defer stopWindowsWatch()
+
+	abortCh, stopAbortWatch := watchKey(cli, abortKey, info.Revision)
+	defer stopAbortWatch()
+
+	var decisionCh <-chan client.WatchNotification
+	if decisionKey != "" {
+		var stopDecisionWatch func()
+		decisionCh, stopDecisionWatch = watchKey(cli, decisionKey, info.Revision)
+		defer stopDecisionWatch()
+	}
+
+	aborted, abortErr := IsTaskAborted(cli, abortKey)
+	if abortErr != nil {
+		return abortErr
+	}
+
+	openingTimer := time.NewTimer(time.Hour)
+	openingTimer.Stop()
+	defer openingTimer.Stop()
+
+	logged := false
+	for true {
+		windows, parseErr := parseMaintenanceWindows(values[windowsKey])
+		if parseErr != nil {
+			return parseErr
+		}
+
+		now := time.Now()
+		open, openErr := windows.IsOpen(now)
+		if openErr != nil {
+			return openErr
+		}
+
+		if open {
+			if decisionKey != "" {
+				_, txErr := commitTransaction(
+					cli,
+					[]clientv3.Cmp{clientv3.Compare(clientv3.Version(decisionKey), "=", 0)},
+					[]clientv3.Op{clientv3.OpPut(decisionKey, now.UTC().Format(time.RFC3339))},
+				)
+				if txErr != nil {
+					return txErr
+				}
+			}
+
+			if logged {
+				log.Infof("[etcd] A maintenance window is open. Resuming the update")
+			}
+			return nil
+		}
+
+		if aborted {
+			return ErrUpdateAborted
+		}
+
+		next, nextErr := windows.NextOpening(now)
+		if nextErr != nil {
+			return nextErr
+		}
+
+		if !logged {
+			log.Infof("[etcd] No maintenance window is open. Holding the update until one opens at %s", next.Format(time.RFC3339))
+			logged = true
+		}
+
+		if !openingTimer.Stop() {
+			select {
+			case <-openingTimer.C:
+			default:
+			}
+		}
+		openingTimer.Reset(time.Until(next))
+
+		select {
+		case res, ok := <-windowsCh:
+			if !ok {
+				return errors.New(fmt.Sprintf("Watch on maintenance windows key %s stopped unexpectedly", windowsKey))
+			}
+
+			if res.Error != nil {
+				return res.Error
+			}
+
+			res.Changes.ApplyOn(values)
+		case res, ok := <-abortCh:
+			if !ok {
+				return errors.New(fmt.Sprintf("Watch on abort key %s stopped unexpectedly", abortKey))
+			}
+
+			if res.Error != nil {
+				return res.Error
+			}
+
+			_, aborted = res.Changes.Upserts[abortKey]
+		case res, ok := <-decisionCh:
+			if !ok {
+				return errors.New(fmt.Sprintf("Watch on maintenance window decision key %s stopped unexpectedly", decisionKey))
+			}
+
+			if res.Error != nil {
+				return res.Error
+			}
+
+			if val, decided := res.Changes.Upserts[decisionKey]; decided {
+				log.Infof("[etcd] Another host saw a maintenance window open at %s. Resuming the update", val.Value)
+				return nil
+			}
+		case <-openingTimer.C:
+		}
+	}
+
+	return nil
+}
+
+/*
+Waits until a maintenance window of the configuration prefix is open.
+ErrUpdateAborted is returned if the abort key of the update is created while waiting.
+*/
+func WaitOnMaintenanceWindow(cli *client.EtcdClient, prefix string, abortKey string, log logger.Logger) error {
+	return waitOnMaintenanceWindow(cli, prefix, "", abortKey, log)
+}
+
+/*
+Waits until minio can be stopped for the update, which is decided once for the whole cluster.
+The first host to see an open maintenance window records it under the window key of the update and the other hosts honour that decision, even if the window closed in the meantime.
+This way, minio is never stopped on only part of the cluster while the other hosts wait for the next window.
+*/
+func WaitOnShutdownWindow(cli *client.EtcdClient, prefix string, windowKey string, abortKey string, log logger.Logger) error {
+	return waitOnMaintenanceWindow(cli, prefix, windowKey, abortKey, log)
+}
@@ -0,0 +1,236 @@
+package etcd
+
+import (
+	"errors"
+	"testing"
+	"time"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
+	"github.com/Ferlab-Ste-Justine/etcd-sdk/testutils"
+)
+
+func TestMaintenanceWindowIsOpen(t *testing.T) {
+	montreal, locErr := time.LoadLocation("America/Montreal")
+	if locErr != nil {
+		t.Errorf("Error occured loading timezone: %s", locErr.Error())
+		return
+	}
+
+	weekend := MaintenanceWindow{Days: []string{"sat", "Sunday"}, Start: "22:00", End: "06:00", Timezone: "America/Montreal"}
+	daily := MaintenanceWindow{Start: "12:00", End: "13:00"}
+
+	tests := []struct {
+		Windows  MaintenanceWindows
+		Time     time.Time
+		Expected bool
+	}{
+		{MaintenanceWindows{}, time.Date(2024, 5, 1, 10, 0, 0, 0, montreal), true},
+		//Saturday night
+		{MaintenanceWindows{weekend}, time.Date(2024, 5, 4, 23, 0, 0, 0, montreal), true},
+		//Sunday morning, in the window started on saturday
+		{MaintenanceWindows{weekend}, time.Date(2024, 5, 5, 5, 59, 0, 0, montreal), true},
+		//Monday morning, in the window started on sunday
+		{MaintenanceWindows{weekend}, time.Date(2024, 5, 6, 1, 0, 0, 0, montreal), true},
+		//Tuesday morning
+		{MaintenanceWindows{weekend}, time.Date(2024, 5, 7, 1, 0, 0, 0, montreal), false},
+		//Saturday afternoon
+		{MaintenanceWindows{weekend}, time.Date(2024, 5, 4, 15, 0, 0, 0, montreal), false},
+		//Saturday night in Montreal, but sunday morning in UTC
+		{MaintenanceWindows{weekend}, time.Date(2024, 5, 5, 3, 0, 0, 0, time.UTC), true},
+		{MaintenanceWindows{daily}, time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC), true},
+		{MaintenanceWindows{daily}, time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC), false},
+		{MaintenanceWindows{weekend, daily}, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), true},
+	}
+
+	for idx, test := range tests {
+		open, err := test.Windows.IsOpen(test.Time)
+		if err != nil {
+			t.Errorf("Error occured checking maintenance windows of test %d: %s", idx, err.Error())
+			continue
+		}
+
+		if open != test.Expected {
+			t.Errorf("Expected maintenance windows of test %d to be open=%t at %s and got open=%t", idx, test.Expected, test.Time.String(), open)
+		}
+	}
+
+	invalid := []MaintenanceWindow{
+		MaintenanceWindow{Days: []string{"someday"}, Start: "22:00", End: "06:00"},
+		MaintenanceWindow{Start: "25:00", End: "06:00"},
+		MaintenanceWindow{Start: "22:00", End: "06:00", Timezone: "Nowhere/Nothing"},
+	}
+
+	for _, window := range invalid {
+		_, err := window.IsOpen(time.Now())
+		if err == nil {
+			t.Errorf("Expected invalid maintenance window %v to return an error", window)
+		}
+	}
+}
+
+func TestMaintenanceWindowsNextOpening(t *testing.T) {
+	montreal, locErr := time.LoadLocation("America/Montreal")
+	if locErr != nil {
+		t.Errorf("Error occured loading timezone: %s", locErr.Error())
+		return
+	}
+
+	weekend := MaintenanceWindow{Days: []string{"sat", "Sunday"}, Start: "22:00", End: "06:00", Timezone: "America/Montreal"}
+	daily := MaintenanceWindow{Start: "12:00", End: "13:00"}
+	allDay := MaintenanceWindow{Days: []string{"wed"}, Start: "00:00", End: "00:00"}
+
+	tests := []struct {
+		Windows  MaintenanceWindows
+		Time     time.Time
+		Expected time.Time
+	}{
+		//Tuesday morning
+		{MaintenanceWindows{weekend}, time.Date(2024, 5, 7, 1, 0, 0, 0, montreal), time.Date(2024, 5, 11, 22, 0, 0, 0, montreal)},
+		//Saturday night, after the window opened
+		{MaintenanceWindows{weekend}, time.Date(2024, 5, 4, 23, 0, 0, 0, montreal), time.Date(2024, 5, 5, 22, 0, 0, 0, montreal)},
+		{MaintenanceWindows{daily}, time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+		{MaintenanceWindows{daily}, time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC), time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)},
+		{MaintenanceWindows{weekend, daily}, time.Date(2024, 5, 4, 13, 0, 0, 0, time.UTC), time.Date(2024, 5, 4, 22, 0, 0, 0, montreal)},
+		{MaintenanceWindows{weekend, daily}, time.Date(2024, 5, 7, 13, 0, 0, 0, time.UTC), time.Date(2024, 5, 8, 12, 0, 0, 0, time.UTC)},
+		//Tuesday, the window lasting the whole wednesday opens at midnight
+		{MaintenanceWindows{allDay}, time.Date(2024, 5, 7, 10, 0, 0, 0, time.UTC), time.Date(2024, 5, 8, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for idx, test := range tests {
+		next, err := test.Windows.NextOpening(test.Time)
+		if err != nil {
+			t.Errorf("Error occured getting the next opening of the maintenance windows of test %d: %s", idx, err.Error())
+			continue
+		}
+
+		if !next.Equal(test.Expected) {
+			t.Errorf("Expected maintenance windows of test %d to next open at %s and got %s", idx, test.Expected.String(), next.String())
+		}
+	}
+}
+
+func TestWaitOnShutdownWindow(t *testing.T) {
+	tearDown, launchErr := testutils.LaunchTestEtcdCluster("../test", testutils.EtcdTestClusterOpts{})
+	if launchErr != nil {
+		t.Errorf("Error occured launching test etcd cluster: %s", launchErr.Error())
+		return
+	}
+
+	defer func() {
+		errs := tearDown()
+		if len(errs) > 0 {
+			t.Errorf("Errors occured tearing down etcd cluster: %s", errs[0].Error())
+		}
+	}()
+
+	retryInterval, _ := time.ParseDuration("1s")
+	timeouts, _ := time.ParseDuration("10s")
+	retries := uint64(10)
+	cli := setupTestEnv(t, timeouts, retryInterval, retries)
+	log := logger.Logger{LogLevel: logger.ERROR}
+
+	first := &MinioRelease{Version: "v1"}
+	err := WaitOnShutdownWindow(cli, "/cfg/", first.GetWindowKey("/ws/"), first.GetAbortKey("/ws/"), log)
+	if err != nil {
+		t.Errorf("Error occured waiting on the shutdown window: %s", err.Error())
+	}
+
+	info, infoErr := cli.GetKey(first.GetWindowKey("/ws/"), client.GetKeyOptions{})
+	if infoErr != nil {
+		t.Errorf("Error occured getting the window key: %s", infoErr.Error())
+	}
+
+	if !info.Found() {
+		t.Errorf("Expected the host that saw an open window to record it for the other hosts and it didn't")
+	}
+
+	now := time.Now().UTC()
+	closed := MaintenanceWindows{MaintenanceWindow{
+		Start: now.Add(2 * time.Hour).Format(MAINTENANCE_WINDOW_TIME_FORMAT),
+		End:   now.Add(3 * time.Hour).Format(MAINTENANCE_WINDOW_TIME_FORMAT),
+	}}
+	output, _ := yaml.Marshal(&closed)
+	_, putErr := cli.PutKey("/cfg/maintenance_windows", string(output))
+	if putErr != nil {
+		t.Errorf("Error occured putting maintenance windows: %s", putErr.Error())
+	}
+
+	err = WaitOnShutdownWindow(cli, "/cfg/", first.GetWindowKey("/ws/"), first.GetAbortKey("/ws/"), log)
+	if err != nil {
+		t.Errorf("Expected a host to honour the open window seen by another host after the window closed and got error: %v", err)
+	}
+
+	second := &MinioRelease{Version: "v2"}
+	_, putErr = cli.PutKey(second.GetAbortKey("/ws/"), "true")
+	if putErr != nil {
+		t.Errorf("Error occured putting abort key: %s", putErr.Error())
+	}
+
+	err = WaitOnShutdownWindow(cli, "/cfg/", second.GetWindowKey("/ws/"), second.GetAbortKey("/ws/"), log)
+	if !errors.Is(err, ErrUpdateAborted) {
+		t.Errorf("Expected a closed window without a recorded decision to hold the update until it was aborted and got: %v", err)
+	}
+
+	info, infoErr = cli.GetKey(second.GetWindowKey("/ws/"), client.GetKeyOptions{})
+	if infoErr != nil {
+		t.Errorf("Error occured getting the window key: %s", infoErr.Error())
+	}
+
+	if info.Found() {
+		t.Errorf("Expected no window decision to be recorded while the window is closed")
+	}
+
+	third := &MinioRelease{Version: "v3"}
+	done := make(chan error)
+	go func() {
+		done <- WaitOnShutdownWindow(cli, "/cfg/", third.GetWindowKey("/ws/"), third.GetAbortKey("/ws/"), log)
+	}()
+
+	select {
+	case err = <-done:
+		t.Errorf("Expected a closed window to hold the update and it didn't: %v", err)
+	case <-time.After(time.Second):
+	}
+
+	delErr := cli.DeleteKey("/cfg/maintenance_windows")
+	if delErr != nil {
+		t.Errorf("Error occured deleting maintenance windows: %s", delErr.Error())
+	}
+
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Errorf("Expected the update to resume once the maintenance windows were removed and got error: %s", err.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected the update to resume shortly after the maintenance windows were removed and it didn't")
+	}
+
+	_, putErr = cli.PutKey("/cfg/maintenance_windows", string(output))
+	if putErr != nil {
+		t.Errorf("Error occured putting maintenance windows: %s", putErr.Error())
+	}
+
+	fourth := &MinioRelease{Version: "v4"}
+	go func() {
+		done <- WaitOnShutdownWindow(cli, "/cfg/", fourth.GetWindowKey("/ws/"), fourth.GetAbortKey("/ws/"), log)
+	}()
+
+	time.Sleep(time.Second)
+	_, putErr = cli.PutKey(fourth.GetAbortKey("/ws/"), "true")
+	if putErr != nil {
+		t.Errorf("Error occured putting abort key: %s", putErr.Error())
+	}
+
+	select {
+	case err = <-done:
+		if !errors.Is(err, ErrUpdateAborted) {
+			t.Errorf("Expected the update to be aborted while waiting on a maintenance window and got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected the wait on a maintenance window to stop shortly after the update was aborted and it didn't")
+	}
+}
@@ -2,6 +2,7 @@ package fs
 
 import (
 	"crypto/sha256"
+	"crypto/sha512"
 	"fmt"
 	"io"
 	"os"
@@ -37,6 +38,22 @@ func GetFileSha256(src string) (string, error) {
 	return fmt.Sprintf("%x", hash.Sum(nil)), nil
 }
 
+func GetFileSha512(src string) (string, error) {
+	fHandle, handleErr := os.Open(src)
+	if handleErr != nil {
+		return "", handleErr
+	}
+	defer fHandle.Close()
+
+	hash := sha512.New()
+	_, copyErr := io.Copy(hash, fHandle)
+	if copyErr != nil {
+		return "", copyErr
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
 func GetTopSubDirectories(parentDir string) ([]string, error) {
     list, listErr := os.ReadDir(parentDir)
     if listErr != nil {
@@ -69,4 +86,22 @@ func KeepLastDirectories(amount int64, dirs []string) error {
 	}
 
 	return nil
+}
+
+/*
+Renames a file and syncs its parent directory, so that the file is either at its old path or at its new path after a crash.
+*/
+func AtomicRename(src string, dst string) error {
+	renameErr := os.Rename(src, dst)
+	if renameErr != nil {
+		return renameErr
+	}
+
+	dir, dirErr := os.Open(path.Dir(dst))
+	if dirErr != nil {
+		return dirErr
+	}
+	defer dir.Close()
+
+	return dir.Sync()
 }
\ No newline at end of file
@@ -0,0 +1,126 @@
+package health
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Ferlab-Ste-Justine/ferio/auth"
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+	"github.com/Ferlab-Ste-Justine/ferio/pool"
+	"github.com/Ferlab-Ste-Justine/ferio/systemd"
+)
+
+const MINIO_LIVENESS_PATH = "/minio/health/live"
+
+type HealthCheckConfig struct {
+	Scheme   string
+	CaCert   string        `yaml:"ca_cert"`
+	Timeout  time.Duration
+	Interval time.Duration
+}
+
+func (conf *HealthCheckConfig) SetDefaults() {
+	if conf.Scheme == "" {
+		conf.Scheme = "https"
+	}
+
+	if conf.Timeout == 0 {
+		conf.Timeout = 5 * time.Minute
+	}
+
+	if conf.Interval == 0 {
+		conf.Interval = 5 * time.Second
+	}
+}
+
+func getHttpClient(conf HealthCheckConfig) (*http.Client, error) {
+	a := auth.Auth{CaCert: conf.CaCert}
+	tlsConf, tlsErr := a.GetTlsConfigs()
+	if tlsErr != nil {
+		return nil, tlsErr
+	}
+
+	return &http.Client{
+		Timeout: conf.Interval,
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConf,
+		},
+	}, nil
+}
+
+func GetMinioLivenessUrl(host string, pools pool.MinioServerPools, service systemd.MinioService, conf HealthCheckConfig) (string, error) {
+	hostPool, found := pools.GetHostPool(host)
+	if !found {
+		if len(pools) == 0 {
+			return "", errors.New(fmt.Sprintf("Cannot determine the api port of %s as there are no server pools", service.GetUnitName()))
+		}
+		hostPool = &pools[0]
+	}
+
+	return fmt.Sprintf("%s://%s:%d%s", conf.Scheme, host, hostPool.GetApiPort(service.TenantName), MINIO_LIVENESS_PATH), nil
+}
+
+func checkUrl(cli *http.Client, url string) error {
+	res, err := cli.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("Server returned status code %d", res.StatusCode))
+	}
+
+	return nil
+}
+
+func checkService(cli *http.Client, url string, service systemd.MinioService) error {
+	activeState, subState, stateErr := systemd.GetMinioServiceState(service)
+	if stateErr != nil {
+		return stateErr
+	}
+
+	if activeState != "active" || subState != "running" {
+		return errors.New(fmt.Sprintf("Unit is %s (%s)", activeState, subState))
+	}
+
+	return checkUrl(cli, url)
+}
+
+/*
+Waits until the unit of each minio service is active (running) and its liveness endpoint responds successfully.
+An error is returned if a service is not healthy before the health check timeout.
+*/
+func CheckMinioServices(host string, pools pool.MinioServerPools, services []systemd.MinioService, conf HealthCheckConfig, log logger.Logger) error {
+	cli, cliErr := getHttpClient(conf)
+	if cliErr != nil {
+		return cliErr
+	}
+
+	deadline := time.Now().Add(conf.Timeout)
+	for _, service := range services {
+		url, urlErr := GetMinioLivenessUrl(host, pools, service, conf)
+		if urlErr != nil {
+			return urlErr
+		}
+
+		log.Infof("[health] Checking that %s is running and live at %s", service.GetUnitName(), url)
+		for true {
+			checkErr := checkService(cli, url, service)
+			if checkErr == nil {
+				break
+			}
+
+			if time.Now().After(deadline) {
+				return errors.New(fmt.Sprintf("%s was not healthy after %s: %s", service.GetUnitName(), conf.Timeout.String(), checkErr.Error()))
+			}
+
+			log.Debugf("[health] %s is not healthy yet: %s", service.GetUnitName(), checkErr.Error())
+			time.Sleep(conf.Interval)
+		}
+	}
+
+	return nil
+}
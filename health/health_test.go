@@ -0,0 +1,51 @@
+package health
+
+import (
+	"testing"
+
+	"github.com/Ferlab-Ste-Justine/ferio/pool"
+	"github.com/Ferlab-Ste-Justine/ferio/systemd"
+)
+
+func TestGetMinioLivenessUrl(t *testing.T) {
+	pools := pool.MinioServerPools{
+		pool.MinioServerPool{
+			ApiPort:          9000,
+			DomainTemplate:   "server%s.minio.ferlab.lan",
+			ServerCountBegin: 1,
+			ServerCountEnd:   4,
+		},
+		pool.MinioServerPool{
+			ApiPort:          9000,
+			Tenants:          []pool.ServerPoolTenant{pool.ServerPoolTenant{Name: "tenant1", ApiPort: 9001}},
+			DomainTemplate:   "server%s.minio.ferlab.lan",
+			ServerCountBegin: 5,
+			ServerCountEnd:   8,
+		},
+	}
+	conf := HealthCheckConfig{}
+	conf.SetDefaults()
+
+	url, err := GetMinioLivenessUrl("server2.minio.ferlab.lan", pools, systemd.MinioService{Name: "minio"}, conf)
+	if err != nil {
+		t.Errorf("Error occured getting liveness url: %s", err.Error())
+	}
+
+	if url != "https://server2.minio.ferlab.lan:9000/minio/health/live" {
+		t.Errorf("Expected liveness url of a host in the first pool to use its api port and it was: %s", url)
+	}
+
+	url, err = GetMinioLivenessUrl("server6.minio.ferlab.lan", pools, systemd.MinioService{Name: "minio", TenantName: "tenant1"}, conf)
+	if err != nil {
+		t.Errorf("Error occured getting liveness url: %s", err.Error())
+	}
+
+	if url != "https://server6.minio.ferlab.lan:9001/minio/health/live" {
+		t.Errorf("Expected liveness url of a tenant to use the tenant's api port and it was: %s", url)
+	}
+
+	_, err = GetMinioLivenessUrl("server6.minio.ferlab.lan", pool.MinioServerPools{}, systemd.MinioService{Name: "minio"}, conf)
+	if err == nil {
+		t.Errorf("Expected getting a liveness url without server pools to fail and it didn't")
+	}
+}
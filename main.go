@@ -1,13 +1,18 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"strings"
 
 	"github.com/Ferlab-Ste-Justine/ferio/binary"
+	"github.com/Ferlab-Ste-Justine/ferio/commands"
 	"github.com/Ferlab-Ste-Justine/ferio/config"
 	"github.com/Ferlab-Ste-Justine/ferio/etcd"
 	"github.com/Ferlab-Ste-Justine/ferio/fs"
 	"github.com/Ferlab-Ste-Justine/ferio/logger"
+	"github.com/Ferlab-Ste-Justine/ferio/metrics"
 	"github.com/Ferlab-Ste-Justine/ferio/systemd"
 	"github.com/Ferlab-Ste-Justine/ferio/update"
 	"github.com/Ferlab-Ste-Justine/ferio/utils"
@@ -31,100 +36,358 @@ func EnsureBinariesDirExist(binsDir string, log logger.Logger) error {
 	return nil
 }
 
-func Startup(cli *client.EtcdClient, conf config.Config, log logger.Logger) (*etcd.MinioServerPools, *etcd.MinioRelease, error) {	
-	pools, _, poolsErr := etcd.GetMinioServerPools(cli, conf.Etcd.ConfigPrefix)
+/*
+Ensures that the host ferio runs on is one of the hosts of the server pools, so that it can take part in updates.
+*/
+func CheckHost(cli *client.EtcdClient, conf config.Config) error {
+	pools, _, poolsErr := etcd.GetMinioServerPools(cli, conf.Etcd.ConfigPrefix, conf.Signatures)
 	if poolsErr != nil {
-		return nil, nil, poolsErr
+		return poolsErr
 	}
 
-	rel, _, relErr := etcd.GetMinioRelease(cli, conf.Etcd.ConfigPrefix)
-	if relErr != nil {
-		return nil, nil, relErr
+	if _, found := pools.Pools.GetHostPool(conf.Host); found {
+		return nil
 	}
 
-	serviceExists, serviceExistsErr := systemd.MinioServicesExists(conf.MinioServices)
-	if serviceExistsErr != nil {
-		return nil, nil, serviceExistsErr
+	applied, appliedErr := etcd.GetAppliedPools(cli, conf.Etcd.WorkspacePrefix, conf.Signatures)
+	if appliedErr != nil {
+		return appliedErr
 	}
 
-	minPath := binary.GetMinioPathFromVersion(conf.BinariesDir, rel.Version)
-
-	if !serviceExists {
-		log.Infof("[main] Minio service not found. Will generate it")
-		downErr := binary.GetBinary(rel.Url, rel.Version, rel.Checksum, conf.BinariesDir, log)
-		if downErr != nil {
-			return nil, nil, downErr
+	if applied != nil {
+		if _, found := applied.Pools.GetHostPool(conf.Host); found {
+			return nil
 		}
+	}
 
-		refrErr := systemd.RefreshMinioSystemdUnits(minPath, pools.Pools, conf.MinioServices, log)
-		if refrErr != nil {
-			return nil, nil, refrErr
-		}
+	return errors.New(fmt.Sprintf("Host %s is not one of the hosts of the server pools: %s", conf.Host, strings.Join(pools.Pools.GetHosts(), ", ")))
+}
+
+/*
+Returns the current host exclusions.
+If the host is excluded, minio is stopped and the exclusions are only returned once the host is no longer excluded.
+*/
+func WaitOnExclusion(cli *client.EtcdClient, conf config.Config, log logger.Logger) (etcd.HostExclusions, error) {
+	excls, exclsErr := etcd.GetHostExclusions(cli, conf.Etcd.ConfigPrefix)
+	if exclsErr != nil {
+		return excls, exclsErr
 	}
 
-	_, updErr := update.UpdatePools(cli, conf.Etcd.WorkspacePrefix, minPath, pools, conf.Host, conf.MinioServices, log)
-	if updErr != nil {
-		return nil, nil, updErr
+	if !excls.IsExcluded(conf.Host) {
+		return excls, nil
 	}
 
-	updatedRelease, updRelErr := update.UpdateRelease(cli, conf.Etcd.WorkspacePrefix, conf.BinariesDir, rel, pools, conf.Host, conf.MinioServices, log)
-	if updRelErr != nil {
-		return nil, nil, updRelErr
+	stopErr := systemd.StopMinioServices(conf.MinioServices, log)
+	if stopErr != nil {
+		return excls, stopErr
 	}
 
-	startErr := systemd.StartMinioServices(conf.MinioServices, log)
-	if startErr != nil {
-		return nil, nil, startErr
+	waitErr := etcd.WaitOnHostExclusion(cli, conf.Etcd.ConfigPrefix, conf.Host, log)
+	if waitErr != nil {
+		return excls, waitErr
 	}
 
-	if updatedRelease {
-		cleanupErr := binary.CleanupOldBinaries(conf.BinariesDir, log)
-		if cleanupErr != nil {
-			return nil, nil, cleanupErr
-		}
+	return etcd.GetHostExclusions(cli, conf.Etcd.ConfigPrefix)
+}
+
+/*
+Brings the binary and units of minio to the last applied release and server pools, before minio is started.
+This is for a host that missed updates, because it was excluded or down when the other hosts applied them.
+*/
+func ConvergeToApplied(cli *client.EtcdClient, conf config.Config, log logger.Logger) error {
+	appliedPools, appliedPoolsErr := etcd.GetAppliedPools(cli, conf.Etcd.WorkspacePrefix, conf.Signatures)
+	if appliedPoolsErr != nil {
+		return appliedPoolsErr
+	}
+
+	appliedRel, appliedRelErr := etcd.GetAppliedRelease(cli, conf.Etcd.WorkspacePrefix, conf.Signatures)
+	if appliedRelErr != nil {
+		return appliedRelErr
+	}
+
+	if appliedPools == nil || appliedRel == nil {
+		return nil
+	}
+
+	if _, found := appliedPools.Pools.GetHostPool(conf.Host); !found {
+		return nil
 	}
 
-	return pools, rel, nil
+	minPath := binary.GetMinioPathFromVersion(conf.BinariesDir, appliedRel.Version)
+	match, matchErr := systemd.MinioSystemdUnitsMatch(minPath, appliedPools.Pools, conf.MinioServices)
+	if matchErr != nil {
+		return matchErr
+	}
+
+	if match {
+		return nil
+	}
+
+	log.Infof("[main] Minio units do not match the applied release %s and server pools %s. Bringing them up to date", appliedRel.Version, appliedPools.Version)
+
+	downErr := update.GetReleaseBinary(cli, conf.Etcd.WorkspacePrefix, conf.BinariesDir, conf.Download, appliedRel, log)
+	if downErr != nil {
+		return downErr
+	}
+
+	stopErr := systemd.StopMinioServices(conf.MinioServices, log)
+	if stopErr != nil {
+		return stopErr
+	}
+
+	return systemd.RefreshMinioSystemdUnits(minPath, appliedPools.Pools, conf.MinioServices, log)
 }
 
-func RuntimeLoop(cli *client.EtcdClient, conf config.Config, startPools *etcd.MinioServerPools, startRel *etcd.MinioRelease, log logger.Logger) error {
-	ch := etcd.HandleChanges(
-		cli,
-		conf.Etcd.ConfigPrefix,
-		startPools,
-		func(newPools *etcd.MinioServerPools, currentRel *etcd.MinioRelease) error {
-			minPath := binary.GetMinioPathFromVersion(conf.BinariesDir, currentRel.Version)
-			_, updErr := update.UpdatePools(cli, conf.Etcd.WorkspacePrefix, minPath, newPools, conf.Host, conf.MinioServices, log)
-			if updErr != nil {
-				return  updErr
-			}
+/*
+Returns whether the update was completed by the other hosts while the host was excluded and later updates were applied on top of it.
+The host should then converge to the applied configurations rather than catch up on the phases of the stale update.
+*/
+func isUpdateSuperseded(cli *client.EtcdClient, conf config.Config, kind string, version string, poolsVersion string, relVersion string) (bool, error) {
+	state, stateErr := etcd.GetQueuedUpdateState(cli, conf.Etcd.WorkspacePrefix, kind, version)
+	if stateErr != nil {
+		return false, stateErr
+	}
 
+	if state == "" || state == etcd.QUEUE_STATE_QUEUED || state == etcd.QUEUE_STATE_STARTED {
+		return false, nil
+	}
+
+	appliedPools, appliedPoolsErr := etcd.GetAppliedPools(cli, conf.Etcd.WorkspacePrefix, conf.Signatures)
+	if appliedPoolsErr != nil {
+		return false, appliedPoolsErr
+	}
+
+	appliedRel, appliedRelErr := etcd.GetAppliedRelease(cli, conf.Etcd.WorkspacePrefix, conf.Signatures)
+	if appliedRelErr != nil {
+		return false, appliedRelErr
+	}
+
+	if appliedPools == nil || appliedRel == nil {
+		return false, nil
+	}
+
+	return appliedPools.Version != poolsVersion || appliedRel.Version != relVersion, nil
+}
+
+/*
+Brings minio to the applied configurations in place of a superseded update and starts it if requested.
+*/
+func convergeSupersededUpdate(cli *client.EtcdClient, conf config.Config, kind string, version string, startServices bool, log logger.Logger) error {
+	log.Infof("[main] The %s update at version %s was completed and superseded while host %s was excluded. Converging to the applied configurations instead", kind, version, conf.Host)
+
+	convErr := ConvergeToApplied(cli, conf, log)
+	if convErr != nil {
+		return convErr
+	}
+
+	if !startServices {
+		return nil
+	}
+
+	appliedPools, appliedPoolsErr := etcd.GetAppliedPools(cli, conf.Etcd.WorkspacePrefix, conf.Signatures)
+	if appliedPoolsErr != nil {
+		return appliedPoolsErr
+	}
+
+	if _, inPools := appliedPools.Pools.GetHostPool(conf.Host); !inPools {
+		return nil
+	}
+
+	return systemd.StartMinioServices(conf.MinioServices, log)
+}
+
+func GetPoolsUpdateAction(cli *client.EtcdClient, conf config.Config, startServices bool, log logger.Logger) etcd.ServerPoolsChangeAction {
+	return func(newPools *etcd.MinioServerPools, currentRel *etcd.MinioRelease) error {
+		excls, exclsErr := WaitOnExclusion(cli, conf, log)
+		if exclsErr != nil {
+			return exclsErr
+		}
+
+		superseded, supersededErr := isUpdateSuperseded(cli, conf, etcd.QUEUE_KIND_POOLS, newPools.Version, newPools.Version, currentRel.Version)
+		if supersededErr != nil {
+			return supersededErr
+		}
+
+		if superseded {
+			return convergeSupersededUpdate(cli, conf, etcd.QUEUE_KIND_POOLS, newPools.Version, startServices, log)
+		}
+
+		updatedPools, updErr := update.UpdatePools(cli, conf.Etcd.ConfigPrefix, conf.Etcd.WorkspacePrefix, conf.BinariesDir, conf.Download, currentRel, newPools, conf.Host, excls, conf.MinioServices, conf.BarrierTimeouts, conf.HealthCheck, conf.Signatures, log)
+		if updErr != nil {
+			return  updErr
+		}
+
+		if startServices {
 			startErr := systemd.StartMinioServices(conf.MinioServices, log)
 			if startErr != nil {
 				return startErr
 			}
+		}
 
-			return nil
-		},
-		startRel,
-		func(newRel *etcd.MinioRelease, currentPools *etcd.MinioServerPools) error {
-			_, updErr := update.UpdateRelease(cli, conf.Etcd.WorkspacePrefix, conf.BinariesDir, newRel, currentPools, conf.Host, conf.MinioServices, log)
-			if updErr != nil {
-				return updErr
+		if updatedPools {
+			gcErr := etcd.RunWorkspaceGc(cli, conf.Etcd.ConfigPrefix, conf.Etcd.WorkspacePrefix, conf.Host, conf.WorkspaceGc, log)
+			if gcErr != nil {
+				return gcErr
 			}
-			
+		}
+
+		return nil
+	}
+}
+
+func GetReleaseUpdateAction(cli *client.EtcdClient, conf config.Config, startServices bool, log logger.Logger) etcd.ReleaseChangeAction {
+	return func(newRel *etcd.MinioRelease, currentPools *etcd.MinioServerPools) error {
+		excls, exclsErr := WaitOnExclusion(cli, conf, log)
+		if exclsErr != nil {
+			return exclsErr
+		}
+
+		superseded, supersededErr := isUpdateSuperseded(cli, conf, etcd.QUEUE_KIND_RELEASE, newRel.Version, currentPools.Version, newRel.Version)
+		if supersededErr != nil {
+			return supersededErr
+		}
+
+		if superseded {
+			return convergeSupersededUpdate(cli, conf, etcd.QUEUE_KIND_RELEASE, newRel.Version, startServices, log)
+		}
+
+		updatedRelease, updErr := update.UpdateRelease(cli, conf.Etcd.ConfigPrefix, conf.Etcd.WorkspacePrefix, conf.BinariesDir, conf.Download, newRel, currentPools, conf.Host, excls, conf.MinioServices, conf.BarrierTimeouts, conf.HealthCheck, conf.Signatures, log)
+		if updErr != nil {
+			return updErr
+		}
+
+		_, inPools := currentPools.Pools.GetHostPool(conf.Host)
+		if startServices && inPools {
 			startErr := systemd.StartMinioServices(conf.MinioServices, log)
 			if startErr != nil {
 				return startErr
 			}
+		}
 
-			cleanupErr := binary.CleanupOldBinaries(conf.BinariesDir, log)
+		if updatedRelease {
+			keptVersions := []string{newRel.Version}
+
+			previousRel, previousRelErr := etcd.GetAppliedRelease(cli, conf.Etcd.WorkspacePrefix, conf.Signatures)
+			if previousRelErr != nil {
+				return previousRelErr
+			}
+
+			if previousRel != nil {
+				keptVersions = append(keptVersions, previousRel.Version)
+			}
+
+			nextRel, nextRelErr := etcd.GetNextRelease(cli, conf.Etcd.ConfigPrefix, conf.Signatures)
+			if errors.Is(nextRelErr, etcd.ErrInvalidDocumentSignature) {
+				log.Errorf("[main] Not keeping the binary of the upcoming minio release: %s", nextRelErr.Error())
+			} else if nextRelErr != nil {
+				return nextRelErr
+			}
+
+			if nextRel != nil {
+				keptVersions = append(keptVersions, nextRel.Version)
+			}
+
+			cleanupErr := binary.CleanupOldBinaries(conf.BinariesDir, keptVersions, log)
 			if cleanupErr != nil {
 				return cleanupErr
 			}
 
-			return nil
-		},
+			gcErr := etcd.RunWorkspaceGc(cli, conf.Etcd.ConfigPrefix, conf.Etcd.WorkspacePrefix, conf.Host, conf.WorkspaceGc, log)
+			if gcErr != nil {
+				return gcErr
+			}
+		}
+
+		return nil
+	}
+}
+
+func GetPrefetchAction(cli *client.EtcdClient, conf config.Config, log logger.Logger) etcd.ReleasePrefetchAction {
+	return func(nextRel *etcd.MinioRelease) error {
+		downErr := update.GetReleaseBinary(cli, conf.Etcd.WorkspacePrefix, conf.BinariesDir, conf.Download, nextRel, log)
+		if downErr != nil {
+			return downErr
+		}
+
+		return etcd.ReportPrefetch(cli, conf.Etcd.WorkspacePrefix, nextRel, conf.Host)
+	}
+}
+
+func Startup(cli *client.EtcdClient, conf config.Config, log logger.Logger) error {	
+	pools, _, poolsErr := etcd.GetMinioServerPools(cli, conf.Etcd.ConfigPrefix, conf.Signatures)
+	if poolsErr != nil {
+		return poolsErr
+	}
+
+	rel, _, relErr := etcd.GetMinioRelease(cli, conf.Etcd.ConfigPrefix, conf.Signatures)
+	if relErr != nil {
+		return relErr
+	}
+
+	_, exclsErr := WaitOnExclusion(cli, conf, log)
+	if exclsErr != nil {
+		return exclsErr
+	}
+
+	serviceExists, serviceExistsErr := systemd.MinioServicesExists(conf.MinioServices)
+	if serviceExistsErr != nil {
+		return serviceExistsErr
+	}
+
+	if serviceExists {
+		convErr := ConvergeToApplied(cli, conf, log)
+		if convErr != nil {
+			return convErr
+		}
+	} else {
+		log.Infof("[main] Minio service not found. Will generate it")
+		downErr := update.GetReleaseBinary(cli, conf.Etcd.WorkspacePrefix, conf.BinariesDir, conf.Download, rel, log)
+		if downErr != nil {
+			return downErr
+		}
+
+		minPath := binary.GetMinioPathFromVersion(conf.BinariesDir, rel.Version)
+		refrErr := systemd.RefreshMinioSystemdUnits(minPath, pools.Pools, conf.MinioServices, log)
+		if refrErr != nil {
+			return refrErr
+		}
+	}
+
+	queueErr := etcd.EnqueueConfigs(cli, conf.Etcd.ConfigPrefix, conf.Etcd.WorkspacePrefix, conf.Signatures, log)
+	if queueErr != nil {
+		return queueErr
+	}
+
+	procErr := etcd.ProcessQueue(
+		cli,
+		conf.Etcd.ConfigPrefix,
+		conf.Etcd.WorkspacePrefix,
+		GetPoolsUpdateAction(cli, conf, false, log),
+		GetReleaseUpdateAction(cli, conf, false, log),
+		conf.Signatures,
+		log,
+	)
+	if procErr != nil {
+		return procErr
+	}
+
+	startErr := systemd.StartMinioServices(conf.MinioServices, log)
+	if startErr != nil {
+		return startErr
+	}
+
+	return nil
+}
+
+func RuntimeLoop(cli *client.EtcdClient, conf config.Config, log logger.Logger) error {
+	ch := etcd.HandleChanges(
+		cli,
+		conf.Etcd.ConfigPrefix,
+		conf.Etcd.WorkspacePrefix,
+		GetPoolsUpdateAction(cli, conf, true, log),
+		GetReleaseUpdateAction(cli, conf, true, log),
+		GetPrefetchAction(cli, conf, log),
+		conf.Signatures,
 		log,
 	)
 
@@ -140,16 +403,37 @@ func main() {
 
 	log.LogLevel = conf.GetLogLevel()
 
+	if len(os.Args) > 1 {
+		cmdErr := commands.Run(os.Args[1:], conf, log)
+		utils.AbortOnErr(cmdErr, log)
+		return
+	}
+
 	ensBinDirErr := EnsureBinariesDirExist(conf.BinariesDir, log)
 	utils.AbortOnErr(ensBinDirErr, log)
 
+	metricsCh := metrics.Serve(conf.Metrics, log)
+	go func() {
+		metricsErr := <-metricsCh
+		utils.AbortOnErr(errors.New(fmt.Sprintf("Error serving prometheus metrics: %s", metricsErr.Error())), log)
+	}()
+
 	cli, cliErr := etcd.GetClient(conf.Etcd)
 	utils.AbortOnErr(cliErr, log)
 	defer cli.Close()
 
-	startPools, startRel, StartErr := Startup(cli, conf, log)
+	checkErr := CheckHost(cli, conf)
+	utils.AbortOnErr(checkErr, log)
+
+	regCh := etcd.RegisterHost(cli, conf.Etcd.WorkspacePrefix, conf.Host, conf.HostRegistry, log)
+	go func() {
+		regErr := <-regCh
+		utils.AbortOnErr(regErr, log)
+	}()
+
+	StartErr := Startup(cli, conf, log)
 	utils.AbortOnErr(StartErr, log)
 
-	runtimeErr := RuntimeLoop(cli, conf, startPools, startRel, log)
+	runtimeErr := RuntimeLoop(cli, conf, log)
 	utils.AbortOnErr(runtimeErr, log)
 }
\ No newline at end of file
@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	appliedVersion = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ferio_applied_version",
+			Help: "Set to 1 for the version of the minio release and of the server pools that were last applied on the cluster.",
+		},
+		[]string{"kind", "version"},
+	)
+
+	updatePhase = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ferio_update_phase",
+			Help: "Set to 1 for the phase of the update ferio is currently synchronizing on.",
+		},
+		[]string{"kind", "version", "phase"},
+	)
+
+	updatePhaseStart = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ferio_update_phase_start_timestamp_seconds",
+			Help: "Unix timestamp at which ferio entered the phase of the update it is currently synchronizing on.",
+		},
+		[]string{"kind", "version", "phase"},
+	)
+
+	barrierWait = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "ferio_barrier_wait_seconds",
+			Help:    "Time spent waiting on the other minio nodes to complete an update phase.",
+			Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600},
+		},
+		[]string{"kind", "phase"},
+	)
+
+	downloadDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "ferio_binary_download_duration_seconds",
+			Help:    "Time taken to download minio binaries.",
+			Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600},
+		},
+	)
+
+	downloadBytes = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ferio_binary_download_bytes_total",
+			Help: "Number of bytes of minio binaries downloaded.",
+		},
+	)
+
+	downloadFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ferio_binary_download_failures_total",
+			Help: "Number of minio binary downloads that failed.",
+		},
+	)
+
+	systemdFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ferio_systemd_failures_total",
+			Help: "Number of minio systemd units that failed to start or stop.",
+		},
+		[]string{"operation", "unit"},
+	)
+
+	watchRestarts = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ferio_etcd_watch_restarts_total",
+			Help: "Number of times the watch on the etcd configurations keyspace was restarted after an error.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		appliedVersion,
+		updatePhase,
+		updatePhaseStart,
+		barrierWait,
+		downloadDuration,
+		downloadBytes,
+		downloadFailures,
+		systemdFailures,
+		watchRestarts,
+	)
+}
+
+func SetAppliedVersion(kind string, version string) {
+	appliedVersion.DeletePartialMatch(prometheus.Labels{"kind": kind})
+	appliedVersion.WithLabelValues(kind, version).Set(1)
+}
+
+func SetUpdatePhase(kind string, version string, phase string) {
+	ClearUpdatePhase(kind)
+	updatePhase.WithLabelValues(kind, version, phase).Set(1)
+	updatePhaseStart.WithLabelValues(kind, version, phase).SetToCurrentTime()
+}
+
+func ClearUpdatePhase(kind string) {
+	updatePhase.DeletePartialMatch(prometheus.Labels{"kind": kind})
+	updatePhaseStart.DeletePartialMatch(prometheus.Labels{"kind": kind})
+}
+
+func ObserveBarrierWait(kind string, phase string, duration time.Duration) {
+	barrierWait.WithLabelValues(kind, phase).Observe(duration.Seconds())
+}
+
+func ObserveDownload(duration time.Duration, bytes int64) {
+	downloadDuration.Observe(duration.Seconds())
+	downloadBytes.Add(float64(bytes))
+}
+
+func IncDownloadFailures() {
+	downloadFailures.Inc()
+}
+
+func IncSystemdFailures(operation string, unit string) {
+	systemdFailures.WithLabelValues(operation, unit).Inc()
+}
+
+func IncWatchRestarts() {
+	watchRestarts.Inc()
+}
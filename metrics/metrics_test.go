@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSetAppliedVersion(t *testing.T) {
+	SetAppliedVersion("release", "v1")
+	SetAppliedVersion("pools", "v1")
+	SetAppliedVersion("release", "v2")
+
+	if testutil.CollectAndCount(appliedVersion) != 2 {
+		t.Errorf("Expected a single applied version per kind and that was not the case")
+	}
+
+	if testutil.ToFloat64(appliedVersion.WithLabelValues("release", "v2")) != 1 {
+		t.Errorf("Expected the last applied release version to be reported and it wasn't")
+	}
+}
+
+func TestSetUpdatePhase(t *testing.T) {
+	SetUpdatePhase("release", "v2", "binary_download")
+	SetUpdatePhase("release", "v2", "minio_shutdown")
+
+	if testutil.CollectAndCount(updatePhase) != 1 {
+		t.Errorf("Expected a single current phase per kind and that was not the case")
+	}
+
+	ClearUpdatePhase("release")
+
+	if testutil.CollectAndCount(updatePhase) != 0 {
+		t.Errorf("Expected no current phase after clearing it and that was not the case")
+	}
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/Ferlab-Ste-Justine/ferio/logger"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type MetricsConfig struct {
+	Address string
+	Path    string
+}
+
+func (conf *MetricsConfig) SetDefaults() {
+	if conf.Path == "" {
+		conf.Path = "/metrics"
+	}
+}
+
+/*
+Exposes the metrics on the configured address in the background.
+Nothing is done if no address is configured.
+*/
+func Serve(conf MetricsConfig, log logger.Logger) <-chan error {
+	errCh := make(chan error, 1)
+	if conf.Address == "" {
+		return errCh
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(conf.Path, promhttp.Handler())
+
+	go func() {
+		log.Infof("[metrics] Serving prometheus metrics on %s%s", conf.Address, conf.Path)
+		errCh <- http.ListenAndServe(conf.Address, mux)
+	}()
+
+	return errCh
+}
@@ -2,6 +2,8 @@ package pool
 
 import (
 	"fmt"
+	"reflect"
+	"strconv"
 	"strings"
 )
 
@@ -51,6 +53,11 @@ func (pool *MinioServerPool) getTenant(tenantName string) ServerPoolTenant {
 	}
 }
 
+func (pool *MinioServerPool) GetApiPort(tenantName string) int64 {
+	poolTenant := pool.getTenant(tenantName)
+	return poolTenant.ApiPort
+}
+
 func (pool *MinioServerPool) Stringify(tenantName string) string {
 	poolTenant := pool.getTenant(tenantName)
 
@@ -93,4 +100,50 @@ func (pools *MinioServerPools) Stringify(tenantName string) string {
 	}
 
 	return strings.Join(stringifiedPools, " ")
-}
\ No newline at end of file
+}
+
+func (pool *MinioServerPool) GetHosts() []string {
+	hosts := []string{}
+	for idx := pool.ServerCountBegin; idx <= pool.ServerCountEnd; idx++ {
+		hosts = append(hosts, fmt.Sprintf(pool.DomainTemplate, strconv.FormatInt(idx, 10)))
+	}
+	return hosts
+}
+
+func (pools *MinioServerPools) GetHosts() []string {
+	hosts := []string{}
+	for _, pool := range *pools {
+		hosts = append(hosts, pool.GetHosts()...)
+	}
+	return hosts
+}
+
+func (pools *MinioServerPools) GetHostPool(host string) (*MinioServerPool, bool) {
+	for idx, pool := range *pools {
+		for _, poolHost := range pool.GetHosts() {
+			if poolHost == host {
+				return &(*pools)[idx], true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+/*
+Returns whether the server pools are the previous server pools with zero or more pools appended to them.
+This is the only kind of server pools change minio supports.
+*/
+func (pools *MinioServerPools) IsAppendOf(previous MinioServerPools) bool {
+	if len(*pools) < len(previous) {
+		return false
+	}
+
+	for idx, pool := range previous {
+		if !reflect.DeepEqual(pool, (*pools)[idx]) {
+			return false
+		}
+	}
+
+	return true
+}
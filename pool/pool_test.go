@@ -0,0 +1,48 @@
+package pool
+
+import (
+	"testing"
+)
+
+func TestIsAppendOf(t *testing.T) {
+	first := MinioServerPool{
+		ApiPort:           9000,
+		DomainTemplate:    "server%s.minio.ferlab.lan",
+		ServerCountBegin:  1,
+		ServerCountEnd:    4,
+		MountPathTemplate: "/opt/mnt/volume%s",
+		MountCount:        2,
+	}
+	second := first
+	second.ServerCountBegin = 5
+	second.ServerCountEnd = 8
+	changed := first
+	changed.MountCount = 3
+
+	previous := MinioServerPools{first}
+
+	appended := MinioServerPools{first, second}
+	if !appended.IsAppendOf(previous) {
+		t.Errorf("Expected server pools with an appended pool to be an append of the previous server pools")
+	}
+
+	unchanged := MinioServerPools{first}
+	if !unchanged.IsAppendOf(previous) {
+		t.Errorf("Expected identical server pools to be an append of the previous server pools")
+	}
+
+	removed := MinioServerPools{}
+	if removed.IsAppendOf(previous) {
+		t.Errorf("Expected server pools with a removed pool not to be an append of the previous server pools")
+	}
+
+	modified := MinioServerPools{changed, second}
+	if modified.IsAppendOf(previous) {
+		t.Errorf("Expected server pools with a modified pool not to be an append of the previous server pools")
+	}
+
+	reordered := MinioServerPools{second, first}
+	if reordered.IsAppendOf(previous) {
+		t.Errorf("Expected server pools with a pool inserted before the previous pools not to be an append of the previous server pools")
+	}
+}
@@ -16,6 +16,7 @@ import (
 
 	"github.com/Ferlab-Ste-Justine/ferio/fs"
 	"github.com/Ferlab-Ste-Justine/ferio/logger"
+	"github.com/Ferlab-Ste-Justine/ferio/metrics"
 	"github.com/Ferlab-Ste-Justine/ferio/pool"
 )
 
@@ -93,12 +94,10 @@ func DeleteMinioSystemdUnits(services []MinioService, log logger.Logger) error {
 	return nil
 }
 
-func RefreshMinioSystemdUnit(minioPath string, pools pool.MinioServerPools, service MinioService, log logger.Logger) error {
-	log.Infof("[systemd] Generating %s unit file with binary path %s, server pools '%s', and reloading systemd", service.Name, minioPath, pools.Stringify(service.TenantName))
-
+func renderMinioSystemdUnit(minioPath string, pools pool.MinioServerPools, service MinioService) ([]byte, error) {
 	tmpl, tErr := template.New("template").Parse(minioUnitTemplate)
 	if tErr != nil {
-		return tErr
+		return nil, tErr
 	}
 
 	tpl := &UnitFileTemplate{
@@ -110,10 +109,53 @@ func RefreshMinioSystemdUnit(minioPath string, pools pool.MinioServerPools, serv
 	var b bytes.Buffer
 	exErr := tmpl.Execute(&b, tpl)
 	if exErr != nil {
-		return exErr
+		return nil, exErr
+	}
+
+	return b.Bytes(), nil
+}
+
+/*
+Returns whether the unit files of the minio services exist and were generated with the given binary path and server pools.
+*/
+func MinioSystemdUnitsMatch(minioPath string, pools pool.MinioServerPools, services []MinioService) (bool, error) {
+	for _, service := range services {
+		unitPath := path.Join(SYSTEMD_UNIT_FILES_PATH, service.GetUnitName())
+		exists, existsErr := fs.PathExists(unitPath)
+		if existsErr != nil {
+			return false, existsErr
+		}
+
+		if !exists {
+			return false, nil
+		}
+
+		content, readErr := ioutil.ReadFile(unitPath)
+		if readErr != nil {
+			return false, readErr
+		}
+
+		expected, renderErr := renderMinioSystemdUnit(minioPath, pools, service)
+		if renderErr != nil {
+			return false, renderErr
+		}
+
+		if !bytes.Equal(content, expected) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func RefreshMinioSystemdUnit(minioPath string, pools pool.MinioServerPools, service MinioService, log logger.Logger) error {
+	log.Infof("[systemd] Generating %s unit file with binary path %s, server pools '%s', and reloading systemd", service.Name, minioPath, pools.Stringify(service.TenantName))
+
+	unitContent, renderErr := renderMinioSystemdUnit(minioPath, pools, service)
+	if renderErr != nil {
+		return renderErr
 	}
 
-	unitContent := b.Bytes()
 	unitPath := path.Join(SYSTEMD_UNIT_FILES_PATH, service.GetUnitName())
 
 	writeErr := ioutil.WriteFile(unitPath, unitContent, 0640)
@@ -176,7 +218,35 @@ func MinioServicesExists(services []MinioService) (bool, error) {
 	return true, nil
 }
 
+func GetMinioServiceState(service MinioService) (string, string, error) {
+	conn, connErr := dbus.NewSystemdConnectionContext(context.Background())
+	if connErr != nil {
+		return "", "", connErr
+	}
+	defer conn.Close()
+
+	statuses, listErr := conn.ListUnitsByNamesContext(context.Background(), []string{service.GetUnitName()})
+	if listErr != nil {
+		return "", "", listErr
+	}
+
+	if len(statuses) == 0 {
+		return "", "", errors.New(fmt.Sprintf("Could not retrieve the state of %s unit", service.GetUnitName()))
+	}
+
+	return statuses[0].ActiveState, statuses[0].SubState, nil
+}
+
 func StopMinioService(service MinioService, log logger.Logger) error {
+	err := stopMinioService(service, log)
+	if err != nil {
+		metrics.IncSystemdFailures("stop", service.GetUnitName())
+	}
+
+	return err
+}
+
+func stopMinioService(service MinioService, log logger.Logger) error {
 	log.Infof("[systemd] Stopping %s unit", service.GetUnitName())
 
 	exists, existsErr := MinioServiceExists(service)
@@ -225,6 +295,15 @@ func StopMinioServices(services []MinioService, log logger.Logger) error {
 }
 
 func StartMinioService(service MinioService, log logger.Logger) error {
+	err := startMinioService(service, log)
+	if err != nil {
+		metrics.IncSystemdFailures("start", service.GetUnitName())
+	}
+
+	return err
+}
+
+func startMinioService(service MinioService, log logger.Logger) error {
 	log.Infof("[systemd] Starting %s unit", service.GetUnitName())
 
 	exists, existsErr := MinioServiceExists(service)
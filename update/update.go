@@ -1,26 +1,123 @@
 package update
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+
 	"github.com/Ferlab-Ste-Justine/ferio/binary"
 	"github.com/Ferlab-Ste-Justine/ferio/etcd"
+	"github.com/Ferlab-Ste-Justine/ferio/health"
 	"github.com/Ferlab-Ste-Justine/ferio/logger"
+	"github.com/Ferlab-Ste-Justine/ferio/pool"
 	"github.com/Ferlab-Ste-Justine/ferio/systemd"
 
 	"github.com/Ferlab-Ste-Justine/etcd-sdk/client"
 )
 
-func UpdatePools(cli *client.EtcdClient, prefix string, minioPath string, pools *etcd.MinioServerPools, host string, services []systemd.MinioService, log logger.Logger) (bool, error) {
-	upd, updErr := pools.GetUpdate(cli, prefix)
-	if updErr != nil {
-		return false, updErr
+func restartWithConfiguration(minioPath string, pools pool.MinioServerPools, services []systemd.MinioService, log logger.Logger) error {
+	log.Infof("[update] Restarting minio with binary path %s and the previously applied server pools", minioPath)
+
+	stopErr := systemd.StopMinioServices(services, log)
+	if stopErr != nil {
+		return stopErr
 	}
 
-	if upd.IsDone() {
-		log.Debugf("[update] Server pools update is done. Skipping it")
-		return false, nil
+	refrErr := systemd.RefreshMinioSystemdUnits(minioPath, pools, services, log)
+	if refrErr != nil {
+		return refrErr
+	}
+
+	return systemd.StartMinioServices(services, log)
+}
+
+func getHealthCheckAction(host string, pools pool.MinioServerPools, services []systemd.MinioService, healthConf health.HealthCheckConfig, log logger.Logger) etcd.TaskAction {
+	return func() error {
+		startErr := systemd.StartMinioServices(services, log)
+		if startErr != nil {
+			return startErr
+		}
+
+		healthErr := health.CheckMinioServices(host, pools, services, healthConf, log)
+		if healthErr != nil {
+			log.Errorf("[update] Minio failed its health check after the update: %s", healthErr.Error())
+			return etcd.ErrTaskFailed
+		}
+
+		return nil
+	}
+}
+
+/*
+Returns an action that stops minio, once the update is cleared to do so in a maintenance window for the whole cluster.
+*/
+func getShutdownAction(cli *client.EtcdClient, confPrefix string, windowKey string, abortKey string, services []systemd.MinioService, log logger.Logger) etcd.TaskAction {
+	return func() error {
+		waitErr := etcd.WaitOnShutdownWindow(cli, confPrefix, windowKey, abortKey, log)
+		if waitErr != nil {
+			return waitErr
+		}
+
+		return systemd.StopMinioServices(services, log)
+	}
+}
+
+func getPoolsPhaseActions(cli *client.EtcdClient, confPrefix string, prefix string, minioPath string, pools *etcd.MinioServerPools, host string, services []systemd.MinioService, healthConf health.HealthCheckConfig, log logger.Logger) map[string]etcd.TaskAction {
+	return map[string]etcd.TaskAction{
+		"acknowledgment": func() error {
+			return etcd.WaitOnMaintenanceWindow(cli, confPrefix, pools.GetAbortKey(prefix), log)
+		},
+		"minio_shutdown": getShutdownAction(cli, confPrefix, pools.GetWindowKey(prefix), pools.GetAbortKey(prefix), services, log),
+		"systemd_update": func() error {
+			return systemd.RefreshMinioSystemdUnits(minioPath, pools.Pools, services, log)
+		},
+		"health_check": getHealthCheckAction(host, pools.Pools, services, healthConf, log),
+	}
+}
+
+/*
+Runs, in order, the actions of the update phases that were completed by the other hosts while the host was excluded from updates.
+It stops at the first phase that is not completed yet, which the host can then synchronize on.
+*/
+func catchUpPhases(cli *client.EtcdClient, phases []etcd.TaskPhase, actions map[string]etcd.TaskAction, host string, log logger.Logger) error {
+	for _, phase := range phases {
+		tk, _, tkErr := etcd.GetTask(cli, phase.Key)
+		if tkErr != nil {
+			return tkErr
+		}
+
+		if !tk.Complete {
+			return nil
+		}
+
+		if !tk.HasToDo(host) {
+			continue
+		}
+
+		log.Infof("[update] Phase %s was completed without host %s. Catching up on it", phase.Name, host)
+		err := actions[phase.Name]()
+		if errors.Is(err, etcd.ErrTaskFailed) {
+			markErr := etcd.MarkTaskFailedBySelf(cli, phase.Key, host)
+			if markErr != nil {
+				return markErr
+			}
+
+			return errors.New(fmt.Sprintf("Host %s failed phase %s while catching up on it", host, phase.Name))
+		} else if err != nil {
+			return err
+		}
+
+		markErr := etcd.MarkTaskDoneBySelf(cli, phase.Key, host)
+		if markErr != nil {
+			return markErr
+		}
 	}
 
-	log.Infof("[update] Detected ongoing server pools update. Will synchronize with other minio nodes to complete it")
+	return nil
+}
+
+func syncPoolsUpdate(cli *client.EtcdClient, confPrefix string, prefix string, minioPath string, pools *etcd.MinioServerPools, upd *etcd.PoolsUpdate, host string, services []systemd.MinioService, timeouts etcd.BarrierTimeouts, healthConf health.HealthCheckConfig, log logger.Logger) error {
+	actions := getPoolsPhaseActions(cli, confPrefix, prefix, minioPath, pools, host, services, healthConf, log)
 
 	if !upd.AcknowledgmentDone {
 		log.Debugf("[update] Synchronizing on server pools update acknowledgment")
@@ -29,12 +126,11 @@ func UpdatePools(cli *client.EtcdClient, prefix string, minioPath string, pools
 			prefix,
 			pools,
 			host,
-			func() error {
-				return nil
-			},
+			timeouts,
+			actions["acknowledgment"],
 		)
 		if err != nil {
-			return false, err
+			return err
 		}
 	}
 
@@ -45,12 +141,11 @@ func UpdatePools(cli *client.EtcdClient, prefix string, minioPath string, pools
 			prefix,
 			pools,
 			host,
-			func() error {
-				return systemd.StopMinioServices(services, log)
-			},
+			timeouts,
+			actions["minio_shutdown"],
 		)
 		if err != nil {
-			return false, err
+			return err
 		}
 	}
 
@@ -61,30 +156,257 @@ func UpdatePools(cli *client.EtcdClient, prefix string, minioPath string, pools
 			prefix,
 			pools,
 			host,
-			func() error {
-				return systemd.RefreshMinioSystemdUnits(minioPath, pools.Pools, services, log)
-			},
+			timeouts,
+			actions["systemd_update"],
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !upd.HealthCheckDone {
+		log.Debugf("[update] Synchronizing on server pools update health check")
+		err := upd.HandleNextTask(
+			cli,
+			prefix,
+			pools,
+			host,
+			timeouts,
+			actions["health_check"],
 		)
 		if err != nil {
-			return false, err
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+Returns whether the host completed the minio shutdown phase of the update, in which case minio was stopped on the host for the update.
+*/
+func hasStoppedMinio(cli *client.EtcdClient, phases []etcd.TaskPhase, host string) (bool, error) {
+	for _, phase := range phases {
+		if phase.Name != "minio_shutdown" {
+			continue
 		}
+
+		tk, _, tkErr := etcd.GetTask(cli, phase.Key)
+		if tkErr != nil {
+			return false, tkErr
+		}
+
+		return !tk.HasToDo(host), nil
+	}
+
+	return false, nil
+}
+
+func abortPoolsUpdate(cli *client.EtcdClient, prefix string, minioPath string, pools *etcd.MinioServerPools, host string, services []systemd.MinioService, sigs etcd.DocumentSignatureConfig, log logger.Logger) error {
+	log.Warnf("[update] Server pools update at version %s was aborted", pools.Version)
+
+	stopped, stoppedErr := hasStoppedMinio(cli, pools.GetTaskPhases(prefix), host)
+	if stoppedErr != nil {
+		return stoppedErr
+	}
+
+	if !stopped {
+		log.Infof("[update] Minio was not stopped yet for the aborted update. Leaving it as is")
+		return etcd.ErrUpdateAborted
+	}
+
+	applied, appliedErr := etcd.GetAppliedPools(cli, prefix, sigs)
+	if appliedErr != nil {
+		return appliedErr
+	}
+
+	if applied == nil {
+		log.Warnf("[update] No previously applied server pools configuration found. Will start minio with its current units")
+		startErr := systemd.StartMinioServices(services, log)
+		if startErr != nil {
+			return startErr
+		}
+
+		return etcd.ErrUpdateAborted
+	}
+
+	restartErr := restartWithConfiguration(minioPath, applied.Pools, services, log)
+	if restartErr != nil {
+		return restartErr
+	}
+
+	return etcd.ErrUpdateAborted
+}
+
+/*
+Restarts minio with the previously applied server pools after the server pools update failed its health check.
+Hosts added by the update stop minio, as they are not part of the previous server pools.
+ErrUpdateRolledBack is returned so that the update is recorded as rolled back, rather than retried on every restart of ferio.
+*/
+func rollbackPoolsUpdate(cli *client.EtcdClient, prefix string, minioPath string, pools *etcd.MinioServerPools, required bool, failedHosts []string, services []systemd.MinioService, sigs etcd.DocumentSignatureConfig, log logger.Logger) error {
+	log.Warnf("[update] Minio failed its health check after the update to server pools %s on hosts %s. Rolling back to the previously applied server pools", pools.Version, strings.Join(failedHosts, ", "))
+
+	if !required {
+		stopErr := systemd.StopMinioServices(services, log)
+		if stopErr != nil {
+			return stopErr
+		}
+
+		return etcd.ErrUpdateRolledBack
+	}
+
+	applied, appliedErr := etcd.GetAppliedPools(cli, prefix, sigs)
+	if appliedErr != nil {
+		return appliedErr
+	}
+
+	if applied == nil {
+		log.Warnf("[update] No previously applied server pools configuration found. Leaving minio as is")
+		return etcd.ErrUpdateRolledBack
+	}
+
+	restartErr := restartWithConfiguration(minioPath, applied.Pools, services, log)
+	if restartErr != nil {
+		return restartErr
+	}
+
+	return etcd.ErrUpdateRolledBack
+}
+
+/*
+Downloads the binary of a release, after pinning its checksum in the workspace if it has a checksum url.
+*/
+func GetReleaseBinary(cli *client.EtcdClient, prefix string, binariesDir string, dlConf binary.DownloadConfig, rel *etcd.MinioRelease, log logger.Logger) error {
+	pinErr := rel.PinChecksum(cli, prefix, func(rel *etcd.MinioRelease) (string, error) {
+		return binary.GetReleaseChecksum(rel, dlConf)
+	})
+	if pinErr != nil {
+		return pinErr
+	}
+
+	return binary.GetBinary(rel, binariesDir, dlConf, log)
+}
+
+/*
+Brings a host that is added to the cluster by the server pools update into the cluster.
+Its minio binary and units are bootstrapped right away, but minio is only started once the existing hosts updated their units.
+*/
+func joinPoolsUpdate(cli *client.EtcdClient, prefix string, binariesDir string, dlConf binary.DownloadConfig, rel *etcd.MinioRelease, pools *etcd.MinioServerPools, upd *etcd.PoolsUpdate, host string, services []systemd.MinioService, timeouts etcd.BarrierTimeouts, healthConf health.HealthCheckConfig, log logger.Logger) error {
+	log.Infof("[update] Host %s is added by the server pools update. Will join the cluster once the existing hosts updated their systemd units", host)
+
+	binErr := GetReleaseBinary(cli, prefix, binariesDir, dlConf, rel, log)
+	if binErr != nil {
+		return binErr
+	}
+
+	refrErr := systemd.RefreshMinioSystemdUnits(binary.GetMinioPathFromVersion(binariesDir, rel.Version), pools.Pools, services, log)
+	if refrErr != nil {
+		return refrErr
 	}
 
-	return true, nil
+	waitErr := upd.WaitOnPhase(cli, prefix, pools, "systemd_update", host, timeouts)
+	if waitErr != nil {
+		return waitErr
+	}
+
+	joinErr := upd.Join(cli, prefix, pools, host, getHealthCheckAction(host, pools.Pools, services, healthConf, log))
+	if joinErr != nil {
+		if errors.Is(joinErr, etcd.ErrTaskFailed) {
+			return errors.New(fmt.Sprintf("Minio failed its health check after host %s joined the cluster with server pools %s", host, pools.Version))
+		}
+
+		return joinErr
+	}
+
+	return upd.WaitOnPhase(cli, prefix, pools, "health_check", host, timeouts)
+}
+
+func getExcludedHostErr(host string) error {
+	return errors.New(fmt.Sprintf("Host %s is excluded from updates and cannot take part in them until its exclusion is lifted", host))
 }
 
-func UpdateRelease(cli *client.EtcdClient, prefix string, binariesDir string, rel *etcd.MinioRelease, pools *etcd.MinioServerPools, host string, services []systemd.MinioService, log logger.Logger) (bool, error) {
-	upd, updErr := rel.GetUpdate(cli, prefix, pools)
+func UpdatePools(cli *client.EtcdClient, confPrefix string, prefix string, binariesDir string, dlConf binary.DownloadConfig, rel *etcd.MinioRelease, pools *etcd.MinioServerPools, host string, excls etcd.HostExclusions, services []systemd.MinioService, timeouts etcd.BarrierTimeouts, healthConf health.HealthCheckConfig, sigs etcd.DocumentSignatureConfig, log logger.Logger) (bool, error) {
+	if excls.IsExcluded(host) {
+		return false, getExcludedHostErr(host)
+	}
+
+	minioPath := binary.GetMinioPathFromVersion(binariesDir, rel.Version)
+
+	upd, updErr := pools.GetUpdate(cli, prefix, excls, sigs)
 	if updErr != nil {
 		return false, updErr
 	}
 
-	if upd.IsDone() {
-		log.Debugf("[update] Release update is done. Skipping it")
-		return false, nil
+	updated := false
+	if !upd.IsRequiredHost(host) {
+		joinErr := joinPoolsUpdate(cli, prefix, binariesDir, dlConf, rel, pools, upd, host, services, timeouts, healthConf, log)
+		if joinErr != nil {
+			if errors.Is(joinErr, etcd.ErrUpdateAborted) {
+				log.Warnf("[update] Server pools update at version %s was aborted. Stopping minio as host %s is not part of the previous server pools", pools.Version, host)
+				stopErr := systemd.StopMinioServices(services, log)
+				if stopErr != nil {
+					return false, stopErr
+				}
+			}
+
+			return false, joinErr
+		}
+		updated = true
+	} else {
+		catchUpErr := catchUpPhases(cli, pools.GetTaskPhases(prefix), getPoolsPhaseActions(cli, confPrefix, prefix, minioPath, pools, host, services, healthConf, log), host, log)
+		if catchUpErr != nil {
+			return false, catchUpErr
+		}
+
+		if upd.IsDone() {
+			log.Debugf("[update] Server pools update is done. Skipping it")
+		} else {
+			log.Infof("[update] Detected ongoing server pools update. Will synchronize with other minio nodes to complete it")
+
+			syncErr := syncPoolsUpdate(cli, confPrefix, prefix, minioPath, pools, upd, host, services, timeouts, healthConf, log)
+			if syncErr != nil {
+				if errors.Is(syncErr, etcd.ErrUpdateAborted) {
+					return false, abortPoolsUpdate(cli, prefix, minioPath, pools, host, services, sigs, log)
+				}
+
+				return false, syncErr
+			}
+			updated = true
+		}
+	}
+
+	tk, _, tkErr := etcd.GetTask(cli, pools.GetHealthCheckKey(prefix))
+	if tkErr != nil {
+		return false, tkErr
 	}
 
-	log.Infof("[update] Detected ongoing minio release update. Will synchronize with other minio nodes to complete it")
+	if len(tk.Failed) > 0 {
+		return false, rollbackPoolsUpdate(cli, prefix, minioPath, pools, upd.IsRequiredHost(host), tk.Failed, services, sigs, log)
+	}
+
+	return updated, nil
+}
+
+func getReleasePhaseActions(cli *client.EtcdClient, confPrefix string, prefix string, binariesDir string, dlConf binary.DownloadConfig, rel *etcd.MinioRelease, pools *etcd.MinioServerPools, host string, services []systemd.MinioService, healthConf health.HealthCheckConfig, log logger.Logger) map[string]etcd.TaskAction {
+	return map[string]etcd.TaskAction{
+		"binary_download": func() error {
+			binErr := GetReleaseBinary(cli, prefix, binariesDir, dlConf, rel, log)
+			if binErr != nil {
+				return binErr
+			}
+
+			return etcd.WaitOnMaintenanceWindow(cli, confPrefix, rel.GetAbortKey(prefix), log)
+		},
+		"minio_shutdown": getShutdownAction(cli, confPrefix, rel.GetWindowKey(prefix), rel.GetAbortKey(prefix), services, log),
+		"systemd_update": func() error {
+			return systemd.RefreshMinioSystemdUnits(binary.GetMinioPathFromVersion(binariesDir, rel.Version), pools.Pools, services, log)
+		},
+		"health_check": getHealthCheckAction(host, pools.Pools, services, healthConf, log),
+	}
+}
+
+func syncReleaseUpdate(cli *client.EtcdClient, confPrefix string, prefix string, binariesDir string, dlConf binary.DownloadConfig, rel *etcd.MinioRelease, pools *etcd.MinioServerPools, upd *etcd.ReleaseUpdate, host string, services []systemd.MinioService, timeouts etcd.BarrierTimeouts, healthConf health.HealthCheckConfig, log logger.Logger) error {
+	actions := getReleasePhaseActions(cli, confPrefix, prefix, binariesDir, dlConf, rel, pools, host, services, healthConf, log)
 
 	if !upd.DownloadDone {
 		log.Debugf("[update] Synchronizing on release update binary download")
@@ -94,16 +416,20 @@ func UpdateRelease(cli *client.EtcdClient, prefix string, binariesDir string, re
 			rel,
 			pools,
 			host,
-			func() error {
-				return binary.GetBinary(rel.Url, rel.Version, rel.Checksum, binariesDir, log)
-			},
+			timeouts,
+			actions["binary_download"],
 		)
 		if err != nil {
-			return false, err
+			return err
 		}
 	}
 
 	if !upd.MinioShutdownDone {
+		approvalErr := rel.WaitOnApproval(cli, prefix, log)
+		if approvalErr != nil {
+			return approvalErr
+		}
+
 		log.Debugf("[update] Synchronizing on release update minio shutdown")
 		err := upd.HandleNextTask(
 			cli,
@@ -111,12 +437,11 @@ func UpdateRelease(cli *client.EtcdClient, prefix string, binariesDir string, re
 			rel,
 			pools,
 			host,
-			func() error {
-				return systemd.StopMinioServices(services, log)
-			},
+			timeouts,
+			actions["minio_shutdown"],
 		)
 		if err != nil {
-			return false, err
+			return err
 		}
 	}
 
@@ -128,14 +453,194 @@ func UpdateRelease(cli *client.EtcdClient, prefix string, binariesDir string, re
 			rel,
 			pools,
 			host,
-			func() error {
-				return systemd.RefreshMinioSystemdUnits(binary.GetMinioPathFromVersion(binariesDir, rel.Version), pools.Pools, services, log)
-			},
+			timeouts,
+			actions["systemd_update"],
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !upd.HealthCheckDone {
+		log.Debugf("[update] Synchronizing on release update health check")
+		err := upd.HandleNextTask(
+			cli,
+			prefix,
+			rel,
+			pools,
+			host,
+			timeouts,
+			actions["health_check"],
 		)
 		if err != nil {
-			return false, err
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+Ensures the binary of the previously applied release is on the host, so that minio can be restarted on it.
+It is downloaded with its checksum and signature checks if it is missing, for example on a host that was bootstrapped on the new release or whose binaries were cleaned up.
+*/
+func getPreviousReleaseBinary(cli *client.EtcdClient, prefix string, binariesDir string, dlConf binary.DownloadConfig, rel *etcd.MinioRelease, applied *etcd.MinioRelease, log logger.Logger) error {
+	binErr := GetReleaseBinary(cli, prefix, binariesDir, dlConf, applied, log)
+	if binErr != nil {
+		return errors.New(fmt.Sprintf("Cannot revert the update to release %s as the binary of the previous release %s is not available: %s. Minio was left as is", rel.Version, applied.Version, binErr.Error()))
+	}
+
+	return nil
+}
+
+func abortReleaseUpdate(cli *client.EtcdClient, prefix string, binariesDir string, dlConf binary.DownloadConfig, rel *etcd.MinioRelease, pools *etcd.MinioServerPools, host string, services []systemd.MinioService, sigs etcd.DocumentSignatureConfig, log logger.Logger) error {
+	log.Warnf("[update] Minio release update at version %s was aborted", rel.Version)
+
+	stopped, stoppedErr := hasStoppedMinio(cli, rel.GetTaskPhases(prefix), host)
+	if stoppedErr != nil {
+		return stoppedErr
+	}
+
+	if !stopped {
+		log.Infof("[update] Minio was not stopped yet for the aborted update. Leaving it as is")
+		return etcd.ErrUpdateAborted
+	}
+
+	applied, appliedErr := etcd.GetAppliedRelease(cli, prefix, sigs)
+	if appliedErr != nil {
+		return appliedErr
+	}
+
+	if applied == nil {
+		log.Warnf("[update] No previously applied minio release found. Will start minio with its current units")
+		startErr := systemd.StartMinioServices(services, log)
+		if startErr != nil {
+			return startErr
+		}
+
+		return etcd.ErrUpdateAborted
+	}
+
+	binErr := getPreviousReleaseBinary(cli, prefix, binariesDir, dlConf, rel, applied, log)
+	if binErr != nil {
+		return binErr
+	}
+
+	restartErr := restartWithConfiguration(binary.GetMinioPathFromVersion(binariesDir, applied.Version), pools.Pools, services, log)
+	if restartErr != nil {
+		return restartErr
+	}
+
+	return etcd.ErrUpdateAborted
+}
+
+func rollbackReleaseUpdate(cli *client.EtcdClient, prefix string, binariesDir string, dlConf binary.DownloadConfig, rel *etcd.MinioRelease, pools *etcd.MinioServerPools, failedHosts []string, services []systemd.MinioService, sigs etcd.DocumentSignatureConfig, log logger.Logger) error {
+	applied, appliedErr := etcd.GetAppliedRelease(cli, prefix, sigs)
+	if appliedErr != nil {
+		return appliedErr
+	}
+
+	if applied == nil {
+		return errors.New(fmt.Sprintf("Minio failed its health check after the update to release %s on hosts %s and there is no previous release to roll back to", rel.Version, strings.Join(failedHosts, ", ")))
+	}
+
+	log.Warnf("[update] Minio failed its health check after the update to release %s on hosts %s. Rolling back to release %s", rel.Version, strings.Join(failedHosts, ", "), applied.Version)
+
+	binErr := getPreviousReleaseBinary(cli, prefix, binariesDir, dlConf, rel, applied, log)
+	if binErr != nil {
+		return binErr
+	}
+
+	restartErr := restartWithConfiguration(binary.GetMinioPathFromVersion(binariesDir, applied.Version), pools.Pools, services, log)
+	if restartErr != nil {
+		return restartErr
+	}
+
+	return etcd.ErrUpdateRolledBack
+}
+
+/*
+Waits until the hosts of the server pools completed the release update, for a host that is not part of the server pools yet.
+*/
+func waitOnReleaseUpdate(cli *client.EtcdClient, prefix string, rel *etcd.MinioRelease, upd *etcd.ReleaseUpdate, host string, log logger.Logger) error {
+	log.Infof("[update] Host %s is not part of the server pools the release update applies to. Will wait for the other hosts to complete it", host)
+
+	healthKey := rel.GetHealthCheckKey(prefix)
+	tk, _, tkErr := etcd.GetTask(cli, healthKey)
+	if tkErr != nil {
+		return tkErr
+	}
+
+	if tk.CanContinueWithHosts(upd.RequiredHosts) {
+		return nil
+	}
+
+	return etcd.WaitOnTaskCompletionWithOptions(cli, healthKey, etcd.TaskWaitOptions{
+		Hosts:          upd.RequiredHosts,
+		Host:           host,
+		AbortKey:       rel.GetAbortKey(prefix),
+		RegistryPrefix: prefix,
+	})
+}
+
+func UpdateRelease(cli *client.EtcdClient, confPrefix string, prefix string, binariesDir string, dlConf binary.DownloadConfig, rel *etcd.MinioRelease, pools *etcd.MinioServerPools, host string, excls etcd.HostExclusions, services []systemd.MinioService, timeouts etcd.BarrierTimeouts, healthConf health.HealthCheckConfig, sigs etcd.DocumentSignatureConfig, log logger.Logger) (bool, error) {
+	if excls.IsExcluded(host) {
+		return false, getExcludedHostErr(host)
+	}
+
+	upd, updErr := rel.GetUpdate(cli, prefix, pools, excls)
+	if updErr != nil {
+		return false, updErr
+	}
+
+	if _, found := pools.Pools.GetHostPool(host); !found {
+		waitErr := waitOnReleaseUpdate(cli, prefix, rel, upd, host, log)
+		if waitErr != nil {
+			return false, waitErr
+		}
+
+		tk, _, tkErr := etcd.GetTask(cli, rel.GetHealthCheckKey(prefix))
+		if tkErr != nil {
+			return false, tkErr
+		}
+
+		if len(tk.Failed) > 0 {
+			return false, etcd.ErrUpdateRolledBack
+		}
+
+		return false, nil
+	}
+
+	catchUpErr := catchUpPhases(cli, rel.GetTaskPhases(prefix), getReleasePhaseActions(cli, confPrefix, prefix, binariesDir, dlConf, rel, pools, host, services, healthConf, log), host, log)
+	if catchUpErr != nil {
+		return false, catchUpErr
+	}
+
+	updated := false
+	if upd.IsDone() {
+		log.Debugf("[update] Release update is done. Skipping it")
+	} else {
+		log.Infof("[update] Detected ongoing minio release update. Will synchronize with other minio nodes to complete it")
+
+		syncErr := syncReleaseUpdate(cli, confPrefix, prefix, binariesDir, dlConf, rel, pools, upd, host, services, timeouts, healthConf, log)
+		if syncErr != nil {
+			if errors.Is(syncErr, etcd.ErrUpdateAborted) {
+				return false, abortReleaseUpdate(cli, prefix, binariesDir, dlConf, rel, pools, host, services, sigs, log)
+			}
+
+			return false, syncErr
 		}
+		updated = true
+	}
+
+	tk, _, tkErr := etcd.GetTask(cli, rel.GetHealthCheckKey(prefix))
+	if tkErr != nil {
+		return false, tkErr
 	}
 
-	return true, nil
-}
\ No newline at end of file
+	if len(tk.Failed) > 0 {
+		return false, rollbackReleaseUpdate(cli, prefix, binariesDir, dlConf, rel, pools, tk.Failed, services, sigs, log)
+	}
+
+	return updated, nil
+}
@@ -0,0 +1,188 @@
+package version
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FORMAT_UNKNOWN = iota
+	FORMAT_DATE
+	FORMAT_SEMVER
+)
+
+const MINIO_RELEASE_TAG_PREFIX = "RELEASE."
+const MINIO_RELEASE_TAG_LAYOUT = "2006-01-02T15-04-05Z"
+
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02",
+}
+
+var semverRegex = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+type Version struct {
+	Raw    string
+	Format int
+	Date   time.Time
+	//Anything following the date of a minio release tag, like a hotfix suffix
+	DateSuffix string
+	Semver     [3]int64
+	Prerelease []string
+}
+
+func parseDate(raw string) (Version, bool) {
+	if strings.HasPrefix(raw, MINIO_RELEASE_TAG_PREFIX) {
+		tag := strings.TrimPrefix(raw, MINIO_RELEASE_TAG_PREFIX)
+		if len(tag) < len(MINIO_RELEASE_TAG_LAYOUT) {
+			return Version{}, false
+		}
+
+		date, err := time.Parse(MINIO_RELEASE_TAG_LAYOUT, tag[:len(MINIO_RELEASE_TAG_LAYOUT)])
+		if err != nil {
+			return Version{}, false
+		}
+
+		return Version{Raw: raw, Format: FORMAT_DATE, Date: date, DateSuffix: tag[len(MINIO_RELEASE_TAG_LAYOUT):]}, true
+	}
+
+	for _, layout := range dateLayouts {
+		date, err := time.Parse(layout, raw)
+		if err == nil {
+			return Version{Raw: raw, Format: FORMAT_DATE, Date: date}, true
+		}
+	}
+
+	return Version{}, false
+}
+
+func parseSemver(raw string) (Version, bool) {
+	matches := semverRegex.FindStringSubmatch(raw)
+	if matches == nil {
+		return Version{}, false
+	}
+
+	ver := Version{Raw: raw, Format: FORMAT_SEMVER}
+	for idx := 0; idx < 3; idx++ {
+		num, err := strconv.ParseInt(matches[idx+1], 10, 64)
+		if err != nil {
+			return Version{}, false
+		}
+		ver.Semver[idx] = num
+	}
+
+	if matches[4] != "" {
+		ver.Prerelease = strings.Split(matches[4], ".")
+	}
+
+	return ver, true
+}
+
+/*
+Parses a version as a date (yyyy-mm-dd or rfc3339), a minio release tag (RELEASE.yyyy-mm-ddThh-mm-ssZ) or a semantic version.
+Versions in another format are returned with an unknown format.
+*/
+func Parse(raw string) Version {
+	if ver, ok := parseDate(raw); ok {
+		return ver
+	}
+
+	if ver, ok := parseSemver(raw); ok {
+		return ver
+	}
+
+	return Version{Raw: raw, Format: FORMAT_UNKNOWN}
+}
+
+func compareInts(a int64, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+
+	return 0
+}
+
+func comparePrereleases(a []string, b []string) int {
+	if len(a) == 0 && len(b) == 0 {
+		return 0
+	} else if len(a) == 0 {
+		return 1
+	} else if len(b) == 0 {
+		return -1
+	}
+
+	for idx := 0; idx < len(a) && idx < len(b); idx++ {
+		aNum, aErr := strconv.ParseInt(a[idx], 10, 64)
+		bNum, bErr := strconv.ParseInt(b[idx], 10, 64)
+
+		cmp := 0
+		if aErr == nil && bErr == nil {
+			cmp = compareInts(aNum, bNum)
+		} else if aErr == nil {
+			cmp = -1
+		} else if bErr == nil {
+			cmp = 1
+		} else {
+			cmp = strings.Compare(a[idx], b[idx])
+		}
+
+		if cmp != 0 {
+			return cmp
+		}
+	}
+
+	return compareInts(int64(len(a)), int64(len(b)))
+}
+
+/*
+Returns -1, 0 or 1 if the first version is respectively older than, identical to or newer than the second version.
+Versions in an unknown format are compared as strings with each other.
+An error is returned if the versions are in formats that cannot be compared.
+*/
+func Compare(a string, b string) (int, error) {
+	verA := Parse(a)
+	verB := Parse(b)
+
+	if verA.Format != verB.Format {
+		return 0, errors.New(fmt.Sprintf("Versions %s and %s are in different formats and cannot be compared", a, b))
+	}
+
+	switch verA.Format {
+	case FORMAT_DATE:
+		if !verA.Date.Equal(verB.Date) {
+			if verA.Date.Before(verB.Date) {
+				return -1, nil
+			}
+			return 1, nil
+		}
+		return strings.Compare(verA.DateSuffix, verB.DateSuffix), nil
+	case FORMAT_SEMVER:
+		for idx := 0; idx < 3; idx++ {
+			cmp := compareInts(verA.Semver[idx], verB.Semver[idx])
+			if cmp != 0 {
+				return cmp, nil
+			}
+		}
+		return comparePrereleases(verA.Prerelease, verB.Prerelease), nil
+	default:
+		return strings.Compare(a, b), nil
+	}
+}
+
+/*
+Returns whether the first version is strictly newer than the second version.
+*/
+func IsGreater(a string, b string) (bool, error) {
+	cmp, err := Compare(a, b)
+	if err != nil {
+		return false, err
+	}
+
+	return cmp > 0, nil
+}
@@ -0,0 +1,44 @@
+package version
+
+import (
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	for _, tc := range []struct {
+		a        string
+		b        string
+		expected int
+	}{
+		{"2024-01-02", "2024-01-01", 1},
+		{"2023-12-31", "2024-01-01", -1},
+		{"2024-01-01", "2024-01-01", 0},
+		{"2024-01-01T10:00:00Z", "2024-01-01", 1},
+		{"RELEASE.2024-05-01T01-11-10Z", "RELEASE.2024-04-18T19-09-19Z", 1},
+		{"RELEASE.2024-04-18T19-09-19Z", "RELEASE.2024-05-01T01-11-10Z", -1},
+		{"RELEASE.2024-05-01T01-11-10Z.hotfix.a1b2c3", "RELEASE.2024-05-01T01-11-10Z", 1},
+		{"RELEASE.2024-05-01T01-11-10Z", "2024-04-30", 1},
+		{"1.10.0", "1.9.0", 1},
+		{"v2.0.0", "1.99.99", 1},
+		{"1.0.0", "1.0.0-rc.1", 1},
+		{"1.0.0-rc.2", "1.0.0-rc.10", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.beta", "1.0.0-alpha.1", 1},
+		{"1.0.0+build.2", "1.0.0+build.1", 0},
+		{"b", "a", 1},
+	} {
+		cmp, err := Compare(tc.a, tc.b)
+		if err != nil {
+			t.Errorf("Error occured comparing %s and %s: %s", tc.a, tc.b, err.Error())
+		}
+
+		if cmp != tc.expected {
+			t.Errorf("Expected comparison of %s and %s to return %d and it returned %d", tc.a, tc.b, tc.expected, cmp)
+		}
+	}
+
+	_, err := Compare("1.0.0", "2024-01-01")
+	if err == nil {
+		t.Errorf("Expected comparison of versions in different formats to fail and it didn't")
+	}
+}